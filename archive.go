@@ -0,0 +1,132 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Archive is a struct representing the (normalized) structure of an Instagram media.json file. It is a
+// superset of `go-sfomuseum-instagram/media.Archive` with support for carousel (multi-media) posts.
+type Archive struct {
+	// Photos is the list of photos (posts) in an archive.
+	Photos []*Photo `json:"photos"`
+}
+
+// Photo is a struct containing data associated with an Instagram post. It is a superset of
+// `go-sfomuseum-instagram/media.Photo` with support for carousel (multi-media) posts.
+type Photo struct {
+	// Caption is the caption associated with the post
+	Caption string `json:"caption"`
+	// TakenAt is the datetime string when the post was published
+	TakenAt string `json:"taken_at"`
+	// CreationTimestamp is the Unix timestamp when the post was published. It is only present for posts derived
+	// from Instagram "posts" JSON files.
+	CreationTimestamp int64  `json:"creation_timestamp,omitempty"`
+	Location          string `json:"location,omitempty"`
+	// Path is the relative URI for the (first) media element associated with the post
+	Path    string `json:"path"`
+	MediaId string `json:"media_id,omitempty"`
+	// Media is the ordered list of media elements for carousel posts. It is empty for posts with a single media element.
+	Media []*PhotoMedia `json:"media,omitempty"`
+}
+
+// PhotoMedia is a struct containing data associated with an individual media element (slide) in a carousel post.
+type PhotoMedia struct {
+	// Path is the relative URI for the media element
+	Path string `json:"path"`
+}
+
+// WalkArchiveCallbackFunc is a function to invoke for each (JSON-encoded) `Photo` in an `Archive`.
+type WalkArchiveCallbackFunc func(context.Context, []byte) error
+
+// DEFAULT_WORKERS is the default number of posts processed concurrently by `WalkArchiveWithCallback`.
+const DEFAULT_WORKERS int = 10
+
+// WalkArchiveWithCallback decodes the (normalized) `Archive` contained in 'r' and invokes 'cb' for each
+// post using `DEFAULT_WORKERS` workers. It returns the first error encountered.
+func WalkArchiveWithCallback(ctx context.Context, r io.Reader, cb WalkArchiveCallbackFunc) error {
+	return WalkArchiveWithWorkers(ctx, r, DEFAULT_WORKERS, cb)
+}
+
+// WalkArchiveWithWorkers decodes the (normalized) `Archive` contained in 'r' and invokes 'cb' for each post
+// using a pool of 'workers' goroutines, so that the number of posts being processed at any one time (and the
+// memory they use) is bounded. It stops and returns the first error encountered.
+func WalkArchiveWithWorkers(ctx context.Context, r io.Reader, workers int, cb WalkArchiveCallbackFunc) error {
+
+	if workers < 1 {
+		return fmt.Errorf("Invalid number of workers (%d)", workers)
+	}
+
+	var archive Archive
+
+	dec := json.NewDecoder(r)
+	err := dec.Decode(&archive)
+
+	if err != nil {
+		return fmt.Errorf("Failed to decode archive, %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	posts_ch := make(chan []byte)
+	err_ch := make(chan error, workers+1)
+
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < workers; i++ {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			for body := range posts_ch {
+
+				err := cb(ctx, body)
+
+				if err != nil {
+					err_ch <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, ph := range archive.Photos {
+
+		ph_body, err := json.Marshal(ph)
+
+		if err != nil {
+			err_ch <- fmt.Errorf("Failed to marshal photo, %w", err)
+			cancel()
+			break
+		}
+
+		select {
+		case posts_ch <- ph_body:
+			// pass
+		case <-ctx.Done():
+			break feed
+		}
+	}
+
+	close(posts_ch)
+
+	wg.Wait()
+	close(err_ch)
+
+	err, ok := <-err_ch
+
+	if ok {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package publish
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// LookupConflictType is a string label describing the kind of problem found while building a lookup.
+type LookupConflictType string
+
+// CONFLICT_MEDIA_ID indicates that two or more WOF records derive the same media ID.
+const CONFLICT_MEDIA_ID LookupConflictType = "media_id"
+
+// CONFLICT_PATH indicates that the same media path is mapped to two or more WOF records.
+const CONFLICT_PATH LookupConflictType = "path"
+
+// CONFLICT_MISSING_HASH indicates that a WOF record has no perceptual, file or video hash. It is only added to the
+// lookup using its media path.
+const CONFLICT_MISSING_HASH LookupConflictType = "missing_hash"
+
+// LookupConflict is a struct describing a single problem found while building a lookup.
+type LookupConflict struct {
+	// Type is the kind of problem.
+	Type LookupConflictType `json:"type"`
+	// Key is the media ID or media path that the problem is associated with. It is empty for `CONFLICT_MISSING_HASH` problems.
+	Key string `json:"key,omitempty"`
+	// WOFIds is the sorted list of WOF IDs involved in the problem.
+	WOFIds []int64 `json:"wof_ids"`
+	// Paths is the sorted list of paths (in the data repository) of the records involved in the problem.
+	Paths []string `json:"paths"`
+}
+
+// LookupAudit is a thread-safe collection of problems encountered while building a lookup. Rather than
+// silently overwriting (or failing on) duplicate keys, `PopulateLookupWithOptions` will record them in a
+// `LookupAudit` instance if one is present.
+type LookupAudit struct {
+	mu      *sync.RWMutex
+	keys    map[LookupConflictType]map[string]map[int64]string
+	missing map[int64]string
+}
+
+// NewLookupAudit returns a new (empty) `LookupAudit` instance.
+func NewLookupAudit() *LookupAudit {
+
+	keys := map[LookupConflictType]map[string]map[int64]string{
+		CONFLICT_MEDIA_ID: make(map[string]map[int64]string),
+		CONFLICT_PATH:     make(map[string]map[int64]string),
+	}
+
+	a := &LookupAudit{
+		mu:      new(sync.RWMutex),
+		keys:    keys,
+		missing: make(map[int64]string),
+	}
+
+	return a
+}
+
+// AddKey records that the WOF record 'wof_id' (at 'path') maps to 'key' for the conflict type 't'.
+func (a *LookupAudit) AddKey(t LookupConflictType, key string, wof_id int64, path string) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ids, exists := a.keys[t][key]
+
+	if !exists {
+		ids = make(map[int64]string)
+		a.keys[t][key] = ids
+	}
+
+	ids[wof_id] = path
+}
+
+// AddMissingHash records that the WOF record 'wof_id' (at 'path') is missing a perceptual hash.
+func (a *LookupAudit) AddMissingHash(wof_id int64, path string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.missing[wof_id] = path
+}
+
+// Conflicts returns the list of problems recorded in 'a' sorted by type and key.
+func (a *LookupAudit) Conflicts() []*LookupConflict {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	conflicts := make([]*LookupConflict, 0)
+
+	for t, keys := range a.keys {
+
+		for k, ids := range keys {
+
+			if len(ids) < 2 {
+				continue
+			}
+
+			c := newLookupConflict(t, k, ids)
+			conflicts = append(conflicts, c)
+		}
+	}
+
+	for id, path := range a.missing {
+		c := newLookupConflict(CONFLICT_MISSING_HASH, "", map[int64]string{id: path})
+		conflicts = append(conflicts, c)
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+
+		if conflicts[i].Type != conflicts[j].Type {
+			return conflicts[i].Type < conflicts[j].Type
+		}
+
+		if conflicts[i].Key != conflicts[j].Key {
+			return conflicts[i].Key < conflicts[j].Key
+		}
+
+		return conflicts[i].WOFIds[0] < conflicts[j].WOFIds[0]
+	})
+
+	return conflicts
+}
+
+// WriteJSONLines writes each `LookupConflict` in 'a' to 'wr' as a line-separated JSON record.
+func (a *LookupAudit) WriteJSONLines(wr io.Writer) error {
+
+	enc := json.NewEncoder(wr)
+
+	for _, c := range a.Conflicts() {
+
+		err := enc.Encode(c)
+
+		if err != nil {
+			return fmt.Errorf("Failed to encode conflict, %w", err)
+		}
+	}
+
+	return nil
+}
+
+// WriteSummary writes a table summarizing the number of problems, by type, in 'a' to 'wr'.
+func (a *LookupAudit) WriteSummary(wr io.Writer) error {
+
+	counts := map[LookupConflictType]int{
+		CONFLICT_MEDIA_ID:     0,
+		CONFLICT_PATH:         0,
+		CONFLICT_MISSING_HASH: 0,
+	}
+
+	for _, c := range a.Conflicts() {
+		counts[c.Type] += 1
+	}
+
+	tw := tabwriter.NewWriter(wr, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "CONFLICT\tCOUNT\n")
+
+	total := 0
+
+	for _, t := range []LookupConflictType{CONFLICT_MEDIA_ID, CONFLICT_PATH, CONFLICT_MISSING_HASH} {
+		fmt.Fprintf(tw, "%s\t%d\n", t, counts[t])
+		total += counts[t]
+	}
+
+	fmt.Fprintf(tw, "total\t%d\n", total)
+
+	return tw.Flush()
+}
+
+func newLookupConflict(t LookupConflictType, key string, ids map[int64]string) *LookupConflict {
+
+	wof_ids := make([]int64, 0)
+
+	for id := range ids {
+		wof_ids = append(wof_ids, id)
+	}
+
+	sort.Slice(wof_ids, func(i, j int) bool {
+		return wof_ids[i] < wof_ids[j]
+	})
+
+	paths := make([]string, len(wof_ids))
+
+	for i, id := range wof_ids {
+		paths[i] = ids[id]
+	}
+
+	c := &LookupConflict{
+		Type:   t,
+		Key:    key,
+		WOFIds: wof_ids,
+		Paths:  paths,
+	}
+
+	return c
+}
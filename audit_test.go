@@ -0,0 +1,132 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLookupAudit(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo := t.TempDir()
+
+	// Records 1 and 2 derive the same media ID and share a media path; record 3 has no hash at all. Records 4 and 5
+	// (for example videos) only have a file hash or a video hash which is not a problem.
+
+	records := map[string]string{
+		"1.geojson": `{"type":"Feature","properties":{"wof:id":1,"instagram:post":{"media_id":"media/posts/a.jpg","perceptual_hash":"p:b867679231ccc633","taken_at":"Nov 26, 2024 4:00 PM"}}}`,
+		"2.geojson": `{"type":"Feature","properties":{"wof:id":2,"instagram:post":{"media_id":"media/posts/a.jpg","perceptual_hash":"p:b867679231ccc633","taken_at":"Nov 26, 2024 4:00 PM"}}}`,
+		"3.geojson": `{"type":"Feature","properties":{"wof:id":3,"instagram:post":{"media_id":"media/posts/c.jpg","taken_at":"Nov 26, 2024 4:00 PM"}}}`,
+		"4.geojson": `{"type":"Feature","properties":{"wof:id":4,"instagram:post":{"media_id":"media/posts/d.mp4","file_hash":"4d2f1a","taken_at":"Nov 26, 2024 4:00 PM"}}}`,
+		"5.geojson": `{"type":"Feature","properties":{"wof:id":5,"instagram:post":{"media_id":"media/posts/e.mp4","video_hash":"p:b867679231ccc633","taken_at":"Nov 26, 2024 4:00 PM"}}}`,
+	}
+
+	for fname, body := range records {
+
+		err := os.WriteFile(filepath.Join(repo, fname), []byte(body), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write record, %v", err)
+		}
+	}
+
+	media_id, err := DeriveMediaId([]byte(records["1.geojson"]), "properties.instagram:post")
+
+	if err != nil {
+		t.Fatalf("Failed to derive media ID, %v", err)
+	}
+
+	audit := NewLookupAudit()
+
+	populate_opts := &PopulateLookupOptions{
+		Lookup:         NewMemoryLookup(),
+		Audit:          audit,
+		IteratorURI:    "directory://",
+		IteratorSource: repo,
+	}
+
+	err = PopulateLookupWithOptions(ctx, populate_opts)
+
+	if err != nil {
+		t.Fatalf("Failed to populate lookup, %v", err)
+	}
+
+	expected := []*LookupConflict{
+		{Type: CONFLICT_MEDIA_ID, Key: media_id, WOFIds: []int64{1, 2}},
+		{Type: CONFLICT_MISSING_HASH, WOFIds: []int64{3}},
+		{Type: CONFLICT_PATH, Key: "media/posts/a.jpg", WOFIds: []int64{1, 2}},
+	}
+
+	conflicts := audit.Conflicts()
+
+	if len(conflicts) != len(expected) {
+		t.Fatalf("Expected %d conflicts, got %d", len(expected), len(conflicts))
+	}
+
+	for i, c := range conflicts {
+
+		e := expected[i]
+
+		if c.Type != e.Type || c.Key != e.Key || len(c.WOFIds) != len(e.WOFIds) || len(c.Paths) != len(e.WOFIds) {
+			t.Fatalf("Unexpected conflict at offset %d: %s %s %v", i, c.Type, c.Key, c.WOFIds)
+		}
+
+		for j, id := range e.WOFIds {
+
+			if c.WOFIds[j] != id {
+				t.Fatalf("Unexpected WOF IDs for conflict at offset %d: %v", i, c.WOFIds)
+			}
+
+			if c.Paths[j] != filepath.Join(repo, fmt.Sprintf("%d.geojson", id)) {
+				t.Fatalf("Unexpected path for conflict at offset %d: %s", i, c.Paths[j])
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+
+	err = audit.WriteJSONLines(&buf)
+
+	if err != nil {
+		t.Fatalf("Failed to write JSON lines, %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d", len(expected), len(lines))
+	}
+
+	var c LookupConflict
+
+	err = json.Unmarshal([]byte(lines[2]), &c)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal conflict, %v", err)
+	}
+
+	if c.Type != CONFLICT_PATH || c.Key != "media/posts/a.jpg" {
+		t.Fatalf("Unexpected conflict: %s", lines[2])
+	}
+
+	buf.Reset()
+
+	err = audit.WriteSummary(&buf)
+
+	if err != nil {
+		t.Fatalf("Failed to write summary, %v", err)
+	}
+
+	summary := strings.Join(strings.Fields(buf.String()), " ")
+
+	if summary != "CONFLICT COUNT media_id 1 path 1 missing_hash 1 total 3" {
+		t.Fatalf("Unexpected summary: %s", buf.String())
+	}
+}
@@ -0,0 +1,121 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry is a struct recording a single WOF record written by a publish run.
+type AuditEntry struct {
+	// Time is the Unix timestamp when the record was written.
+	Time int64 `json:"time"`
+	// WOFId is the WOF ID of the record that was written.
+	WOFId int64 `json:"wof_id"`
+	// Action is whether the record was new or updated.
+	Action Action `json:"action"`
+	// MediaId is the SFO Museum media ID of the Instagram post.
+	MediaId string `json:"media_id"`
+	// Path is the (relative) media path of the Instagram post.
+	Path string `json:"path"`
+	// Bundle is the name of the Instagram export bundle the post was read from, if known.
+	Bundle string `json:"bundle,omitempty"`
+	// PreviousLastModified is the `wof:lastmodified` property of the record before it was updated. It is zero for new records.
+	PreviousLastModified int64 `json:"previous_lastmodified,omitempty"`
+	// LastModified is the `wof:lastmodified` property of the record that was written.
+	LastModified int64 `json:"lastmodified"`
+	// Commit is the git commit (HEAD) of the data repository when the run started, if known.
+	Commit string `json:"commit,omitempty"`
+}
+
+// AuditLog is an append-only, line-separated JSON log of every WOF record written by publish runs, used to trace a
+// record back to the export that produced it. Unlike a `Journal` entries are never replaced or read back.
+type AuditLog struct {
+	mu   *sync.Mutex
+	path string
+	fh   *os.File
+}
+
+// OpenAuditLog returns a new `AuditLog` instance for the file at 'path', which will be created if it does not exist.
+// New entries are appended to any existing entries.
+func OpenAuditLog(ctx context.Context, path string) (*AuditLog, error) {
+
+	body, err := os.ReadFile(path)
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("Failed to read %s, %w", path, err)
+	}
+
+	fh, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s for writing, %w", path, err)
+	}
+
+	// Make sure new entries aren't appended to a truncated final line
+
+	if len(body) > 0 && !bytes.HasSuffix(body, []byte("\n")) {
+
+		_, err := fh.Write([]byte("\n"))
+
+		if err != nil {
+			fh.Close()
+			return nil, fmt.Errorf("Failed to write to %s, %w", path, err)
+		}
+	}
+
+	l := &AuditLog{
+		mu:   new(sync.Mutex),
+		path: path,
+		fh:   fh,
+	}
+
+	return l, nil
+}
+
+// Record appends 'e' to the audit log. If 'e.Time' is zero it is assigned the current time.
+func (l *AuditLog) Record(ctx context.Context, e *AuditEntry) error {
+
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+
+	enc, err := json.Marshal(e)
+
+	if err != nil {
+		return fmt.Errorf("Failed to marshal audit entry, %w", err)
+	}
+
+	enc = append(enc, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.fh.Write(enc)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write audit entry, %w", err)
+	}
+
+	return nil
+}
+
+// Close flushes and closes the audit log file.
+func (l *AuditLog) Close() error {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.fh.Sync()
+
+	if err != nil {
+		return fmt.Errorf("Failed to sync %s, %w", l.path, err)
+	}
+
+	return l.fh.Close()
+}
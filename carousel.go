@@ -0,0 +1,67 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// IsCarousel returns a boolean value indicating whether the Instagram post in 'body' is a carousel post
+// (one with more than one element in its `media` property).
+func IsCarousel(body []byte) bool {
+	return len(gjson.GetBytes(body, "media").Array()) > 1
+}
+
+// AppendCarouselHashes will append the properties assigned by `AppendMediaTypeAndHashes` ("file_hash" or
+// "perceptual_hash", "video_hash", "media_type" and "mime_type") to each element of the `media` property in 'body'.
+// The properties of the first element (the cover) are also assigned to the post itself.
+func AppendCarouselHashes(ctx context.Context, opts *AppendMediaOptions, body []byte) ([]byte, error) {
+
+	media_rsp := gjson.GetBytes(body, "media")
+
+	for i, m := range media_rsp.Array() {
+
+		rel_path := m.Get("path").String()
+
+		slide_body, err := AppendMediaTypeAndHashes(ctx, opts, rel_path, []byte(`{}`))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to append hashes for media element %d (%s), %w", i, rel_path, err)
+		}
+
+		updates := make(map[string]interface{})
+
+		for _, k := range []string{"media_type", "mime_type", "file_hash", "perceptual_hash", "video_hash"} {
+
+			rsp := gjson.GetBytes(slide_body, k)
+
+			if rsp.Exists() {
+				updates[k] = rsp.String()
+			}
+		}
+
+		for k, v := range updates {
+
+			path := fmt.Sprintf("media.%d.%s", i, k)
+
+			body, err = sjson.SetBytes(body, path, v)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to assign %s, %w", path, err)
+			}
+
+			if i == 0 {
+
+				body, err = sjson.SetBytes(body, k, v)
+
+				if err != nil {
+					return nil, fmt.Errorf("Failed to assign %s, %w", k, err)
+				}
+			}
+		}
+	}
+
+	return body, nil
+}
@@ -3,7 +3,8 @@
 // the corresponding "_o.jpg" image from the `sfomuseum-media` S3 bucket and use it to derive a new perceptual
 // hash and then update the record accordingly. This was originally written to "backfill" properties and shouldn't
 // be necessary going forward (hash are appended in publish/publish.go) but the tool is being kept around in
-// case it's ever needed again.
+// case it's ever needed again. New backfills should be written as migrations for the migrate tool (see the
+// perceptual_hash migration).
 package main
 
 import (
@@ -12,10 +13,10 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"path/filepath"
 
 	_ "github.com/aaronland/gocloud-blob/s3"
 
+	"github.com/sfomuseum/go-sfomuseum-instagram-publish"
 	"github.com/sfomuseum/go-sfomuseum-instagram-publish/secret"
 	"github.com/sfomuseum/go-sfomuseum-instagram/hash"
 	sfom_writer "github.com/sfomuseum/go-sfomuseum-writer/v3"
@@ -35,6 +36,9 @@ func main() {
 
 	writer_uri := flag.String("writer-uri", "repo:///usr/local/data/sfomuseum-data-socialmedia-instagram", "...")
 
+	max_media_reads := flag.Int("max-media-reads", 10, "The maximum number of concurrent reads from the media bucket. If 0 concurrent reads are not limited.")
+	media_reads_per_second := flag.Float64("media-reads-per-second", 0, "The maximum number of reads from the media bucket to start per second. If 0 reads are not rate limited.")
+
 	flag.Parse()
 
 	ctx := context.Background()
@@ -51,6 +55,8 @@ func main() {
 		log.Fatalf("Failed to open media bucket, %v", err)
 	}
 
+	media_limiter := publish.NewLimiter(*max_media_reads, *media_reads_per_second)
+
 	iter_cb := func(ctx context.Context, path string, r io.ReadSeeker, args ...interface{}) error {
 
 		body, err := io.ReadAll(r)
@@ -74,22 +80,29 @@ func main() {
 		media_id := id_rsp.String()
 		media_secret := secret.DeriveSecret(media_id)
 
-		im_fname := fmt.Sprintf("%s_%s_o.jpg", media_id, media_secret)
-		im_path := filepath.Join(media_id, im_fname)
+		im_path := publish.DerivativeKey(media_id, publish.SIZE_ORIGINAL, "jpg")
+
+		release, err := media_limiter.Acquire(ctx)
+
+		if err != nil {
+			return err
+		}
 
 		im_r, err := media_bucket.NewReader(ctx, im_path, nil)
 
 		// TBD: Mark as deprecated?
 
 		if err != nil {
+			release()
 			log.Printf("Failed to open %s, %v", im_path, err)
 			return nil
 		}
 
-		defer im_r.Close()
-
 		phash, err := hash.PerceptualHash(im_r)
 
+		im_r.Close()
+		release()
+
 		if err != nil {
 			return fmt.Errorf("Failed to generate perceptual hash for %s (%s %s), %w", im_path, media_id, media_secret, err)
 		}
@@ -0,0 +1,61 @@
+// find-duplicates is a command line tool to report clusters of records in the sfomuseum-data-socialmedia-instagram
+// repository which are likely to be duplicates of one another, typically created when Instagram re-encoded an image
+// between exports causing its perceptual hash (and the media ID derived from it) to change. Records are grouped if
+// their perceptual (or video perceptual) hashes are within the `-threshold` Hamming distance of one another and,
+// optionally, if they were taken within `-time-window` of one another. Superseded records are skipped, as are records
+// without a taken time if `-time-window` is set. Clusters are emitted as line-separated JSON to STDOUT, for human
+// review, followed by a summary table (to STDERR). For example:
+//
+//	$> ./bin/find-duplicates -iterator-source /usr/local/data/sfomuseum-data-socialmedia-instagram -threshold 6 -time-window 24h
+//	{"records":[{"wof_id":1729355023,"path":"...","media_id":"8b1f...","hash":"p:b867679231ccc633","caption":"...","taken_at":"...","taken":1600000000},...],"pairs":[{"a":1729355023,"b":1729355025,"distance":2}]}
+//	DUPLICATES  COUNT
+//	clusters    1
+//	records     2
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/sfomuseum/go-sfomuseum-instagram-publish"
+)
+
+func main() {
+
+	iterator_uri := flag.String("iterator-uri", "repo://", "A valid whosonfirst/go-whosonfirst-iterate/v2 URI")
+	iterator_source := flag.String("iterator-source", "/usr/local/data/sfomuseum-data-socialmedia-instagram", "...")
+
+	threshold := flag.Int("threshold", 6, "The maximum Hamming distance between perceptual hashes for two records to be considered duplicates.")
+	time_window := flag.Duration("time-window", 0, "The optional maximum difference between the times two posts were taken for them to be considered duplicates. If 0 the times posts were taken are not compared. Otherwise records without a taken time are skipped.")
+
+	flag.Parse()
+
+	ctx := context.Background()
+
+	opts := &publish.FindDuplicatesOptions{
+		IteratorURI:    *iterator_uri,
+		IteratorSource: *iterator_source,
+		Threshold:      *threshold,
+		TimeWindow:     *time_window,
+	}
+
+	clusters, err := publish.FindDuplicates(ctx, opts)
+
+	if err != nil {
+		log.Fatalf("Failed to find duplicates, %v", err)
+	}
+
+	err = publish.WriteDuplicateClustersJSONLines(os.Stdout, clusters)
+
+	if err != nil {
+		log.Fatalf("Failed to write clusters, %v", err)
+	}
+
+	err = publish.WriteDuplicateClustersSummary(os.Stderr, clusters)
+
+	if err != nil {
+		log.Fatalf("Failed to write summary, %v", err)
+	}
+}
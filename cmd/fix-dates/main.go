@@ -1,4 +1,6 @@
-// one-off backfill script but leaving around for historical purposes.
+// one-off backfill script but leaving around for historical purposes. Going forward timezones are handled by the
+// publish tool itself (see the -timezone flag and `publish.AppendTakenTimestamps`) and existing records can be
+// updated using the taken_timestamps migration in the migrate tool.
 package main
 
 import (
@@ -0,0 +1,66 @@
+// lookup-audit is a command line tool to report problems in the sfomuseum-data-socialmedia-instagram repository
+// that would otherwise be silently ignored (or cause an error) while building the media ID lookup table used by
+// the publish tool. Specifically: two or more records deriving the same media ID, the same media path being mapped
+// to two or more records and records without a perceptual, file or video hash. Problems are emitted as line-separated
+// JSON to STDOUT followed by a summary table (to STDERR). For example:
+//
+//	$> ./bin/lookup-audit -iterator-source /usr/local/data/sfomuseum-data-socialmedia-instagram
+//	{"type":"media_id","key":"8b1f...","wof_ids":[1729355023,1729355025],"paths":["..."]}
+//	CONFLICT      COUNT
+//	media_id      1
+//	path          0
+//	missing_hash  0
+//	total         1
+//
+// The tool will exit with a non-zero status code if any problems are found.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/sfomuseum/go-sfomuseum-instagram-publish"
+)
+
+func main() {
+
+	iterator_uri := flag.String("iterator-uri", "repo://", "A valid whosonfirst/go-whosonfirst-iterate/v2 URI")
+	iterator_source := flag.String("iterator-source", "/usr/local/data/sfomuseum-data-socialmedia-instagram", "...")
+
+	flag.Parse()
+
+	ctx := context.Background()
+
+	audit := publish.NewLookupAudit()
+
+	populate_opts := &publish.PopulateLookupOptions{
+		Lookup:         publish.NewMemoryLookup(),
+		Audit:          audit,
+		IteratorURI:    *iterator_uri,
+		IteratorSource: *iterator_source,
+	}
+
+	err := publish.PopulateLookupWithOptions(ctx, populate_opts)
+
+	if err != nil {
+		log.Fatalf("Failed to build lookup, %v", err)
+	}
+
+	err = audit.WriteJSONLines(os.Stdout)
+
+	if err != nil {
+		log.Fatalf("Failed to write conflicts, %v", err)
+	}
+
+	err = audit.WriteSummary(os.Stderr)
+
+	if err != nil {
+		log.Fatalf("Failed to write summary, %v", err)
+	}
+
+	if len(audit.Conflicts()) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,162 @@
+// merge-duplicates is a command line tool to merge duplicate records in the sfomuseum-data-socialmedia-instagram
+// repository in to a single canonical record. The media IDs, media paths and perceptual hashes of the other records
+// are folded in to the canonical record's `instagram:historical_media_ids`, `instagram:historical_paths` and
+// `instagram:historical_hashes` properties (so that future exports continue to match it) and the other records are
+// marked as deprecated and superseded by it. Records may be specified as WOF IDs, in which case the `-canonical` flag
+// may be used to pick the canonical record, or as the (reviewed) line-separated JSON output of the find-duplicates
+// tool using the `-clusters` flag. If no canonical record is specified the one with the lowest WOF ID that has not
+// been superseded or deprecated is chosen. A canonical record that has been superseded or deprecated is an error.
+// The outcome of each merge, including property-level changes, is emitted as line-separated JSON to STDOUT. For example:
+//
+//	$> ./bin/merge-duplicates -dry-run 1729355023 1729355025
+//	{"canonical":1729355023,"superseded":[1729355025],"changes":{...}}
+//
+//	$> ./bin/find-duplicates -threshold 6 -time-window 24h > clusters.jsonl
+//	# Review clusters.jsonl and remove any false positives
+//	$> ./bin/merge-duplicates -clusters clusters.jsonl
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/sfomuseum/go-sfomuseum-instagram-publish"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-writer/v3"
+)
+
+func main() {
+
+	reader_uri := flag.String("reader-uri", "repo:///usr/local/data/sfomuseum-data-socialmedia-instagram", "A valid whosonfirst/go-reader URI")
+	writer_uri := flag.String("writer-uri", "repo:///usr/local/data/sfomuseum-data-socialmedia-instagram", "A valid whosonfirst/go-writer URI")
+
+	canonical := flag.Int64("canonical", 0, "The optional WOF ID of the record the other records (passed as arguments) will be merged in to. If 0 the record with the lowest WOF ID that has not been superseded or deprecated is chosen.")
+	clusters_path := flag.String("clusters", "", "The optional path to line-separated JSON output of the find-duplicates tool. Each cluster will be merged. If \"-\" clusters are read from STDIN.")
+
+	dry_run := flag.Bool("dry-run", false, "Merge records and report the changes but do not write them.")
+
+	flag.Parse()
+
+	ctx := context.Background()
+
+	groups := make([][]int64, 0)
+
+	switch {
+	case *clusters_path != "" && len(flag.Args()) > 0:
+		log.Fatalf("-clusters can not be used with WOF IDs")
+	case *clusters_path != "" && *canonical != 0:
+		log.Fatalf("-clusters can not be used with -canonical")
+	case *clusters_path != "":
+
+		var r io.Reader
+
+		if *clusters_path == "-" {
+			r = os.Stdin
+		} else {
+
+			fh, err := os.Open(*clusters_path)
+
+			if err != nil {
+				log.Fatalf("Failed to open %s, %v", *clusters_path, err)
+			}
+
+			defer fh.Close()
+			r = fh
+		}
+
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+
+		for scanner.Scan() {
+
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+
+			var c publish.DuplicateCluster
+
+			err := json.Unmarshal(scanner.Bytes(), &c)
+
+			if err != nil {
+				log.Fatalf("Failed to parse cluster, %v", err)
+			}
+
+			ids := make([]int64, len(c.Records))
+
+			for i, rec := range c.Records {
+				ids[i] = rec.WOFId
+			}
+
+			groups = append(groups, ids)
+		}
+
+		err := scanner.Err()
+
+		if err != nil {
+			log.Fatalf("Failed to read clusters, %v", err)
+		}
+
+	default:
+
+		ids := make([]int64, 0)
+
+		for _, str_id := range flag.Args() {
+
+			id, err := strconv.ParseInt(str_id, 10, 64)
+
+			if err != nil {
+				log.Fatalf("Invalid WOF ID '%s', %v", str_id, err)
+			}
+
+			ids = append(ids, id)
+		}
+
+		groups = append(groups, ids)
+	}
+
+	rdr, err := reader.NewReader(ctx, *reader_uri)
+
+	if err != nil {
+		log.Fatalf("Failed to create reader, %v", err)
+	}
+
+	merge_opts := &publish.MergeOptions{
+		Reader:    rdr,
+		Canonical: *canonical,
+		DryRun:    *dry_run,
+	}
+
+	if !*dry_run {
+
+		wr, err := writer.NewWriter(ctx, *writer_uri)
+
+		if err != nil {
+			log.Fatalf("Failed to create writer, %v", err)
+		}
+
+		merge_opts.Writer = wr
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+
+	for _, ids := range groups {
+
+		rsp, err := publish.Merge(ctx, merge_opts, ids)
+
+		if err != nil {
+			log.Fatalf("Failed to merge %v, %v", ids, err)
+		}
+
+		err = enc.Encode(rsp)
+
+		if err != nil {
+			log.Fatalf("Failed to encode result, %v", err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// migrate is a command line tool to apply named, versioned migrations (backfills) to records in the
+// sfomuseum-data-socialmedia-instagram repository. Migrations are idempotent: the version of each migration applied
+// to a record is stored in its `instagram:migrations` property and migrations that have already been applied are
+// skipped. Records are only written if a migration changes them. For example:
+//
+//	$> ./bin/migrate -list
+//	perceptual_hash   1  Assign instagram:post.perceptual_hash properties, derived from the original image ...
+//	taken_timestamps  1  Derive timezone-aware taken timestamps (and local and UTC datetime strings) ...
+//
+//	$> ./bin/migrate -dry-run -migration taken_timestamps
+//
+// Pass the `-dry-run` flag to log the property-level changes each migration would make without writing anything.
+// The fix-dates tool rewrote taken_at datetime strings using a fixed -0800 offset, even during daylight saving time.
+// Pass the `-fix-dates-ids` flag with a file listing the WOF IDs of the records it rewrote so that the taken_timestamps
+// migration interprets them using that offset rather than `-timezone`.
+// New migrations are added by registering them, in the migrate package, with `migrate.RegisterMigration`.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/aaronland/gocloud-blob/s3"
+	_ "gocloud.dev/blob/fileblob"
+
+	"github.com/sfomuseum/go-sfomuseum-instagram-publish"
+	"github.com/sfomuseum/go-sfomuseum-instagram-publish/migrate"
+	"github.com/whosonfirst/go-writer/v3"
+	"gocloud.dev/blob"
+)
+
+func main() {
+
+	iterator_uri := flag.String("iterator-uri", "repo://", "A valid whosonfirst/go-whosonfirst-iterate/v2 URI")
+	iterator_source := flag.String("iterator-source", "/usr/local/data/sfomuseum-data-socialmedia-instagram", "...")
+
+	writer_uri := flag.String("writer-uri", "repo:///usr/local/data/sfomuseum-data-socialmedia-instagram", "A valid whosonfirst/go-writer URI")
+
+	str_migrations := flag.String("migration", "", "A comma-separated list of the names of the migrations to apply, in order.")
+	list := flag.Bool("list", false, "List the available migrations and exit.")
+
+	dry_run := flag.Bool("dry-run", false, "Log the property-level changes each migration would make but do not write any records.")
+
+	media_bucket_uri := flag.String("media-bucket-uri", "", "An optional gocloud.dev/blob URI where SFO Museum Instagram media files are stored, for example s3blob://sfomuseum-media?prefix=media/instagram/&region=us-west-2&credentials=session. Required by migrations that read media files.")
+	max_media_reads := flag.Int("max-media-reads", 10, "The maximum number of concurrent reads from the media bucket. If 0 concurrent reads are not limited.")
+	media_reads_per_second := flag.Float64("media-reads-per-second", 0, "The maximum number of reads from the media bucket to start per second. If 0 reads are not rate limited.")
+
+	timezone := flag.String("timezone", publish.DEFAULT_TIMEZONE, "The timezone of the (wall-clock) taken_at datetime strings in records.")
+	fix_dates_ids := flag.String("fix-dates-ids", "", "The path to an optional file containing the WOF IDs, one per line, of records whose taken_at datetime strings were rewritten by the fix-dates tool. The taken_timestamps migration interprets those using the fixed -0800 offset fix-dates used.")
+
+	verbose := flag.Bool("verbose", false, "Enable verbose (debug) logging.")
+
+	flag.Parse()
+
+	if *verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+		slog.Debug("Verbose logging enabled")
+	}
+
+	ctx := context.Background()
+
+	if *list {
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+		for _, m := range migrate.Migrations() {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", m.Name, m.Version, m.Description)
+		}
+
+		tw.Flush()
+		return
+	}
+
+	migrations := make([]*migrate.Migration, 0)
+
+	for _, name := range strings.Split(*str_migrations, ",") {
+
+		name = strings.TrimSpace(name)
+
+		if name == "" {
+			continue
+		}
+
+		m, err := migrate.GetMigration(name)
+
+		if err != nil {
+			log.Fatalf("Failed to get migration, %v", err)
+		}
+
+		migrations = append(migrations, m)
+	}
+
+	if len(migrations) == 0 {
+		log.Fatalf("No migrations specified, use -list to list the available migrations")
+	}
+
+	loc, err := time.LoadLocation(*timezone)
+
+	if err != nil {
+		log.Fatalf("Failed to load timezone, %v", err)
+	}
+
+	env := &migrate.Environment{
+		MediaLimiter: publish.NewLimiter(*max_media_reads, *media_reads_per_second),
+		Timezone:     loc,
+	}
+
+	if *fix_dates_ids != "" {
+
+		ids, err := readIds(*fix_dates_ids)
+
+		if err != nil {
+			log.Fatalf("Failed to read fix-dates IDs, %v", err)
+		}
+
+		env.FixDatesIds = ids
+	}
+
+	if *media_bucket_uri != "" {
+
+		media_bucket, err := blob.OpenBucket(ctx, *media_bucket_uri)
+
+		if err != nil {
+			log.Fatalf("Failed to open media bucket, %v", err)
+		}
+
+		defer media_bucket.Close()
+
+		env.MediaBucket = media_bucket
+	}
+
+	run_opts := &migrate.RunOptions{
+		Migrations:     migrations,
+		Environment:    env,
+		IteratorURI:    *iterator_uri,
+		IteratorSource: *iterator_source,
+		DryRun:         *dry_run,
+	}
+
+	if !*dry_run {
+
+		wr, err := writer.NewWriter(ctx, *writer_uri)
+
+		if err != nil {
+			log.Fatalf("Failed to create writer, %v", err)
+		}
+
+		run_opts.Writer = wr
+	}
+
+	summary, err := migrate.Run(ctx, run_opts)
+
+	if err != nil {
+		log.Fatalf("Failed to run migrations, %v", err)
+	}
+
+	err = summary.WriteSummary(os.Stderr)
+
+	if err != nil {
+		log.Fatalf("Failed to write summary, %v", err)
+	}
+}
+
+// readIds returns a lookup of the WOF IDs, one per line, in the file at 'path'. Blank lines are ignored.
+func readIds(path string) (map[int64]bool, error) {
+
+	r, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s, %w", path, err)
+	}
+
+	defer r.Close()
+
+	ids := make(map[int64]bool)
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+
+		ln := strings.TrimSpace(scanner.Text())
+
+		if ln == "" {
+			continue
+		}
+
+		id, err := strconv.ParseInt(ln, 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid WOF ID '%s', %w", ln, err)
+		}
+
+		ids[id] = true
+	}
+
+	err = scanner.Err()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s, %w", path, err)
+	}
+
+	return ids, nil
+}
@@ -1,44 +1,359 @@
-// perceptual-hash is a command line utility for generating perceptual hashes for one or more files.
+// perceptual-hash is a command line utility for generating (and comparing) image hashes for one or more files.
 // The expected use of the tool is for checking the hashes of existing SFO Museum Instagram images
-// and those are included with Instagram export bundles. For example:
+// and those are included with Instagram export bundles, for example when Instagram's re-encoding of an
+// image means it no longer matches an existing record. Files may be local paths, directories (which are
+// walked for JPEG, PNG and GIF images) or gocloud.dev/blob URIs where the bucket and the key (or prefix)
+// are encoded in a single string: "{scheme}://{bucket}/{key}?{query}". For example:
 //
-//	> go run -mod vendor cmd/perceptual-hash/main.go ~/Desktop/120885293_2012351615564945_4864065299023451274_n_17956147654363845.jpg ~/Desktop/3b1bce024e1f35517a8d517a2a8cd169_77e79d2a1a_o.jpg
-//	p:b867679231ccc633
-//	p:b867679231ccc633
+//	> go run -mod vendor cmd/perceptual-hash/main.go -algorithm perception ~/Desktop/120885293_2012351615564945_4864065299023451274_n_17956147654363845.jpg ~/Desktop/3b1bce024e1f35517a8d517a2a8cd169_77e79d2a1a_o.jpg
+//	/Users/example/Desktop/120885293_2012351615564945_4864065299023451274_n_17956147654363845.jpg	perception	p:b867679231ccc633
+//	/Users/example/Desktop/3b1bce024e1f35517a8d517a2a8cd169_77e79d2a1a_o.jpg	perception	p:b867679231ccc633
+//
+// By default the average, difference, perception and extended (256-bit perception) hashes are derived for
+// each file. Pass the `-compare` flag to print the Hamming distance between every pair of files, for each
+// algorithm, along with a "same" or "different" verdict derived from that algorithm's threshold:
+//
+//	> go run -mod vendor cmd/perceptual-hash/main.go -compare \
+//		~/Desktop/instagram.jpg \
+//		's3blob://sfomuseum-media/media/instagram/3b1bce024e1f35517a8d517a2a8cd169/3b1bce024e1f35517a8d517a2a8cd169_77e79d2a1a_o.jpg?region=us-west-2&credentials=session'
+//	A                 B                       ALGORITHM   DISTANCE  THRESHOLD  VERDICT
+//	/.../instagram.jpg  s3blob://.../..._o.jpg  average     2         5          same
+//	...
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	_ "image/jpeg"
+	"io"
+	"io/fs"
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	_ "github.com/aaronland/gocloud-blob/s3"
+	_ "gocloud.dev/blob/fileblob"
 
-	"github.com/sfomuseum/go-sfomuseum-instagram/hash"
+	"github.com/sfomuseum/go-sfomuseum-instagram-publish"
+	"gocloud.dev/blob"
 )
 
+// image_extensions is the list of file extensions hashed when walking directories (or listing bucket prefixes).
+var image_extensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+// source is a struct defining an individual image to hash.
+type source struct {
+	uri  string
+	open func(context.Context) (io.ReadCloser, error)
+}
+
+// thresholds is a `flag.Value` implementation for per-algorithm thresholds in the form "{algorithm}={distance}".
+type thresholds map[string]int
+
+func (t thresholds) String() string {
+	return fmt.Sprintf("%v", map[string]int(t))
+}
+
+func (t thresholds) Set(v string) error {
+
+	parts := strings.SplitN(v, "=", 2)
+
+	if len(parts) != 2 {
+		return fmt.Errorf("Invalid threshold '%s'", v)
+	}
+
+	_, err := publish.GetImageHashAlgorithm(parts[0])
+
+	if err != nil {
+		return err
+	}
+
+	d, err := strconv.Atoi(parts[1])
+
+	if err != nil {
+		return fmt.Errorf("Invalid threshold '%s', %w", v, err)
+	}
+
+	t[parts[0]] = d
+	return nil
+}
+
 func main() {
 
+	var str_algorithms string
+	algorithm_thresholds := make(thresholds)
+
+	default_algorithms := make([]string, len(publish.IMAGE_HASH_ALGORITHMS))
+
+	for i, a := range publish.IMAGE_HASH_ALGORITHMS {
+		default_algorithms[i] = a.Name
+	}
+
+	flag.StringVar(&str_algorithms, "algorithm", strings.Join(default_algorithms, ","), "A comma-separated list of the hash algorithms to use. Valid options are: "+strings.Join(default_algorithms, ", ")+".")
+	flag.Var(algorithm_thresholds, "threshold", "Zero or more {algorithm}={distance} pairs overriding the default maximum Hamming distance for two images to be considered the same in -compare mode.")
+
+	compare := flag.Bool("compare", false, "Print the Hamming distance between every pair of files, for each algorithm, and whether they are considered the same.")
+
 	flag.Parse()
 
+	ctx := context.Background()
+
+	algorithms := make([]*publish.ImageHashAlgorithm, 0)
+
+	for _, name := range strings.Split(str_algorithms, ",") {
+
+		a, err := publish.GetImageHashAlgorithm(strings.TrimSpace(name))
+
+		if err != nil {
+			log.Fatalf("Invalid -algorithm flag, %v", err)
+		}
+
+		algorithms = append(algorithms, a)
+	}
+
+	sources := make([]*source, 0)
+
 	for _, uri := range flag.Args() {
 
-		// To do: Some sort of syntax to allow us to use URIs that
-		// encode both gocloud/blob.Bucket specifics and the file
-		// to fetch in a single string
+		s, err := deriveSources(ctx, uri)
+
+		if err != nil {
+			log.Fatalf("Failed to derive files for %s, %v", uri, err)
+		}
+
+		sources = append(sources, s...)
+	}
+
+	if *compare && len(sources) < 2 {
+		log.Fatalf("-compare requires at least two files")
+	}
+
+	hashes := make([]map[string]string, len(sources))
+
+	for i, s := range sources {
+
+		r, err := s.open(ctx)
+
+		if err != nil {
+			log.Fatalf("Failed to open %s, %v", s.uri, err)
+		}
+
+		h, err := publish.ImageHashes(r, algorithms)
+
+		r.Close()
+
+		if err != nil {
+			log.Fatalf("Failed to derive hashes for %s, %v", s.uri, err)
+		}
+
+		hashes[i] = h
+
+		if !*compare {
+
+			for _, a := range algorithms {
+				fmt.Printf("%s\t%s\t%s\n", s.uri, a.Name, h[a.Name])
+			}
+		}
+	}
+
+	if !*compare {
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "A\tB\tALGORITHM\tDISTANCE\tTHRESHOLD\tVERDICT\n")
+
+	for i := 0; i < len(sources); i++ {
+
+		for j := i + 1; j < len(sources); j++ {
+
+			for _, a := range algorithms {
+
+				d, err := a.Distance(hashes[i][a.Name], hashes[j][a.Name])
+
+				if err != nil {
+					log.Fatalf("Failed to compare %s and %s, %v", sources[i].uri, sources[j].uri, err)
+				}
+
+				threshold := a.Threshold
+
+				if t, ok := algorithm_thresholds[a.Name]; ok {
+					threshold = t
+				}
 
-		r, err := os.Open(uri)
+				verdict := "different"
+
+				if d <= threshold {
+					verdict = "same"
+				}
+
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n", sources[i].uri, sources[j].uri, a.Name, d, threshold, verdict)
+			}
+		}
+	}
+
+	tw.Flush()
+}
+
+// deriveSources returns the list of images defined by 'uri' which may be a local file or directory or a
+// gocloud.dev/blob URI for a key or a prefix.
+func deriveSources(ctx context.Context, uri string) ([]*source, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil || u.Scheme == "" || u.Scheme == "file" {
+
+		path := uri
+
+		if err == nil && u.Scheme == "file" {
+			path = u.Path
+		}
+
+		return deriveLocalSources(path)
+	}
+
+	bucket_u := url.URL{
+		Scheme:   u.Scheme,
+		Host:     u.Host,
+		RawQuery: u.RawQuery,
+	}
+
+	bucket, err := blob.OpenBucket(ctx, bucket_u.String())
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open bucket, %w", err)
+	}
+
+	key := strings.TrimPrefix(u.Path, "/")
+
+	if key != "" && !strings.HasSuffix(key, "/") {
+
+		exists, err := bucket.Exists(ctx, key)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to determine whether %s exists, %w", key, err)
+		}
+
+		if exists {
+			return []*source{bucketSource(bucket, uri, key)}, nil
+		}
+
+		key = key + "/"
+	}
+
+	sources := make([]*source, 0)
+
+	list_iter := bucket.List(&blob.ListOptions{Prefix: key})
+
+	for {
+
+		obj, err := list_iter.Next(ctx)
+
+		if err == io.EOF {
+			break
+		}
 
 		if err != nil {
-			log.Fatalf("Failed to open %s, %v", uri, err)
+			return nil, fmt.Errorf("Failed to list %s, %w", key, err)
+		}
+
+		if obj.IsDir || !image_extensions[strings.ToLower(filepath.Ext(obj.Key))] {
+			continue
 		}
 
-		h, err := hash.PerceptualHash(r)
+		obj_u := u
+		obj_u.Path = "/" + obj.Key
+
+		sources = append(sources, bucketSource(bucket, obj_u.String(), obj.Key))
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("No images found")
+	}
+
+	return sources, nil
+}
+
+// deriveLocalSources returns the list of images defined by 'path' which may be a file or a directory.
+func deriveLocalSources(path string) ([]*source, error) {
+
+	abs_path, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive absolute path, %w", err)
+	}
+
+	info, err := os.Stat(abs_path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to stat %s, %w", abs_path, err)
+	}
+
+	if !info.IsDir() {
+		return []*source{localSource(abs_path)}, nil
+	}
+
+	paths := make([]string, 0)
+
+	err = filepath.WalkDir(abs_path, func(p string, d fs.DirEntry, err error) error {
 
 		if err != nil {
-			log.Fatalf("Failed to derive hash for %s, %v", uri, err)
+			return err
 		}
 
-		fmt.Println(h)
+		if !d.IsDir() && image_extensions[strings.ToLower(filepath.Ext(p))] {
+			paths = append(paths, p)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to walk %s, %w", abs_path, err)
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("No images found")
+	}
+
+	sort.Strings(paths)
+
+	sources := make([]*source, len(paths))
+
+	for i, p := range paths {
+		sources[i] = localSource(p)
+	}
+
+	return sources, nil
+}
+
+func localSource(path string) *source {
+
+	return &source{
+		uri: path,
+		open: func(ctx context.Context) (io.ReadCloser, error) {
+			return os.Open(path)
+		},
+	}
+}
+
+func bucketSource(bucket *blob.Bucket, uri string, key string) *source {
+
+	return &source{
+		uri: uri,
+		open: func(ctx context.Context) (io.ReadCloser, error) {
+			return bucket.NewReader(ctx, key, nil)
+		},
 	}
 }
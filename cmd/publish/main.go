@@ -1,26 +1,105 @@
-// publish is a command-line tool to merge Instagram posts defined in one or more "media.json" files
-// with the sfomuseum-data-socialmedia-instagram repository. For example:
+// publish is a command-line tool to merge Instagram posts defined in one or more "media.json" or "posts" JSON
+// files with the sfomuseum-data-socialmedia-instagram repository. For example:
 //
 //	$> ./bin/publish -media-bucket-uri file:///Volumes/Museum/_Public/_Social_Media/SM\ downloads/2022/sfomuseum_20220418/ file:///usr/local/data/media.json
 //
-// Important: As of April, 2022 Instagram no longer publishes "media.json" files with the export bundles.
-// Use the sfomuseum/go-sfomuseum-instagram/cmd/derive-media-json tool to create a media.json file from
-// the available data.
+// Important: As of April, 2022 Instagram no longer publishes "media.json" files with the export bundles. Current
+// export bundles contain "your_instagram_activity/content/posts_(N).json" files which are detected and read natively.
+// If no media files are specified the tool will look for those files in the media bucket. For example:
+//
+//	$> ./bin/publish -media-bucket-uri file:///usr/local/data/instagram/instagram-sfomuseum-2024-11-27-p55zxMWB
+//
+// Export bundles can also be read directly, without being extracted, by passing the path to the ZIP archive. For example:
+//
+//	$> ./bin/publish /usr/local/data/instagram/instagram-sfomuseum-2024-11-27-p55zxMWB.zip
+//
+// To preview what would be published without writing anything pass the `-dry-run` flag. Each post will be
+// classified as a new record, an update to an existing record or unchanged and the results will be emitted
+// as line-separated JSON (to STDOUT or the path defined by the `-report-path` flag) followed by a summary
+// table (to STDERR).
+//
+// Existing records are only written if one or more of their properties have changed. To log the
+// property-level changes for each updated record pass the `-log-changes` flag.
+//
+// Instagram records when posts were published as wall-clock datetime strings without a timezone. The `-timezone`
+// flag (default "America/Los_Angeles") defines the timezone of the export; each post is assigned a correct "taken"
+// timestamp along with "taken_at_local" (with offset) and "taken_at_utc" properties. Media IDs are derived from the
+// original datetime strings so they don't depend on the timezone.
+//
+// Pass the `-journal-path` flag to record the status of each post in a checkpoint journal. If a run is interrupted
+// rerunning it with the same journal will skip the posts that were already published. To retry only the posts
+// that failed pass the `-retry-failed` flag as well.
+//
+// Video posts are assigned a perceptual hash derived from a small number of their keyframes, which survives
+// re-encoding by Instagram, when those keyframes can be decoded. H.264 keyframes require the `-ffmpeg-path` flag.
+// Video hashes are only used for fuzzy matching (see below) so re-encoded videos will still create duplicate
+// records unless both the `-ffmpeg-path` and `-fuzzy-threshold` flags are passed.
+//
+// Pass the `-derivatives-bucket-uri` flag to upload the original media file for each post, and resized (`b`, `z` and
+// `sq`) derivatives for images, to a bucket using the "{media_id}/{media_id}_{secret}_{size}.{extension}" naming
+// scheme expected by `assign-hash`. The available sizes are recorded in the `instagram:sizes` property of each record
+// (and, for the remaining slides of carousel posts, in the `instagram:slide_sizes` property).
+//
+// New and updated records are assigned an `instagram:provenance` property recording the export bundle (derived from
+// the name of the ZIP archive or `-media-bucket-uri`, or the `-bundle` flag), its export date, the media path of the
+// post inside the bundle and the version of the tool. When a later export updates a record the previous provenance
+// is appended to its `instagram:provenance_history` property.
+//
+// Pass the `-audit-log-path` flag to append a line-separated JSON entry for every record that is written, recording
+// its WOF ID, whether it was new or updated, its media ID and media path, the export bundle, its previous and new
+// `wof:lastmodified` properties and the git commit of the data repository, so that records can be traced back to
+// the export that produced them.
+//
+// By default the lookup table of media IDs to WOF IDs is rebuilt by crawling the data repository on every run.
+// Pass the `-lookup-path` flag to persist it as a JSON snapshot. Records that have changed since the git HEAD
+// the snapshot was built from are added to it at the start of each run and it is only rebuilt if that commit is
+// no longer part of the data repository's history or records have been removed.
+//
+// Instagram sometimes re-encodes images between exports which causes their perceptual hashes, and the media IDs
+// derived from them, to change. Pass the `-fuzzy-threshold` flag to match posts that can't otherwise be found
+// to existing records taken in the same minute whose perceptual hashes are within that Hamming distance.
+//
+// Posts are published by a pool of `-workers` (default 10) goroutines. Reads from the media bucket and record
+// writes can be limited separately using the `-max-media-reads` and `-max-writes` flags and reads from remote
+// buckets can be rate limited using the `-media-reads-per-second` flag.
+//
+// Progress (posts processed, the total number of posts, the rate and an estimated time remaining) is logged every
+// `-progress-interval` and a summary of the run, with counts of new, updated, unchanged and skipped records,
+// path-fallback and fuzzy matches, failures and the time spent in each pipeline stage, is written to STDERR at the end
+// of the run. Pass the `-summary-path` flag to also write the summary as JSON.
+//
+// By default the first post that fails to publish stops the run. Pass the `-continue-on-error` flag to record
+// failures (with the pipeline stage that failed) and continue; they are reported at the end of the run and the
+// process only exits with a non-zero status code if there are more than `-max-failures` failures.
+//
+// New records are parented by the Null Terminal and stored in the sfomuseum-data-socialmedia-instagram repository
+// by default. Use the `-template-parent-id` (and `-template-reader-uri`) flags to derive the parent ID, hierarchy and
+// centroid from a different (or updated) parent record or `-template-path` to use a GeoJSON Feature as a template.
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	_ "github.com/aaronland/gocloud-blob/s3"
 	_ "gocloud.dev/blob/fileblob"
 	_ "image/jpeg"
 
 	"github.com/sfomuseum/go-sfomuseum-instagram-publish"
+	"github.com/sfomuseum/go-sfomuseum-instagram-publish/zipblob"
 	"github.com/sfomuseum/go-sfomuseum-instagram/media"
-	"github.com/sfomuseum/go-sfomuseum-instagram/walk"
+	"github.com/tidwall/gjson"
 	"github.com/whosonfirst/go-reader"
 	"github.com/whosonfirst/go-writer/v3"
 	"gocloud.dev/blob"
@@ -28,14 +107,66 @@ import (
 
 func main() {
 
+	err := run()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run publishes Instagram posts and returns an error, rather than exiting, so that deferred functions (which save
+// the lookup snapshot and close the journal and audit log) are run on every exit path.
+func run() (err error) {
+
 	iterator_uri := flag.String("iterator-uri", "repo://", "A valid whosonfirst/go-whosonfirst-iterate/v2 URI")
 	iterator_source := flag.String("iterator-source", "/usr/local/data/sfomuseum-data-socialmedia-instagram", "...")
 
 	reader_uri := flag.String("reader-uri", "repo:///usr/local/data/sfomuseum-data-socialmedia-instagram", "A valid whosonfirst/go-reader URI")
 	writer_uri := flag.String("writer-uri", "repo:///usr/local/data/sfomuseum-data-socialmedia-instagram", "A valid whosonfirst/go-writer URI")
 
+	lookup_path := flag.String("lookup-path", "", "An optional path to a JSON snapshot of the media ID lookup table. If present the snapshot will be used instead of crawling -iterator-source. Records changed since the git HEAD the snapshot was built from are added to it and it is rebuilt automatically if that commit is no longer part of the history of -iterator-source or records have been removed. The snapshot is updated at the end of each run, including runs that stop because of an error.")
+	rebuild_lookup := flag.Bool("rebuild-lookup", false, "Force the lookup snapshot defined by -lookup-path to be rebuilt.")
+
+	fuzzy_threshold := flag.Int("fuzzy-threshold", 0, "The maximum Hamming distance between perceptual hashes for a post to be matched to an existing record taken in the same minute, when it can't be matched by media ID or path. Video posts are compared using their video hashes (see -ffmpeg-path). Fuzzy matches are logged and reported for review. If 0 fuzzy matching is disabled.")
+
+	template_path := flag.String("template-path", "", "An optional path to a GeoJSON Feature to use as the template for new records. It must define wof:parent_id, wof:hierarchy and wof:repo properties.")
+	template_parent_id := flag.Int64("template-parent-id", 0, "An optional WOF ID of the parent record (for example 1159160869 for the Null Terminal) from which the parent ID, hierarchy, country and centroid of new records will be derived.")
+	template_reader_uri := flag.String("template-reader-uri", "repo:///usr/local/data/sfomuseum-data-architecture", "A valid whosonfirst/go-reader URI used to read the record defined by -template-parent-id.")
+	template_repo := flag.String("template-repo", publish.DEFAULT_REPO, "The wof:repo property to assign to new records derived from -template-parent-id.")
+
 	media_bucket_uri := flag.String("media-bucket-uri", "", "A valid gocloud.dev/blob URI where Instagram (export) media files are stored.")
 
+	timezone := flag.String("timezone", publish.DEFAULT_TIMEZONE, "The timezone of the export being published. It is used to derive datetime strings from the Unix timestamps in Instagram \"posts\" JSON files and to interpret the (wall-clock) datetime strings in legacy media.json files. Each post is assigned a \"taken\" timestamp and \"taken_at_local\" and \"taken_at_utc\" properties derived using this timezone.")
+
+	derivatives_bucket_uri := flag.String("derivatives-bucket-uri", "", "An optional gocloud.dev/blob URI (for example the sfomuseum-media S3 bucket) where the original media file for each post, and resized derivatives, will be uploaded. If empty nothing is uploaded.")
+
+	dry_run := flag.Bool("dry-run", false, "Classify each post as new, updated or unchanged but do not write any records.")
+	report_path := flag.String("report-path", "", "An optional path to write a line-separated JSON report of each post's outcome. If empty and -dry-run is true the report will be written to STDOUT.")
+
+	log_changes := flag.Bool("log-changes", false, "Log the property-level changes for each updated record.")
+
+	journal_path := flag.String("journal-path", "", "An optional path to a checkpoint journal recording the status of each post. If present posts that were published in a previous run, recorded in the journal, will be skipped.")
+	retry_failed := flag.Bool("retry-failed", false, "Only publish posts whose most recent status in the journal defined by -journal-path is \"failed\".")
+
+	bundle := flag.String("bundle", "", "The name of the Instagram export bundle being published, recorded in the instagram:provenance property of new and updated records. If empty it is derived from the name of the ZIP archive or -media-bucket-uri.")
+
+	audit_log_path := flag.String("audit-log-path", "", "An optional path to an append-only, line-separated JSON audit log of every record written.")
+
+	continue_on_error := flag.Bool("continue-on-error", false, "Record posts that fail to publish and continue rather than stopping at the first failure. Failures are written as line-separated JSON to the path defined by -failure-report-path (or STDERR) at the end of the run.")
+	failure_report_path := flag.String("failure-report-path", "", "An optional path to write a line-separated JSON report of posts that failed to publish when -continue-on-error is true. If empty the report will be written to STDERR.")
+	max_failures := flag.Int("max-failures", 0, "The maximum number of failures tolerated when -continue-on-error is true before the process exits with a non-zero status code.")
+
+	workers := flag.Int("workers", publish.DEFAULT_WORKERS, "The number of posts to publish concurrently.")
+	max_media_reads := flag.Int("max-media-reads", 0, "The maximum number of concurrent reads from the media bucket. If 0 reads are only limited by -workers.")
+	media_reads_per_second := flag.Float64("media-reads-per-second", 0, "The maximum number of reads from the media bucket to start per second, for example when reading from a remote (S3) bucket. If 0 reads are not rate limited.")
+	max_writes := flag.Int("max-writes", 0, "The maximum number of concurrent record writes. If 0 writes are only limited by -workers.")
+
+	ffmpeg_path := flag.String("ffmpeg-path", "", "An optional path to an ffmpeg binary used to decode H.264 video keyframes when deriving video perceptual hashes. If empty only videos whose frames can be decoded natively, which excludes almost all Instagram videos, will be assigned a video hash. Video hashes are only used when -fuzzy-threshold is greater than 0.")
+	video_hash_frames := flag.Int("video-hash-frames", publish.DEFAULT_VIDEO_HASH_FRAMES, "The number of keyframes used to derive video perceptual hashes.")
+
+	progress_interval := flag.Duration("progress-interval", 30*time.Second, "How often to log the progress of the run. If 0 progress is not logged.")
+	summary_path := flag.String("summary-path", "", "An optional path to write a JSON-encoded summary of the run, including counts and the time spent in each pipeline stage.")
+
 	verbose := flag.Bool("verbose", false, "Enable verbose (debug) logging.")
 
 	flag.Parse()
@@ -53,82 +184,488 @@ func main() {
 	rdr, err := reader.NewReader(ctx, *reader_uri)
 
 	if err != nil {
-		log.Fatalf("Failed to create reader, %v", err)
+		return fmt.Errorf("Failed to create reader, %w", err)
 	}
 
-	wrtr, err := writer.NewWriter(ctx, *writer_uri)
+	var wrtr writer.Writer
 
-	if err != nil {
-		log.Fatalf("Failed to create writer, %v", err)
+	if !*dry_run {
+
+		wrtr, err = writer.NewWriter(ctx, *writer_uri)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create writer, %w", err)
+		}
 	}
 
-	lookup, err := publish.BuildLookup(ctx, *iterator_uri, *iterator_source)
+	var lookup publish.Lookup
+	var file_lookup *publish.FileLookup
+	var hash_index *publish.PerceptualHashIndex
 
-	if err != nil {
-		log.Fatalf("Failed to build lookup, %v", err)
+	if *lookup_path != "" {
+
+		file_lookup, err = publish.OpenFileLookup(ctx, *lookup_path)
+
+		if err != nil {
+			return fmt.Errorf("Failed to open lookup, %w", err)
+		}
+
+		is_stale := *rebuild_lookup
+
+		if !is_stale {
+
+			is_stale, err = file_lookup.IsStale(ctx, *iterator_source)
+
+			if err != nil {
+				return fmt.Errorf("Failed to determine whether lookup is stale, %w", err)
+			}
+		}
+
+		if is_stale {
+
+			slog.Info("Rebuilding lookup", "path", *lookup_path)
+
+			err = file_lookup.Rebuild(ctx, *iterator_uri, *iterator_source)
+
+			if err != nil {
+				return fmt.Errorf("Failed to rebuild lookup, %w", err)
+			}
+
+			err = file_lookup.Save(ctx)
+
+			if err != nil {
+				return fmt.Errorf("Failed to save lookup, %w", err)
+			}
+
+		} else {
+
+			err = file_lookup.Update(ctx, *iterator_source)
+
+			if err != nil {
+				return fmt.Errorf("Failed to update lookup, %w", err)
+			}
+		}
+
+		lookup = file_lookup
+		hash_index = file_lookup.HashIndex()
+
+		// Save the lookup, including entries for any records written during this run, even if the run
+		// fails. Otherwise those (uncommitted) records would be created again by the next run.
+
+		defer func() {
+
+			save_err := file_lookup.Save(ctx)
+
+			if save_err != nil {
+
+				slog.Error("Failed to save lookup", "path", *lookup_path, "error", save_err)
+
+				if err == nil {
+					err = fmt.Errorf("Failed to save lookup, %w", save_err)
+				}
+			}
+		}()
+
+	} else {
+
+		hash_index = publish.NewPerceptualHashIndex()
+
+		populate_opts := &publish.PopulateLookupOptions{
+			Lookup:         publish.NewMemoryLookup(),
+			HashIndex:      hash_index,
+			IteratorURI:    *iterator_uri,
+			IteratorSource: *iterator_source,
+		}
+
+		err = publish.PopulateLookupWithOptions(ctx, populate_opts)
+
+		if err != nil {
+			return fmt.Errorf("Failed to build lookup, %w", err)
+		}
+
+		lookup = populate_opts.Lookup
+	}
+
+	args := flag.Args()
+
+	// If we've been passed an Instagram export bundle (ZIP archive) read both media files and
+	// "posts" JSON files directly from the archive.
+
+	if len(args) == 1 && strings.ToLower(filepath.Ext(args[0])) == ".zip" {
+
+		if *media_bucket_uri != "" {
+			return fmt.Errorf("-media-bucket-uri can not be used with an Instagram export ZIP archive")
+		}
+
+		zip_path, err := filepath.Abs(args[0])
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive absolute path for %s, %w", args[0], err)
+		}
+
+		// Paths may contain characters (spaces, "#", "?", "%") that need to be escaped in a URI
+
+		zip_uri := url.URL{
+			Scheme: zipblob.Scheme,
+			Path:   zip_path,
+		}
+
+		*media_bucket_uri = zip_uri.String()
+		args = []string{}
+	}
+
+	// The name of the export bundle is recorded in the provenance properties of each record and the audit log
+
+	if *bundle == "" && *media_bucket_uri != "" {
+
+		name, err := publish.BundleNameFromURI(*media_bucket_uri)
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive export bundle name, %w", err)
+		}
+
+		*bundle = name
 	}
 
 	media_bucket, err := blob.OpenBucket(ctx, *media_bucket_uri)
 
 	if err != nil {
-		log.Fatalf("Failed to open media bucket, %v", err)
+		return fmt.Errorf("Failed to open media bucket, %w", err)
+	}
+
+	var template *publish.RecordTemplate
+
+	switch {
+	case *template_path != "":
+
+		template_fh, err := os.Open(*template_path)
+
+		if err != nil {
+			return fmt.Errorf("Failed to open template, %w", err)
+		}
+
+		template, err = publish.NewRecordTemplateFromReader(ctx, template_fh)
+
+		template_fh.Close()
+
+		if err != nil {
+			return fmt.Errorf("Failed to create record template, %w", err)
+		}
+
+	case *template_parent_id != 0:
+
+		template_rdr, err := reader.NewReader(ctx, *template_reader_uri)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create template reader, %w", err)
+		}
+
+		template, err = publish.NewRecordTemplateFromParent(ctx, template_rdr, *template_parent_id, *template_repo)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create record template, %w", err)
+		}
+
+	default:
+		// pass
 	}
 
+	metrics := publish.NewMetrics()
+
 	publish_opts := &publish.PublishOptions{
-		Lookup:      lookup,
-		Reader:      rdr,
-		Writer:      wrtr,
-		MediaBucket: media_bucket,
+		Metrics:         metrics,
+		Bundle:          *bundle,
+		Lookup:          lookup,
+		Reader:          rdr,
+		Writer:          wrtr,
+		MediaBucket:     media_bucket,
+		DryRun:          *dry_run,
+		LogChanges:      *log_changes,
+		HashIndex:       hash_index,
+		FuzzyThreshold:  *fuzzy_threshold,
+		Template:        template,
+		VideoHashFrames: *video_hash_frames,
 	}
 
-	max_procs := 10
-	throttle := make(chan bool, max_procs)
+	if *derivatives_bucket_uri != "" {
+
+		derivatives_bucket, err := blob.OpenBucket(ctx, *derivatives_bucket_uri)
+
+		if err != nil {
+			return fmt.Errorf("Failed to open derivatives bucket, %w", err)
+		}
 
-	for i := 0; i < max_procs; i++ {
-		throttle <- true
+		defer derivatives_bucket.Close()
+
+		publish_opts.DerivativesBucket = derivatives_bucket
 	}
 
-	cb := func(ctx context.Context, body []byte) error {
+	if *max_media_reads > 0 || *media_reads_per_second > 0 {
+		publish_opts.MediaLimiter = publish.NewLimiter(*max_media_reads, *media_reads_per_second)
+	}
 
-		<-throttle
+	if *max_writes > 0 {
+		publish_opts.WriteLimiter = publish.NewLimiter(*max_writes, 0)
+	}
 
-		defer func() {
-			throttle <- true
-		}()
+	if *ffmpeg_path != "" {
+		publish_opts.VideoFrameDecoder = publish.NewFFmpegFrameDecoder(*ffmpeg_path)
+	}
+
+	if *retry_failed && *journal_path == "" {
+		return fmt.Errorf("-retry-failed requires -journal-path")
+	}
+
+	if *journal_path != "" {
+
+		journal, err := publish.OpenJournal(ctx, *journal_path)
+
+		if err != nil {
+			return fmt.Errorf("Failed to open journal, %w", err)
+		}
+
+		defer journal.Close()
+
+		publish_opts.Journal = journal
+		publish_opts.RetryFailed = *retry_failed
+	}
+
+	if *audit_log_path != "" && !*dry_run {
+
+		audit_log, err := publish.OpenAuditLog(ctx, *audit_log_path)
+
+		if err != nil {
+			return fmt.Errorf("Failed to open audit log, %w", err)
+		}
+
+		defer audit_log.Close()
+
+		commit, err := publish.GitHead(ctx, *iterator_source)
+
+		if err != nil {
+			slog.Warn("Failed to determine git commit for data repository, audit log entries will not include it", "error", err)
+		}
+
+		publish_opts.AuditLog = audit_log
+		publish_opts.Commit = commit
+	}
+
+	if *dry_run || *report_path != "" {
+		publish_opts.Report = publish.NewReport()
+	}
+
+	failures := publish.NewFailureReport()
+
+	cb := func(ctx context.Context, body []byte) error {
 
 		err := publish.PublishMedia(ctx, publish_opts, body)
 
 		if err != nil {
-			// slog.Warn("Failed to publish media", "error", err)
-			return err
+
+			if !*continue_on_error {
+				return err
+			}
+
+			path := gjson.GetBytes(body, "path").String()
+
+			slog.Warn("Failed to publish media, continuing", "path", path, "error", err)
+			failures.Add(path, err)
 		}
 
 		return nil
 	}
 
-	walk_opts := &walk.WalkWithCallbackOptions{
-		Callback: cb,
+	loc, err := time.LoadLocation(*timezone)
+
+	if err != nil {
+		return fmt.Errorf("Failed to load timezone, %w", err)
 	}
 
-	args := flag.Args()
+	publish_opts.Timezone = loc
+
+	walk_archive := func(ctx context.Context, media_fh io.Reader) error {
+
+		body, err := io.ReadAll(media_fh)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read media, %w", err)
+		}
+
+		body, err = publish.NormalizeArchive(ctx, body, loc)
+
+		if err != nil {
+			return fmt.Errorf("Failed to normalize media, %w", err)
+		}
+
+		metrics.AddTotal(int(gjson.GetBytes(body, "photos.#").Int()))
+
+		return publish.WalkArchiveWithWorkers(ctx, bytes.NewReader(body), *workers, cb)
+	}
+
+	if *progress_interval > 0 {
+		go metrics.LogProgress(ctx, slog.Default(), *progress_interval)
+	}
 
 	for _, media_uri := range args {
 
 		media_fh, err := media.Open(ctx, media_uri)
 
 		if err != nil {
-			log.Fatalf("Failed to open %s, %v", media_uri, err)
+			return fmt.Errorf("Failed to open %s, %w", media_uri, err)
 		}
 
 		defer media_fh.Close()
 
-		err = walk.WalkMediaWithCallback(ctx, walk_opts, media_fh)
+		err = walk_archive(ctx, media_fh)
 
 		if err != nil {
-			log.Fatalf("Failed to walk media for %s, %v", media_uri, err)
+			return fmt.Errorf("Failed to walk media for %s, %w", media_uri, err)
 		}
 
-		log.Println(media_uri)
+		slog.Info("Finished publishing media", "uri", media_uri)
+	}
+
+	// If no media files were specified look for "posts" JSON files in the media bucket
+
+	if len(args) == 0 {
+
+		for i := 1; ; i++ {
+
+			posts_path := fmt.Sprintf(publish.POSTS_JSON_PATH_TEMPLATE, i)
+
+			exists, err := media_bucket.Exists(ctx, posts_path)
+
+			if err != nil {
+				return fmt.Errorf("Failed to determine whether %s exists, %w", posts_path, err)
+			}
+
+			if !exists {
+
+				if i == 1 {
+					return fmt.Errorf("No media files specified and %s not found in media bucket", posts_path)
+				}
+
+				break
+			}
+
+			posts_fh, err := media_bucket.NewReader(ctx, posts_path, nil)
+
+			if err != nil {
+				return fmt.Errorf("Failed to open %s, %w", posts_path, err)
+			}
+
+			err = walk_archive(ctx, posts_fh)
+
+			posts_fh.Close()
+
+			if err != nil {
+				return fmt.Errorf("Failed to walk media for %s, %w", posts_path, err)
+			}
+
+			slog.Info("Finished publishing media", "path", posts_path)
+		}
+	}
+
+	if publish_opts.Journal != nil {
+
+		failures := publish_opts.Journal.Failures()
+
+		if len(failures) > 0 {
+			log.Printf("%d posts have failed, rerun with -retry-failed to retry them\n", len(failures))
+		}
+	}
+
+	if publish_opts.Report != nil {
+
+		var report_wr io.Writer = os.Stdout
+
+		if *report_path != "" {
+
+			report_fh, err := os.Create(*report_path)
+
+			if err != nil {
+				return fmt.Errorf("Failed to create %s, %w", *report_path, err)
+			}
+
+			defer report_fh.Close()
+			report_wr = report_fh
+		}
+
+		err := publish_opts.Report.WriteJSONLines(report_wr)
+
+		if err != nil {
+			return fmt.Errorf("Failed to write report, %w", err)
+		}
+
+		err = publish_opts.Report.WriteSummary(os.Stderr)
+
+		if err != nil {
+			return fmt.Errorf("Failed to write report summary, %w", err)
+		}
+	}
+
+	err = metrics.WriteSummary(os.Stderr)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write run summary, %w", err)
+	}
+
+	if *summary_path != "" {
+
+		summary_fh, err := os.Create(*summary_path)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create %s, %w", *summary_path, err)
+		}
+
+		enc := json.NewEncoder(summary_fh)
+		enc.SetIndent("", "  ")
+
+		err = enc.Encode(metrics.Summary())
+
+		if err != nil {
+			return fmt.Errorf("Failed to write run summary, %w", err)
+		}
+
+		err = summary_fh.Close()
+
+		if err != nil {
+			return fmt.Errorf("Failed to close %s, %w", *summary_path, err)
+		}
+	}
+
+	if failures.Count() > 0 {
+
+		var failures_wr io.Writer = os.Stderr
+
+		if *failure_report_path != "" {
+
+			failures_fh, err := os.Create(*failure_report_path)
+
+			if err != nil {
+				return fmt.Errorf("Failed to create %s, %w", *failure_report_path, err)
+			}
+
+			defer failures_fh.Close()
+			failures_wr = failures_fh
+		}
+
+		err := failures.WriteJSONLines(failures_wr)
+
+		if err != nil {
+			return fmt.Errorf("Failed to write failure report, %w", err)
+		}
+
+		err = failures.WriteSummary(os.Stderr)
+
+		if err != nil {
+			return fmt.Errorf("Failed to write failure summary, %w", err)
+		}
+
+		if failures.Count() > *max_failures {
+			return fmt.Errorf("%d posts failed to publish, which exceeds the maximum of %d", failures.Count(), *max_failures)
+		}
 	}
 
+	return nil
 }
@@ -0,0 +1,89 @@
+// verify-media is a command line tool to check that every record in the sfomuseum-data-socialmedia-instagram
+// repository has a corresponding original ("_o") media file, and any derivatives listed in its `instagram:sizes`
+// property, in the `sfomuseum-media` bucket using the "{media_id}/{media_id}_{secret}_{size}.{extension}" naming
+// scheme. Media files that are missing or empty, originals whose hash doesn't match the hash recorded in the record
+// and media files in the bucket that don't belong to any record (orphans) are emitted as line-separated JSON to STDOUT
+// followed by a summary table (to STDERR). For example:
+//
+//	$> ./bin/verify-media -iterator-source /usr/local/data/sfomuseum-data-socialmedia-instagram
+//	{"type":"missing","key":"8b1f.../8b1f..._7bc63e2e17_o.jpg","media_id":"8b1f...","wof_id":1729355023,"path":"..."}
+//	PROBLEM        COUNT
+//	missing        1
+//	empty          0
+//	hash_mismatch  0
+//	orphaned       0
+//	total          1
+//	checked        5120
+//
+// The tool will exit with a non-zero status code if any problems are found.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	_ "github.com/aaronland/gocloud-blob/s3"
+	_ "gocloud.dev/blob/fileblob"
+
+	"github.com/sfomuseum/go-sfomuseum-instagram-publish"
+	"gocloud.dev/blob"
+)
+
+func main() {
+
+	media_bucket_uri := flag.String("media-bucket-uri", "s3blob://sfomuseum-media?prefix=media/instagram/&region=us-west-2&credentials=session", "A valid gocloud.dev/blob URI where SFO Museum Instagram media files are stored.")
+
+	iterator_uri := flag.String("iterator-uri", "repo://", "A valid whosonfirst/go-whosonfirst-iterate/v2 URI")
+	iterator_source := flag.String("iterator-source", "/usr/local/data/sfomuseum-data-socialmedia-instagram", "...")
+
+	check_hashes := flag.Bool("check-hashes", true, "Read each original media file and compare its hash to the perceptual (or file) hash recorded in its record.")
+	hash_threshold := flag.Int("hash-threshold", 0, "The maximum Hamming distance between perceptual hashes before they are reported as a mismatch.")
+
+	max_media_reads := flag.Int("max-media-reads", 10, "The maximum number of concurrent reads from the media bucket. If 0 concurrent reads are not limited.")
+	media_reads_per_second := flag.Float64("media-reads-per-second", 0, "The maximum number of reads from the media bucket to start per second. If 0 reads are not rate limited.")
+
+	flag.Parse()
+
+	ctx := context.Background()
+
+	media_bucket, err := blob.OpenBucket(ctx, *media_bucket_uri)
+
+	if err != nil {
+		log.Fatalf("Failed to open media bucket, %v", err)
+	}
+
+	defer media_bucket.Close()
+
+	verify_opts := &publish.VerifyMediaOptions{
+		Bucket:         media_bucket,
+		IteratorURI:    *iterator_uri,
+		IteratorSource: *iterator_source,
+		CheckHashes:    *check_hashes,
+		HashThreshold:  *hash_threshold,
+		Limiter:        publish.NewLimiter(*max_media_reads, *media_reads_per_second),
+	}
+
+	v, err := publish.VerifyMedia(ctx, verify_opts)
+
+	if err != nil {
+		log.Fatalf("Failed to verify media, %v", err)
+	}
+
+	err = v.WriteJSONLines(os.Stdout)
+
+	if err != nil {
+		log.Fatalf("Failed to write problems, %v", err)
+	}
+
+	err = v.WriteSummary(os.Stderr)
+
+	if err != nil {
+		log.Fatalf("Failed to write summary, %v", err)
+	}
+
+	if len(v.Problems()) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,213 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"path"
+
+	"github.com/nfnt/resize"
+	"github.com/sfomuseum/go-sfomuseum-instagram-publish/secret"
+	"gocloud.dev/blob"
+)
+
+// SIZES_PROPERTY is the WOF property where the sizes of the media files uploaded for a post are stored.
+const SIZES_PROPERTY string = "instagram:sizes"
+
+// SLIDE_SIZES_PROPERTY is the WOF property where the sizes of the media files uploaded for the slides of a carousel
+// post (other than the first, whose sizes are stored in `SIZES_PROPERTY`) are stored, keyed by slide media ID.
+const SLIDE_SIZES_PROPERTY string = "instagram:slide_sizes"
+
+// SIZE_ORIGINAL is the label for the original (unmodified) media file.
+const SIZE_ORIGINAL string = "o"
+
+// DERIVATIVE_JPEG_QUALITY is the JPEG quality used to encode resized derivatives.
+const DERIVATIVE_JPEG_QUALITY int = 85
+
+// DerivativeSize is a struct defining a resized derivative of an image.
+type DerivativeSize struct {
+	// Label is the label used in the derivative's filename, for example "b" for "{media_id}_{secret}_b.jpg".
+	Label string
+	// MaxDimension is the maximum width or height of the derivative, in pixels. Images are never enlarged.
+	MaxDimension uint
+	// Square is a boolean flag indicating that the derivative should be cropped (around its centre) to a square.
+	Square bool
+}
+
+// DEFAULT_DERIVATIVE_SIZES is the default list of derivatives generated for images.
+var DEFAULT_DERIVATIVE_SIZES = []*DerivativeSize{
+	{Label: "b", MaxDimension: 1024},
+	{Label: "z", MaxDimension: 640},
+	{Label: "sq", MaxDimension: 150, Square: true},
+}
+
+// MediaSize is a struct describing an individual media file (the original or a derivative) uploaded for a post.
+type MediaSize struct {
+	// Width is the width of the file in pixels. It is zero for media that are not images.
+	Width int `json:"width,omitempty"`
+	// Height is the height of the file in pixels. It is zero for media that are not images.
+	Height int `json:"height,omitempty"`
+	// Extension is the file extension of the file.
+	Extension string `json:"extension"`
+}
+
+// UploadDerivativesOptions is a struct containing configuration options for the `UploadDerivatives` method.
+type UploadDerivativesOptions struct {
+	// Bucket is the gocloud.dev/blob bucket where media files will be uploaded.
+	Bucket *blob.Bucket
+	// Sizes is the list of derivatives to generate for images. If nil `DEFAULT_DERIVATIVE_SIZES` is used.
+	Sizes []*DerivativeSize
+}
+
+// extensions maps MIME types to the file extensions used for uploaded originals.
+var extensions = map[string]string{
+	"image/jpeg":      "jpg",
+	"image/png":       "png",
+	"image/gif":       "gif",
+	"image/webp":      "webp",
+	"image/heic":      "heic",
+	"image/heif":      "heif",
+	"image/avif":      "avif",
+	"video/mp4":       "mp4",
+	"video/quicktime": "mov",
+	"video/x-m4v":     "m4v",
+	"video/3gpp":      "3gp",
+}
+
+// DerivativeKey returns the key, relative to the root of a media bucket, for the file with size 'label' and extension
+// 'ext' of the media file identified by 'media_id'. For example "{media_id}/{media_id}_{secret}_o.jpg" where secret is
+// derived using `secret.DeriveSecret`.
+func DerivativeKey(media_id string, label string, ext string) string {
+	fname := fmt.Sprintf("%s_%s_%s.%s", media_id, secret.DeriveSecret(media_id), label, ext)
+	return path.Join(media_id, fname)
+}
+
+// UploadDerivatives uploads 'body', the media file with MIME type 'mime_type' for the media ID 'media_id', to the
+// bucket defined in 'opts' as well as (JPEG-encoded) resized derivatives if it is an image that can be decoded.
+// It returns a map of the sizes that were uploaded keyed by their label.
+func UploadDerivatives(ctx context.Context, opts *UploadDerivativesOptions, media_id string, mime_type string, body []byte) (map[string]*MediaSize, error) {
+
+	ext, ok := extensions[mime_type]
+
+	if !ok {
+		return nil, fmt.Errorf("Unsupported MIME type '%s'", mime_type)
+	}
+
+	sizes := make(map[string]*MediaSize)
+
+	original := &MediaSize{
+		Extension: ext,
+	}
+
+	if perceptual_mimetypes[mime_type] {
+
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(body))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode image config, %w", err)
+		}
+
+		original.Width = cfg.Width
+		original.Height = cfg.Height
+	}
+
+	err := uploadMedia(ctx, opts.Bucket, DerivativeKey(media_id, SIZE_ORIGINAL, ext), mime_type, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sizes[SIZE_ORIGINAL] = original
+
+	if !perceptual_mimetypes[mime_type] {
+		return sizes, nil
+	}
+
+	im, _, err := image.Decode(bytes.NewReader(body))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode image, %w", err)
+	}
+
+	derivative_sizes := opts.Sizes
+
+	if derivative_sizes == nil {
+		derivative_sizes = DEFAULT_DERIVATIVE_SIZES
+	}
+
+	for _, sz := range derivative_sizes {
+
+		dr_im := ResizeImage(im, sz)
+
+		var buf bytes.Buffer
+
+		err := jpeg.Encode(&buf, dr_im, &jpeg.Options{Quality: DERIVATIVE_JPEG_QUALITY})
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encode %s derivative, %w", sz.Label, err)
+		}
+
+		err = uploadMedia(ctx, opts.Bucket, DerivativeKey(media_id, sz.Label, "jpg"), "image/jpeg", buf.Bytes())
+
+		if err != nil {
+			return nil, err
+		}
+
+		bounds := dr_im.Bounds()
+
+		sizes[sz.Label] = &MediaSize{
+			Width:     bounds.Dx(),
+			Height:    bounds.Dy(),
+			Extension: "jpg",
+		}
+	}
+
+	return sizes, nil
+}
+
+// ResizeImage returns a copy of 'im' resized (and optionally cropped) according to 'sz'.
+func ResizeImage(im image.Image, sz *DerivativeSize) image.Image {
+
+	if sz.Square {
+
+		bounds := im.Bounds()
+		w := bounds.Dx()
+		h := bounds.Dy()
+
+		side := w
+
+		if h < side {
+			side = h
+		}
+
+		x0 := bounds.Min.X + (w-side)/2
+		y0 := bounds.Min.Y + (h-side)/2
+
+		crop := image.Rect(x0, y0, x0+side, y0+side)
+
+		if sub, ok := im.(interface {
+			SubImage(image.Rectangle) image.Image
+		}); ok {
+			im = sub.SubImage(crop)
+		}
+	}
+
+	return resize.Thumbnail(sz.MaxDimension, sz.MaxDimension, im, resize.Lanczos3)
+}
+
+func uploadMedia(ctx context.Context, bucket *blob.Bucket, key string, content_type string, body []byte) error {
+
+	wr_opts := &blob.WriterOptions{
+		ContentType: content_type,
+	}
+
+	err := bucket.WriteAll(ctx, key, body, wr_opts)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write %s, %w", key, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/fileblob"
+)
+
+func TestUploadDerivatives(t *testing.T) {
+
+	ctx := context.Background()
+
+	bucket, err := blob.OpenBucket(ctx, "file://"+t.TempDir())
+
+	if err != nil {
+		t.Fatalf("Failed to open bucket, %v", err)
+	}
+
+	defer bucket.Close()
+
+	im := image.NewRGBA(image.Rect(0, 0, 1600, 1200))
+
+	for x := 0; x < 1600; x++ {
+		im.Set(x, 600, color.RGBA{255, 0, 0, 255})
+	}
+
+	var buf bytes.Buffer
+
+	err = jpeg.Encode(&buf, im, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to encode image, %v", err)
+	}
+
+	opts := &UploadDerivativesOptions{
+		Bucket: bucket,
+	}
+
+	media_id := "7784f12fc1c2315991c8afd2542fbc09"
+
+	sizes, err := UploadDerivatives(ctx, opts, media_id, "image/jpeg", buf.Bytes())
+
+	if err != nil {
+		t.Fatalf("Failed to upload derivatives, %v", err)
+	}
+
+	expected := map[string][2]int{
+		"o":  {1600, 1200},
+		"b":  {1024, 768},
+		"z":  {640, 480},
+		"sq": {150, 150},
+	}
+
+	for label, dims := range expected {
+
+		sz, ok := sizes[label]
+
+		if !ok {
+			t.Fatalf("Missing %s size", label)
+		}
+
+		if sz.Width != dims[0] || sz.Height != dims[1] {
+			t.Fatalf("Unexpected dimensions for %s: %dx%d", label, sz.Width, sz.Height)
+		}
+
+		exists, err := bucket.Exists(ctx, DerivativeKey(media_id, label, sz.Extension))
+
+		if err != nil {
+			t.Fatalf("Failed to determine whether %s exists, %v", label, err)
+		}
+
+		if !exists {
+			t.Fatalf("Missing %s derivative", label)
+		}
+	}
+
+	if DerivativeKey(media_id, "o", "jpg") != "7784f12fc1c2315991c8afd2542fbc09/7784f12fc1c2315991c8afd2542fbc09_7bc63e2e17_o.jpg" {
+		t.Fatalf("Unexpected key: %s", DerivativeKey(media_id, "o", "jpg"))
+	}
+}
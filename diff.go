@@ -0,0 +1,95 @@
+package publish
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// PropertyChange is a struct describing a single property that differs between two versions of a WOF record.
+type PropertyChange struct {
+	// Property is the (gjson-style) dotted path of the property that changed, for example "properties.instagram:post.caption.body".
+	Property string `json:"property"`
+	// Old is the previous value of the property. It will be nil if the property was added.
+	Old interface{} `json:"old,omitempty"`
+	// New is the updated value of the property. It will be nil if the property was removed.
+	New interface{} `json:"new,omitempty"`
+}
+
+// DiffRecords returns the list of property-level changes between the JSON encoded records 'old_record' and 'new_record'
+// sorted by property. Nested objects are compared key by key; all other values (including arrays) are compared as a whole.
+func DiffRecords(old_record []byte, new_record []byte) ([]*PropertyChange, error) {
+
+	var old_f interface{}
+	var new_f interface{}
+
+	err := json.Unmarshal(old_record, &old_f)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal old record, %w", err)
+	}
+
+	err = json.Unmarshal(new_record, &new_f)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal new record, %w", err)
+	}
+
+	changes := make([]*PropertyChange, 0)
+	changes = diffValues("", old_f, new_f, changes)
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Property < changes[j].Property
+	})
+
+	return changes, nil
+}
+
+func diffValues(prefix string, old_v interface{}, new_v interface{}, changes []*PropertyChange) []*PropertyChange {
+
+	old_m, old_ok := old_v.(map[string]interface{})
+	new_m, new_ok := new_v.(map[string]interface{})
+
+	if !old_ok || !new_ok {
+
+		if !reflect.DeepEqual(old_v, new_v) {
+
+			ch := &PropertyChange{
+				Property: prefix,
+				Old:      old_v,
+				New:      new_v,
+			}
+
+			changes = append(changes, ch)
+		}
+
+		return changes
+	}
+
+	for k, v := range old_m {
+		changes = diffValues(joinPropertyPath(prefix, k), v, new_m[k], changes)
+	}
+
+	for k, v := range new_m {
+
+		_, exists := old_m[k]
+
+		if exists {
+			continue
+		}
+
+		changes = diffValues(joinPropertyPath(prefix, k), nil, v, changes)
+	}
+
+	return changes
+}
+
+func joinPropertyPath(prefix string, k string) string {
+
+	if prefix == "" {
+		return k
+	}
+
+	return fmt.Sprintf("%s.%s", prefix, k)
+}
@@ -0,0 +1,54 @@
+package publish
+
+import (
+	"testing"
+)
+
+func TestDiffRecords(t *testing.T) {
+
+	old_record := []byte(`{"properties":{"wof:name":"Hello..","instagram:post":{"caption":{"body":"Hello world","hashtags":["sfo"]},"perceptual_hash":"p:b867679231ccc633"}}}`)
+
+	new_record := []byte(`{"properties":{"wof:name":"Hello..","instagram:post":{"caption":{"body":"Hello world!","hashtags":["sfo","museum"]},"file_hash":"abc"}}}`)
+
+	changes, err := DiffRecords(old_record, new_record)
+
+	if err != nil {
+		t.Fatalf("Failed to diff records, %v", err)
+	}
+
+	expected := []string{
+		"properties.instagram:post.caption.body",
+		"properties.instagram:post.caption.hashtags",
+		"properties.instagram:post.file_hash",
+		"properties.instagram:post.perceptual_hash",
+	}
+
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %d", len(expected), len(changes))
+	}
+
+	for i, ch := range changes {
+
+		if ch.Property != expected[i] {
+			t.Fatalf("Expected change %d to be '%s', got '%s'", i, expected[i], ch.Property)
+		}
+	}
+
+	if changes[2].Old != nil {
+		t.Fatalf("Expected added property to have nil old value")
+	}
+
+	if changes[3].New != nil {
+		t.Fatalf("Expected removed property to have nil new value")
+	}
+
+	changes, err = DiffRecords(old_record, old_record)
+
+	if err != nil {
+		t.Fatalf("Failed to diff identical records, %v", err)
+	}
+
+	if len(changes) != 0 {
+		t.Fatalf("Expected no changes for identical records, got %d", len(changes))
+	}
+}
@@ -0,0 +1,352 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/corona10/goimagehash"
+	"github.com/sfomuseum/go-sfomuseum-instagram/media"
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/iterator"
+)
+
+// DuplicateRecord is a struct describing a WOF record in a `DuplicateCluster`.
+type DuplicateRecord struct {
+	// WOFId is the WOF ID of the record.
+	WOFId int64 `json:"wof_id"`
+	// Path is the path (in the data repository) of the record.
+	Path string `json:"path"`
+	// MediaId is the (SFO Museum) media ID of the record.
+	MediaId string `json:"media_id,omitempty"`
+	// Hash is the perceptual hash (or, for videos, the video perceptual hash) of the record's media file.
+	Hash string `json:"hash"`
+	// Caption is the caption excerpt (or name) of the record.
+	Caption string `json:"caption,omitempty"`
+	// TakenAt is the (wall-clock) datetime string when the post was taken.
+	TakenAt string `json:"taken_at,omitempty"`
+	// Taken is the Unix timestamp when the post was taken.
+	Taken int64 `json:"taken,omitempty"`
+	// hashes is the parsed list of perceptual hashes in Hash.
+	hashes []*goimagehash.ImageHash
+}
+
+// DuplicatePair is a struct describing two records in a `DuplicateCluster` whose perceptual hashes are within
+// the threshold defined in `FindDuplicatesOptions`.
+type DuplicatePair struct {
+	// A is the WOF ID of the first record.
+	A int64 `json:"a"`
+	// B is the WOF ID of the second record.
+	B int64 `json:"b"`
+	// Distance is the Hamming distance between the perceptual hashes of the two records.
+	Distance int `json:"distance"`
+}
+
+// DuplicateCluster is a struct describing a group of records which are likely to be duplicates of one another.
+// Records are grouped if they are linked by one or more pairs whose perceptual hashes are within the threshold
+// defined in `FindDuplicatesOptions`.
+type DuplicateCluster struct {
+	// Records is the list of records in the cluster sorted by WOF ID.
+	Records []*DuplicateRecord `json:"records"`
+	// Pairs is the list of pairs which link the records in the cluster.
+	Pairs []*DuplicatePair `json:"pairs"`
+}
+
+// FindDuplicatesOptions is a struct containing configuration options for the `FindDuplicates` method.
+type FindDuplicatesOptions struct {
+	// IteratorURI is a valid whosonfirst/go-whosonfirst-iterate/v2 URI.
+	IteratorURI string
+	// IteratorSource is the URI (or path) of the data repository to iterate.
+	IteratorSource string
+	// Threshold is the maximum Hamming distance between perceptual hashes for two records to be considered duplicates.
+	Threshold int
+	// TimeWindow is the optional maximum difference between the times two posts were taken for them to be considered
+	// duplicates. If zero the times posts were taken are not compared. Otherwise records without a taken time
+	// are skipped.
+	TimeWindow time.Duration
+}
+
+// FindDuplicates iterates the data repository defined in 'opts' and returns clusters of records whose perceptual
+// (or video perceptual) hashes are within 'opts.Threshold' of one another and, optionally, which were taken within
+// 'opts.TimeWindow' of one another. Records without a perceptual hash, or which have been superseded, are skipped as
+// are records without a taken time if 'opts.TimeWindow' is greater than zero.
+func FindDuplicates(ctx context.Context, opts *FindDuplicatesOptions) ([]*DuplicateCluster, error) {
+
+	mu := new(sync.Mutex)
+	records := make([]*DuplicateRecord, 0)
+
+	iter_cb := func(ctx context.Context, path string, r io.ReadSeeker, args ...interface{}) error {
+
+		body, err := io.ReadAll(r)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read %s, %w", path, err)
+		}
+
+		if len(gjson.GetBytes(body, "properties.wof:superseded_by").Array()) > 0 {
+			return nil
+		}
+
+		phash_rsp := gjson.GetBytes(body, "properties.instagram:post.perceptual_hash")
+
+		if !phash_rsp.Exists() {
+			phash_rsp = gjson.GetBytes(body, "properties.instagram:post.video_hash")
+		}
+
+		if !phash_rsp.Exists() {
+			slog.Debug("Record is missing perceptual hash, skipping", "path", path)
+			return nil
+		}
+
+		hashes, err := parsePerceptualHashes(phash_rsp.String())
+
+		if err != nil {
+			return fmt.Errorf("Failed to parse perceptual hash for %s, %w", path, err)
+		}
+
+		caption := gjson.GetBytes(body, "properties.instagram:post.caption.excerpt").String()
+
+		if caption == "" {
+			caption = gjson.GetBytes(body, "properties.wof:name").String()
+		}
+
+		rec := &DuplicateRecord{
+			WOFId:   gjson.GetBytes(body, "properties.wof:id").Int(),
+			Path:    path,
+			MediaId: gjson.GetBytes(body, "properties.instagram:post.media_id").String(),
+			Hash:    phash_rsp.String(),
+			Caption: caption,
+			TakenAt: gjson.GetBytes(body, "properties.instagram:post.taken_at").String(),
+			Taken:   gjson.GetBytes(body, "properties.instagram:post.taken").Int(),
+			hashes:  hashes,
+		}
+
+		if rec.Taken == 0 && rec.TakenAt != "" {
+
+			t, err := media.ParseTime(rec.TakenAt)
+
+			if err == nil {
+				rec.Taken = t.Unix()
+			}
+		}
+
+		mu.Lock()
+		records = append(records, rec)
+		mu.Unlock()
+
+		return nil
+	}
+
+	iter, err := iterator.NewIterator(ctx, opts.IteratorURI, iter_cb)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create iterator, %w", err)
+	}
+
+	err = iter.IterateURIs(ctx, opts.IteratorSource)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to iterate %s, %w", opts.IteratorSource, err)
+	}
+
+	return clusterDuplicates(records, opts.Threshold, opts.TimeWindow)
+}
+
+// WriteDuplicateClustersJSONLines writes each `DuplicateCluster` in 'clusters' to 'wr' as a line-separated JSON record.
+func WriteDuplicateClustersJSONLines(wr io.Writer, clusters []*DuplicateCluster) error {
+
+	enc := json.NewEncoder(wr)
+
+	for _, c := range clusters {
+
+		err := enc.Encode(c)
+
+		if err != nil {
+			return fmt.Errorf("Failed to encode cluster, %w", err)
+		}
+	}
+
+	return nil
+}
+
+// WriteDuplicateClustersSummary writes a table summarizing 'clusters' to 'wr'.
+func WriteDuplicateClustersSummary(wr io.Writer, clusters []*DuplicateCluster) error {
+
+	records := 0
+
+	for _, c := range clusters {
+		records += len(c.Records)
+	}
+
+	tw := tabwriter.NewWriter(wr, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "DUPLICATES\tCOUNT\n")
+	fmt.Fprintf(tw, "clusters\t%d\n", len(clusters))
+	fmt.Fprintf(tw, "records\t%d\n", records)
+
+	return tw.Flush()
+}
+
+// clusterDuplicates groups 'records' in to clusters of records whose perceptual hashes are within 'threshold' of one
+// another (and, if 'window' is greater than zero, which were taken within 'window' of one another). If 'window' is
+// greater than zero records without a taken time can't be compared with any other record so they are skipped.
+func clusterDuplicates(records []*DuplicateRecord, threshold int, window time.Duration) ([]*DuplicateCluster, error) {
+
+	if window > 0 {
+
+		dated := make([]*DuplicateRecord, 0, len(records))
+
+		for _, r := range records {
+
+			if r.Taken == 0 {
+				slog.Warn("Record is missing taken time, skipping", "id", r.WOFId, "path", r.Path)
+				continue
+			}
+
+			dated = append(dated, r)
+		}
+
+		records = dated
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+
+		if records[i].Taken != records[j].Taken {
+			return records[i].Taken < records[j].Taken
+		}
+
+		return records[i].WOFId < records[j].WOFId
+	})
+
+	// Union-find (disjoint set) of offsets in records
+
+	parents := make([]int, len(records))
+
+	for i := range parents {
+		parents[i] = i
+	}
+
+	var find func(int) int
+
+	find = func(i int) int {
+
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+
+		return parents[i]
+	}
+
+	pairs := make([]*DuplicatePair, 0)
+
+	for i, a := range records {
+
+		for j := i + 1; j < len(records); j++ {
+
+			b := records[j]
+
+			// Records are sorted by the time they were taken so there is no need to look any further
+
+			if window > 0 && time.Duration(b.Taken-a.Taken)*time.Second > window {
+				break
+			}
+
+			d, ok, err := duplicateDistance(a, b)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if !ok || d > threshold {
+				continue
+			}
+
+			pairs = append(pairs, &DuplicatePair{A: a.WOFId, B: b.WOFId, Distance: d})
+			parents[find(j)] = find(i)
+		}
+	}
+
+	offsets := make(map[int64]int)
+
+	for i, r := range records {
+		offsets[r.WOFId] = i
+	}
+
+	clusters_map := make(map[int]*DuplicateCluster)
+
+	for _, p := range pairs {
+
+		root := find(offsets[p.A])
+
+		c, exists := clusters_map[root]
+
+		if !exists {
+			c = &DuplicateCluster{
+				Records: make([]*DuplicateRecord, 0),
+				Pairs:   make([]*DuplicatePair, 0),
+			}
+
+			clusters_map[root] = c
+		}
+
+		c.Pairs = append(c.Pairs, p)
+	}
+
+	for i, r := range records {
+
+		c, exists := clusters_map[find(i)]
+
+		if exists {
+			c.Records = append(c.Records, r)
+		}
+	}
+
+	clusters := make([]*DuplicateCluster, 0)
+
+	for _, c := range clusters_map {
+
+		sort.Slice(c.Records, func(i, j int) bool {
+			return c.Records[i].WOFId < c.Records[j].WOFId
+		})
+
+		clusters = append(clusters, c)
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Records[0].WOFId < clusters[j].Records[0].WOFId
+	})
+
+	return clusters, nil
+}
+
+// duplicateDistance returns the Hamming distance between the perceptual hashes of 'a' and 'b' and a boolean value
+// indicating whether they are comparable, using the same rules as `PerceptualHashDistance`.
+func duplicateDistance(a *DuplicateRecord, b *DuplicateRecord) (int, bool, error) {
+
+	if len(a.hashes) != len(b.hashes) {
+		return 0, false, nil
+	}
+
+	max_distance := 0
+
+	for i, h := range a.hashes {
+
+		d, err := h.Distance(b.hashes[i])
+
+		if err != nil {
+			return 0, false, fmt.Errorf("Failed to derive distance between %d and %d, %w", a.WOFId, b.WOFId, err)
+		}
+
+		if d > max_distance {
+			max_distance = d
+		}
+	}
+
+	return max_distance, true, nil
+}
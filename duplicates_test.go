@@ -0,0 +1,78 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFindDuplicates(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo := t.TempDir()
+
+	records := []string{
+		`{"wof:id":1,"instagram:post":{"perceptual_hash":"p:ff00ff00ff00ff00","taken":1000}}`,
+		`{"wof:id":2,"instagram:post":{"perceptual_hash":"p:ff00ff00ff00ff01","taken":1060}}`,
+		`{"wof:id":3,"instagram:post":{"perceptual_hash":"p:ff00ff00ff00ff03","taken":100000}}`,
+		`{"wof:id":4,"instagram:post":{"perceptual_hash":"p:00ff00ff00ff00ff","taken":1000}}`,
+		`{"wof:id":5,"wof:superseded_by":[1],"instagram:post":{"perceptual_hash":"p:ff00ff00ff00ff00","taken":1000}}`,
+		`{"wof:id":6,"instagram:post":{"perceptual_hash":"p:ff00ff00ff00ff02"}}`,
+		`{"wof:id":7,"instagram:post":{"perceptual_hash":"p:ff00ff00ff00ff00"}}`,
+	}
+
+	for i, props := range records {
+
+		body := fmt.Sprintf(`{"type":"Feature","properties":%s}`, props)
+
+		err := os.WriteFile(filepath.Join(repo, fmt.Sprintf("%d.geojson", i+1)), []byte(body), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write record, %v", err)
+		}
+	}
+
+	// Records 6 and 7 are undated so they are only compared when there is no time window
+
+	tests := map[time.Duration][]int64{
+		0:         {1, 2, 3, 6, 7},
+		time.Hour: {1, 2},
+	}
+
+	for window, expected := range tests {
+
+		opts := &FindDuplicatesOptions{
+			IteratorURI:    "directory://",
+			IteratorSource: repo,
+			Threshold:      4,
+			TimeWindow:     window,
+		}
+
+		clusters, err := FindDuplicates(ctx, opts)
+
+		if err != nil {
+			t.Fatalf("Failed to find duplicates, %v", err)
+		}
+
+		if len(clusters) != 1 {
+			t.Fatalf("Expected 1 cluster with window %v, got %d", window, len(clusters))
+		}
+
+		c := clusters[0]
+
+		if len(c.Records) != len(expected) {
+			t.Fatalf("Expected %d records with window %v, got %d", len(expected), window, len(c.Records))
+		}
+
+		for i, id := range expected {
+
+			if c.Records[i].WOFId != id {
+				t.Fatalf("Expected record %d at offset %d with window %v, got %d", id, i, window, c.Records[i].WOFId)
+			}
+		}
+	}
+}
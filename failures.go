@@ -0,0 +1,173 @@
+package publish
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// PublishError is an error returned by `PublishMedia` recording the post and the pipeline stage that failed.
+type PublishError struct {
+	// Path is the (relative) media path of the post.
+	Path string
+	// Stage is the pipeline stage that failed.
+	Stage StageName
+	// Err is the underlying error.
+	Err error
+}
+
+// NewPublishError returns a new `PublishError` instance wrapping 'err'.
+func NewPublishError(path string, stage StageName, err error) *PublishError {
+
+	e := &PublishError{
+		Path:  path,
+		Stage: stage,
+		Err:   err,
+	}
+
+	return e
+}
+
+// Error returns the error message for the underlying error.
+func (e *PublishError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *PublishError) Unwrap() error {
+	return e.Err
+}
+
+// Failure is a struct describing an Instagram post that failed to be published.
+type Failure struct {
+	// Path is the (relative) media path of the post.
+	Path string `json:"path"`
+	// Stage is the pipeline stage that failed. It is empty if the stage is not known.
+	Stage StageName `json:"stage,omitempty"`
+	// Error is the error message.
+	Error string `json:"error"`
+}
+
+// FailureReport is a thread-safe collection of `Failure` instances.
+type FailureReport struct {
+	mu       *sync.RWMutex
+	failures []*Failure
+}
+
+// NewFailureReport returns a new (empty) `FailureReport` instance.
+func NewFailureReport() *FailureReport {
+
+	r := &FailureReport{
+		mu:       new(sync.RWMutex),
+		failures: make([]*Failure, 0),
+	}
+
+	return r
+}
+
+// Add adds a `Failure` for the post with media path 'path' to the report. If 'err' is (or wraps) a
+// `PublishError` its stage will be recorded.
+func (r *FailureReport) Add(path string, err error) {
+
+	f := &Failure{
+		Path:  path,
+		Error: err.Error(),
+	}
+
+	var publish_err *PublishError
+
+	if errors.As(err, &publish_err) {
+		f.Stage = publish_err.Stage
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failures = append(r.failures, f)
+}
+
+// Count returns the number of failures in the report.
+func (r *FailureReport) Count() int {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.failures)
+}
+
+// Failures returns the list of failures in the report sorted by path.
+func (r *FailureReport) Failures() []*Failure {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	failures := make([]*Failure, len(r.failures))
+	copy(failures, r.failures)
+
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Path < failures[j].Path
+	})
+
+	return failures
+}
+
+// WriteJSONLines writes each failure in the report as line-separated JSON to 'wr'.
+func (r *FailureReport) WriteJSONLines(wr io.Writer) error {
+
+	enc := json.NewEncoder(wr)
+
+	for _, f := range r.Failures() {
+
+		err := enc.Encode(f)
+
+		if err != nil {
+			return fmt.Errorf("Failed to encode failure for %s, %w", f.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// WriteSummary writes a table of failure counts, grouped by stage, to 'wr'.
+func (r *FailureReport) WriteSummary(wr io.Writer) error {
+
+	counts := make(map[string]int)
+
+	for _, f := range r.Failures() {
+
+		k := string(f.Stage)
+
+		if k == "" {
+			k = "unknown"
+		}
+
+		counts[k] += 1
+	}
+
+	keys := make([]string, 0)
+
+	for k := range counts {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(wr, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "STAGE\tFAILURES\n")
+
+	total := 0
+
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s\t%d\n", k, counts[k])
+		total += counts[k]
+	}
+
+	fmt.Fprintf(tw, "total\t%d\n", total)
+
+	return tw.Flush()
+}
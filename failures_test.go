@@ -0,0 +1,102 @@
+package publish
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPublishError(t *testing.T) {
+
+	base_err := errors.New("Nope")
+	err := fmt.Errorf("Failed to publish, %w", NewPublishError("media/posts/a.jpg", STAGE_HASHING, base_err))
+
+	var publish_err *PublishError
+
+	if !errors.As(err, &publish_err) {
+		t.Fatalf("Expected error to wrap PublishError")
+	}
+
+	if publish_err.Stage != STAGE_HASHING || publish_err.Path != "media/posts/a.jpg" {
+		t.Fatalf("Unexpected stage or path: %s %s", publish_err.Stage, publish_err.Path)
+	}
+
+	if publish_err.Error() != "Nope" {
+		t.Fatalf("Unexpected error message: %s", publish_err.Error())
+	}
+
+	if !errors.Is(err, base_err) {
+		t.Fatalf("Expected error to wrap underlying error")
+	}
+}
+
+func TestFailureReport(t *testing.T) {
+
+	r := NewFailureReport()
+
+	r.Add("media/posts/c.jpg", NewPublishError("media/posts/c.jpg", STAGE_WRITE, errors.New("Write failed")))
+	r.Add("media/posts/a.jpg", fmt.Errorf("Failed to publish, %w", NewPublishError("media/posts/a.jpg", STAGE_HASHING, errors.New("Hash failed"))))
+	r.Add("media/posts/b.jpg", errors.New("Unknown failure"))
+	r.Add("media/posts/d.jpg", NewPublishError("media/posts/d.jpg", STAGE_HASHING, errors.New("Hash failed")))
+
+	if r.Count() != 4 {
+		t.Fatalf("Unexpected count: %d", r.Count())
+	}
+
+	var buf bytes.Buffer
+
+	err := r.WriteJSONLines(&buf)
+
+	if err != nil {
+		t.Fatalf("Failed to write JSON lines, %v", err)
+	}
+
+	expected := []*Failure{
+		{Path: "media/posts/a.jpg", Stage: STAGE_HASHING, Error: "Failed to publish, Hash failed"},
+		{Path: "media/posts/b.jpg", Error: "Unknown failure"},
+		{Path: "media/posts/c.jpg", Stage: STAGE_WRITE, Error: "Write failed"},
+		{Path: "media/posts/d.jpg", Stage: STAGE_HASHING, Error: "Hash failed"},
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d", len(expected), len(lines))
+	}
+
+	for i, ln := range lines {
+
+		var f Failure
+
+		err := json.Unmarshal([]byte(ln), &f)
+
+		if err != nil {
+			t.Fatalf("Failed to unmarshal line %d, %v", i, err)
+		}
+
+		if f != *expected[i] {
+			t.Fatalf("Unexpected failure at line %d: %s", i, ln)
+		}
+	}
+
+	if strings.Contains(lines[1], `"stage"`) {
+		t.Fatalf("Expected failure without a stage to omit it: %s", lines[1])
+	}
+
+	buf.Reset()
+
+	err = r.WriteSummary(&buf)
+
+	if err != nil {
+		t.Fatalf("Failed to write summary, %v", err)
+	}
+
+	summary := strings.Join(strings.Fields(buf.String()), " ")
+
+	if summary != "STAGE FAILURES hashing 2 unknown 1 write 1 total 4" {
+		t.Fatalf("Unexpected summary: %s", buf.String())
+	}
+}
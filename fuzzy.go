@@ -0,0 +1,147 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/sfomuseum/go-sfomuseum-instagram/media"
+)
+
+// PerceptualHashEntry is a struct associating a perceptual hash with a WOF ID.
+type PerceptualHashEntry struct {
+	// WOFId is the WOF ID of the record the hash belongs to.
+	WOFId int64 `json:"wof_id"`
+	// Hash is the perceptual hash (as produced by `goimagehash.ImageHash.ToString`) of the record's media file
+	// or, for videos, the video perceptual hash produced by `VideoPerceptualHash`.
+	Hash string `json:"hash"`
+}
+
+// PerceptualHashIndex is a thread-safe index of perceptual hashes grouped by the minute an Instagram post was
+// taken. It is used to match posts whose images Instagram has re-encoded between exports, causing their perceptual
+// hashes (and derived media IDs) to change slightly, to existing records.
+type PerceptualHashIndex struct {
+	mu      *sync.RWMutex
+	entries map[string][]*PerceptualHashEntry
+}
+
+// NewPerceptualHashIndex returns a new (empty) `PerceptualHashIndex` instance.
+func NewPerceptualHashIndex() *PerceptualHashIndex {
+
+	idx := &PerceptualHashIndex{
+		mu:      new(sync.RWMutex),
+		entries: make(map[string][]*PerceptualHashEntry),
+	}
+
+	return idx
+}
+
+// Add associates 'phash' with 'wof_id' for posts taken at 'taken_at'.
+func (idx *PerceptualHashIndex) Add(ctx context.Context, taken_at string, phash string, wof_id int64) error {
+
+	k, err := perceptualHashIndexKey(taken_at)
+
+	if err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, e := range idx.entries[k] {
+
+		if e.WOFId == wof_id && e.Hash == phash {
+			return nil
+		}
+	}
+
+	e := &PerceptualHashEntry{
+		WOFId: wof_id,
+		Hash:  phash,
+	}
+
+	idx.entries[k] = append(idx.entries[k], e)
+	return nil
+}
+
+// removeIds removes every entry in 'idx' associated with one of the WOF IDs in 'ids'.
+func (idx *PerceptualHashIndex) removeIds(ids map[int64]bool) {
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for k, entries := range idx.entries {
+
+		kept := make([]*PerceptualHashEntry, 0, len(entries))
+
+		for _, e := range entries {
+
+			if !ids[e.WOFId] {
+				kept = append(kept, e)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(idx.entries, k)
+			continue
+		}
+
+		idx.entries[k] = kept
+	}
+}
+
+// Match returns the WOF ID and Hamming distance of the entry taken in the same minute as 'taken_at' whose
+// hash is closest to 'phash' (an image or video perceptual hash), provided that distance is less than or equal
+// to 'threshold'. The final boolean value indicates whether a match was found.
+func (idx *PerceptualHashIndex) Match(ctx context.Context, taken_at string, phash string, threshold int) (int64, int, bool, error) {
+
+	k, err := perceptualHashIndexKey(taken_at)
+
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var match_id int64
+	match_distance := -1
+
+	for _, e := range idx.entries[k] {
+
+		d, comparable, err := PerceptualHashDistance(phash, e.Hash)
+
+		if err != nil {
+			return 0, 0, false, fmt.Errorf("Failed to compare perceptual hash for %d, %w", e.WOFId, err)
+		}
+
+		if !comparable || d > threshold {
+			continue
+		}
+
+		if match_distance == -1 || d < match_distance {
+			match_id = e.WOFId
+			match_distance = d
+		}
+	}
+
+	if match_distance == -1 {
+		return 0, 0, false, nil
+	}
+
+	return match_id, match_distance, true, nil
+}
+
+// perceptualHashIndexKey returns the key, for a post taken at 'taken_at', used to group hashes in a `PerceptualHashIndex`.
+// Datetime strings are normalized using `media.TIME_FORMAT`, which has minute-level precision, for the same reasons
+// described in `DeriveMediaId`.
+func perceptualHashIndexKey(taken_at string) (string, error) {
+
+	t, err := media.ParseTime(taken_at)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse %s, %w", taken_at, err)
+	}
+
+	return t.Format(media.TIME_FORMAT), nil
+}
@@ -0,0 +1,49 @@
+package publish
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPerceptualHashIndex(t *testing.T) {
+
+	ctx := context.Background()
+
+	idx := NewPerceptualHashIndex()
+
+	err := idx.Add(ctx, "Apr 18, 2022 10:15 AM", "p:b867679231ccc633", 1729355025)
+
+	if err != nil {
+		t.Fatalf("Failed to add hash, %v", err)
+	}
+
+	err = idx.Add(ctx, "Apr 18, 2022 10:16 AM", "p:b867679231ccc632", 1729355023)
+
+	if err != nil {
+		t.Fatalf("Failed to add hash, %v", err)
+	}
+
+	id, distance, ok, err := idx.Match(ctx, "Apr 18, 2022, 10:15 am", "p:b867679231ccc632", 4)
+
+	if err != nil {
+		t.Fatalf("Failed to match hash, %v", err)
+	}
+
+	if !ok {
+		t.Fatalf("Expected hash to match")
+	}
+
+	if id != 1729355025 || distance != 1 {
+		t.Fatalf("Unexpected match %d (%d)", id, distance)
+	}
+
+	_, _, ok, err = idx.Match(ctx, "Apr 18, 2022 10:15 AM", "p:4798986dce3339cc", 4)
+
+	if err != nil {
+		t.Fatalf("Failed to match hash, %v", err)
+	}
+
+	if ok {
+		t.Fatalf("Expected distant hash not to match")
+	}
+}
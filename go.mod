@@ -4,6 +4,10 @@ go 1.23.2
 
 require (
 	github.com/aaronland/gocloud-blob v0.4.0
+	github.com/corona10/goimagehash v1.1.0
+	github.com/go-git/go-git/v5 v5.12.0
+	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
+	github.com/sfomuseum/go-sfomuseum-export/v2 v2.3.11
 	github.com/sfomuseum/go-sfomuseum-instagram v0.3.0
 	github.com/sfomuseum/go-sfomuseum-reader v0.0.2
 	github.com/sfomuseum/go-sfomuseum-writer/v3 v3.0.3
@@ -13,6 +17,8 @@ require (
 	github.com/whosonfirst/go-whosonfirst-export/v2 v2.8.3
 	github.com/whosonfirst/go-whosonfirst-iterate-git/v2 v2.1.7
 	github.com/whosonfirst/go-whosonfirst-iterate/v2 v2.5.0
+	github.com/whosonfirst/go-whosonfirst-uri v1.3.0
+	github.com/whosonfirst/go-whosonfirst-writer/v3 v3.1.3
 	github.com/whosonfirst/go-writer/v3 v3.1.1
 	gocloud.dev v0.40.0
 )
@@ -58,14 +64,12 @@ require (
 	github.com/benbjohnson/clock v1.3.0 // indirect
 	github.com/cenkalti/backoff/v4 v4.2.1 // indirect
 	github.com/cloudflare/circl v1.3.7 // indirect
-	github.com/corona10/goimagehash v1.1.0 // indirect
 	github.com/cyphar/filepath-securejoin v0.2.4 // indirect
 	github.com/dominikbraun/graph v0.16.1 // indirect
 	github.com/emirpasic/gods v1.18.1 // indirect
 	github.com/g8rswimmer/error-chain v1.0.0 // indirect
 	github.com/go-git/gcfg v1.5.1-0.20230307220236-3a3c6141e376 // indirect
 	github.com/go-git/go-billy/v5 v5.5.0 // indirect
-	github.com/go-git/go-git/v5 v5.12.0 // indirect
 	github.com/go-ini/ini v1.67.0 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/google/wire v0.6.0 // indirect
@@ -78,13 +82,11 @@ require (
 	github.com/mmcloughlin/geohash v0.10.0 // indirect
 	github.com/natefinch/atomic v1.0.1 // indirect
 	github.com/neurosnap/sentences v1.1.2 // indirect
-	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646 // indirect
 	github.com/paulmach/go.geojson v1.4.0 // indirect
 	github.com/paulmach/orb v0.11.1 // indirect
 	github.com/pjbgf/sha1cd v0.3.0 // indirect
 	github.com/sergi/go-diff v1.3.2-0.20230802210424-5b0b94c5c0d3 // indirect
 	github.com/sfomuseum/go-edtf v1.2.1 // indirect
-	github.com/sfomuseum/go-sfomuseum-export/v2 v2.3.11 // indirect
 	github.com/sfomuseum/go-sfomuseum-geojson v0.1.3 // indirect
 	github.com/skeema/knownhosts v1.2.2 // indirect
 	github.com/skelterjohn/geom v0.0.0-20180103142417-96f3e8a219c5 // indirect
@@ -101,8 +103,6 @@ require (
 	github.com/whosonfirst/go-whosonfirst-placetypes v0.7.0 // indirect
 	github.com/whosonfirst/go-whosonfirst-sources v0.1.0 // indirect
 	github.com/whosonfirst/go-whosonfirst-spr/v2 v2.0.0 // indirect
-	github.com/whosonfirst/go-whosonfirst-uri v1.3.0 // indirect
-	github.com/whosonfirst/go-whosonfirst-writer/v3 v3.1.3 // indirect
 	github.com/whosonfirst/walk v0.0.2 // indirect
 	github.com/whosonfirst/warning v0.1.1 // indirect
 	github.com/xanzy/ssh-agent v0.3.3 // indirect
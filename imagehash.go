@@ -0,0 +1,146 @@
+package publish
+
+import (
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/corona10/goimagehash"
+)
+
+// HASH_AVERAGE is the name of the (64-bit) average hash algorithm.
+const HASH_AVERAGE string = "average"
+
+// HASH_DIFFERENCE is the name of the (64-bit) difference hash algorithm.
+const HASH_DIFFERENCE string = "difference"
+
+// HASH_PERCEPTION is the name of the (64-bit) perception hash algorithm. This is the algorithm used to derive the
+// `instagram:post.perceptual_hash` property of records.
+const HASH_PERCEPTION string = "perception"
+
+// HASH_EXTENDED is the name of the extended (256-bit) perception hash algorithm.
+const HASH_EXTENDED string = "extended"
+
+// EXTENDED_HASH_SIZE is the width and height of the grid used to derive extended perception hashes.
+const EXTENDED_HASH_SIZE int = 16
+
+// ImageHashAlgorithm is a struct defining an algorithm for deriving (and comparing) image hashes.
+type ImageHashAlgorithm struct {
+	// Name is the name of the algorithm.
+	Name string
+	// Bits is the size of the hashes produced by the algorithm.
+	Bits int
+	// Threshold is the default maximum Hamming distance between two hashes for their images to be considered the same.
+	Threshold int
+	// extended is a boolean flag indicating that the algorithm produces `goimagehash.ExtImageHash` hashes.
+	extended bool
+}
+
+// IMAGE_HASH_ALGORITHMS is the list of supported image hash algorithms.
+var IMAGE_HASH_ALGORITHMS = []*ImageHashAlgorithm{
+	{Name: HASH_AVERAGE, Bits: 64, Threshold: 5},
+	{Name: HASH_DIFFERENCE, Bits: 64, Threshold: 8},
+	{Name: HASH_PERCEPTION, Bits: 64, Threshold: 8},
+	{Name: HASH_EXTENDED, Bits: EXTENDED_HASH_SIZE * EXTENDED_HASH_SIZE, Threshold: 32, extended: true},
+}
+
+// GetImageHashAlgorithm returns the `ImageHashAlgorithm` named 'name'.
+func GetImageHashAlgorithm(name string) (*ImageHashAlgorithm, error) {
+
+	for _, a := range IMAGE_HASH_ALGORITHMS {
+
+		if a.Name == name {
+			return a, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unsupported image hash algorithm '%s'", name)
+}
+
+// Hash derives the hash (as produced by `goimagehash.ImageHash.ToString` or `goimagehash.ExtImageHash.ToString`)
+// of 'im'.
+func (a *ImageHashAlgorithm) Hash(im image.Image) (string, error) {
+
+	var h interface{ ToString() string }
+	var err error
+
+	switch a.Name {
+	case HASH_AVERAGE:
+		h, err = goimagehash.AverageHash(im)
+	case HASH_DIFFERENCE:
+		h, err = goimagehash.DifferenceHash(im)
+	case HASH_PERCEPTION:
+		h, err = goimagehash.PerceptionHash(im)
+	case HASH_EXTENDED:
+		h, err = goimagehash.ExtPerceptionHash(im, EXTENDED_HASH_SIZE, EXTENDED_HASH_SIZE)
+	default:
+		return "", fmt.Errorf("Unsupported image hash algorithm '%s'", a.Name)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to derive %s hash, %w", a.Name, err)
+	}
+
+	return h.ToString(), nil
+}
+
+// Distance returns the Hamming distance between 'hash_a' and 'hash_b', two hashes produced by 'a'.
+func (a *ImageHashAlgorithm) Distance(hash_a string, hash_b string) (int, error) {
+
+	if a.extended {
+
+		h_a, err := goimagehash.ExtImageHashFromString(hash_a)
+
+		if err != nil {
+			return 0, fmt.Errorf("Failed to parse hash '%s', %w", hash_a, err)
+		}
+
+		h_b, err := goimagehash.ExtImageHashFromString(hash_b)
+
+		if err != nil {
+			return 0, fmt.Errorf("Failed to parse hash '%s', %w", hash_b, err)
+		}
+
+		return h_a.Distance(h_b)
+	}
+
+	h_a, err := goimagehash.ImageHashFromString(hash_a)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to parse hash '%s', %w", hash_a, err)
+	}
+
+	h_b, err := goimagehash.ImageHashFromString(hash_b)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to parse hash '%s', %w", hash_b, err)
+	}
+
+	return h_a.Distance(h_b)
+}
+
+// ImageHashes decodes the image contained in 'r' and returns its hashes, derived using each of 'algorithms', keyed
+// by algorithm name.
+func ImageHashes(r io.Reader, algorithms []*ImageHashAlgorithm) (map[string]string, error) {
+
+	im, _, err := image.Decode(r)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode image, %w", err)
+	}
+
+	hashes := make(map[string]string)
+
+	for _, a := range algorithms {
+
+		h, err := a.Hash(im)
+
+		if err != nil {
+			return nil, err
+		}
+
+		hashes[a.Name] = h
+	}
+
+	return hashes, nil
+}
@@ -0,0 +1,76 @@
+package publish
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+func TestImageHashes(t *testing.T) {
+
+	noise := func(seed int64) []byte {
+
+		r := rand.New(rand.NewSource(seed))
+		im := image.NewGray(image.Rect(0, 0, 64, 64))
+
+		for x := 0; x < 64; x++ {
+			for y := 0; y < 64; y++ {
+				im.SetGray(x, y, color.Gray{Y: uint8(r.Intn(256))})
+			}
+		}
+
+		var buf bytes.Buffer
+
+		err := png.Encode(&buf, im)
+
+		if err != nil {
+			t.Fatalf("Failed to encode image, %v", err)
+		}
+
+		return buf.Bytes()
+	}
+
+	hashes_a, err := ImageHashes(bytes.NewReader(noise(1)), IMAGE_HASH_ALGORITHMS)
+
+	if err != nil {
+		t.Fatalf("Failed to derive hashes, %v", err)
+	}
+
+	hashes_b, err := ImageHashes(bytes.NewReader(noise(2)), IMAGE_HASH_ALGORITHMS)
+
+	if err != nil {
+		t.Fatalf("Failed to derive hashes, %v", err)
+	}
+
+	for _, a := range IMAGE_HASH_ALGORITHMS {
+
+		d, err := a.Distance(hashes_a[a.Name], hashes_a[a.Name])
+
+		if err != nil {
+			t.Fatalf("Failed to derive %s distance, %v", a.Name, err)
+		}
+
+		if d != 0 {
+			t.Fatalf("Expected %s distance of 0 for the same image, got %d", a.Name, d)
+		}
+
+		d, err = a.Distance(hashes_a[a.Name], hashes_b[a.Name])
+
+		if err != nil {
+			t.Fatalf("Failed to derive %s distance, %v", a.Name, err)
+		}
+
+		if d <= a.Threshold {
+			t.Fatalf("Expected %s distance greater than %d for different images, got %d", a.Name, a.Threshold, d)
+		}
+	}
+
+	_, err = GetImageHashAlgorithm("bogus")
+
+	if err == nil {
+		t.Fatalf("Expected error for unsupported algorithm")
+	}
+}
@@ -0,0 +1,206 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JournalStatus is a string label describing how far an Instagram post has progressed through a publish run.
+type JournalStatus string
+
+// JOURNAL_STATUS_HASHED indicates that the media file(s) for an Instagram post have been hashed and its media ID derived.
+const JOURNAL_STATUS_HASHED JournalStatus = "hashed"
+
+// JOURNAL_STATUS_MATCHED indicates that an Instagram post has been matched to an existing WOF record (or not, in which
+// case a new record will be created).
+const JOURNAL_STATUS_MATCHED JournalStatus = "matched"
+
+// JOURNAL_STATUS_WRITTEN indicates that the WOF record for an Instagram post has been written.
+const JOURNAL_STATUS_WRITTEN JournalStatus = "written"
+
+// JOURNAL_STATUS_UNCHANGED indicates that the WOF record for an Instagram post is already up to date.
+const JOURNAL_STATUS_UNCHANGED JournalStatus = "unchanged"
+
+// JOURNAL_STATUS_FAILED indicates that publishing an Instagram post failed.
+const JOURNAL_STATUS_FAILED JournalStatus = "failed"
+
+// JournalEntry is a struct recording the (most recent) status of an Instagram post in a publish run.
+type JournalEntry struct {
+	// Path is the (relative) media path of the Instagram post.
+	Path string `json:"path"`
+	// Status is the status of the post.
+	Status JournalStatus `json:"status"`
+	// MediaId is the SFO Museum media ID derived for the post, if known.
+	MediaId string `json:"media_id,omitempty"`
+	// WOFId is the WOF ID of the record the post was matched to or written as, if known.
+	WOFId int64 `json:"wof_id,omitempty"`
+	// Error is the error message for failed posts.
+	Error string `json:"error,omitempty"`
+	// LastModified is the Unix timestamp when the entry was recorded.
+	LastModified int64 `json:"lastmodified"`
+}
+
+// IsComplete returns a boolean value indicating whether the post has been published (its record was written or
+// was already up to date).
+func (e *JournalEntry) IsComplete() bool {
+	return e.Status == JOURNAL_STATUS_WRITTEN || e.Status == JOURNAL_STATUS_UNCHANGED
+}
+
+// Journal is a checkpoint journal, keyed by media path, recording the status of each Instagram post in a publish run
+// so that an interrupted run can be resumed without reprocessing completed posts. Entries are appended, as
+// line-separated JSON, to a file on disk as they are recorded; when a journal is opened the last entry for each path wins.
+type Journal struct {
+	mu      *sync.RWMutex
+	path    string
+	fh      *os.File
+	entries map[string]*JournalEntry
+}
+
+// OpenJournal returns a new `Journal` instance for the file at 'path', which will be created if it does not exist.
+// Any existing entries are loaded. A truncated final line (from a run that was killed mid-write) is ignored.
+func OpenJournal(ctx context.Context, path string) (*Journal, error) {
+
+	j := &Journal{
+		mu:      new(sync.RWMutex),
+		path:    path,
+		entries: make(map[string]*JournalEntry),
+	}
+
+	body, err := os.ReadFile(path)
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("Failed to read %s, %w", path, err)
+	}
+
+	for _, ln := range bytes.Split(body, []byte("\n")) {
+
+		if len(bytes.TrimSpace(ln)) == 0 {
+			continue
+		}
+
+		var e JournalEntry
+
+		err := json.Unmarshal(ln, &e)
+
+		if err != nil {
+			slog.Warn("Failed to decode journal entry, skipping", "path", path, "error", err)
+			continue
+		}
+
+		j.entries[e.Path] = &e
+	}
+
+	fh, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s for writing, %w", path, err)
+	}
+
+	// Make sure new entries aren't appended to a truncated final line
+
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+
+		_, err := fh.Write([]byte("\n"))
+
+		if err != nil {
+			fh.Close()
+			return nil, fmt.Errorf("Failed to write to %s, %w", path, err)
+		}
+	}
+
+	j.fh = fh
+	return j, nil
+}
+
+// Record appends 'e' to the journal, replacing any previous entry for the same path.
+func (j *Journal) Record(ctx context.Context, e *JournalEntry) error {
+
+	e.LastModified = time.Now().Unix()
+
+	enc, err := json.Marshal(e)
+
+	if err != nil {
+		return fmt.Errorf("Failed to marshal journal entry, %w", err)
+	}
+
+	enc = append(enc, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_, err = j.fh.Write(enc)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write journal entry, %w", err)
+	}
+
+	j.entries[e.Path] = e
+	return nil
+}
+
+// Get returns the most recent entry for 'path'.
+func (j *Journal) Get(ctx context.Context, path string) (*JournalEntry, bool) {
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	e, ok := j.entries[path]
+	return e, ok
+}
+
+// Entries returns the most recent entry for each path in the journal, sorted by path.
+func (j *Journal) Entries() []*JournalEntry {
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	entries := make([]*JournalEntry, 0, len(j.entries))
+
+	for _, e := range j.entries {
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].Path < entries[b].Path
+	})
+
+	return entries
+}
+
+// Failures returns the entries for paths whose most recent status is `JOURNAL_STATUS_FAILED`, sorted by path.
+func (j *Journal) Failures() []*JournalEntry {
+
+	failures := make([]*JournalEntry, 0)
+
+	for _, e := range j.Entries() {
+
+		if e.Status == JOURNAL_STATUS_FAILED {
+			failures = append(failures, e)
+		}
+	}
+
+	return failures
+}
+
+// Close flushes and closes the journal file.
+func (j *Journal) Close() error {
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	err := j.fh.Sync()
+
+	if err != nil {
+		return fmt.Errorf("Failed to sync %s, %w", j.path, err)
+	}
+
+	return j.fh.Close()
+}
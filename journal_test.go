@@ -0,0 +1,97 @@
+package publish
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+
+	j, err := OpenJournal(ctx, path)
+
+	if err != nil {
+		t.Fatalf("Failed to open journal, %v", err)
+	}
+
+	entries := []*JournalEntry{
+		{Path: "media/a.jpg", Status: JOURNAL_STATUS_HASHED, MediaId: "a"},
+		{Path: "media/a.jpg", Status: JOURNAL_STATUS_WRITTEN, MediaId: "a", WOFId: 1},
+		{Path: "media/b.jpg", Status: JOURNAL_STATUS_FAILED, Error: "Failed to decode image"},
+	}
+
+	for _, e := range entries {
+
+		err := j.Record(ctx, e)
+
+		if err != nil {
+			t.Fatalf("Failed to record entry, %v", err)
+		}
+	}
+
+	err = j.Close()
+
+	if err != nil {
+		t.Fatalf("Failed to close journal, %v", err)
+	}
+
+	// Simulate a run that was killed mid-write
+
+	fh, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to open %s, %v", path, err)
+	}
+
+	fh.Write([]byte(`{"path":"media/c.jpg","sta`))
+	fh.Close()
+
+	j, err = OpenJournal(ctx, path)
+
+	if err != nil {
+		t.Fatalf("Failed to reopen journal, %v", err)
+	}
+
+	defer j.Close()
+
+	e, ok := j.Get(ctx, "media/a.jpg")
+
+	if !ok || !e.IsComplete() || e.WOFId != 1 {
+		t.Fatalf("Unexpected entry for media/a.jpg")
+	}
+
+	failures := j.Failures()
+
+	if len(failures) != 1 || failures[0].Path != "media/b.jpg" {
+		t.Fatalf("Unexpected failures")
+	}
+
+	if len(j.Entries()) != 2 {
+		t.Fatalf("Unexpected number of entries: %d", len(j.Entries()))
+	}
+
+	err = j.Record(ctx, &JournalEntry{Path: "media/c.jpg", Status: JOURNAL_STATUS_UNCHANGED, WOFId: 3})
+
+	if err != nil {
+		t.Fatalf("Failed to record entry, %v", err)
+	}
+
+	j2, err := OpenJournal(ctx, path)
+
+	if err != nil {
+		t.Fatalf("Failed to reopen journal, %v", err)
+	}
+
+	defer j2.Close()
+
+	e, ok = j2.Get(ctx, "media/c.jpg")
+
+	if !ok || !e.IsComplete() {
+		t.Fatalf("Entry appended after truncated line was not recovered")
+	}
+}
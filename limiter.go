@@ -0,0 +1,91 @@
+package publish
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter limits the number of concurrent operations, for example reads from a media bucket or writes to a data
+// repository, and optionally the rate at which they are started. A nil `*Limiter` imposes no limits.
+type Limiter struct {
+	slots    chan bool
+	mu       *sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewLimiter returns a new `Limiter` instance allowing at most 'max_concurrent' operations at a time and starting at
+// most 'per_second' operations per second. If 'max_concurrent' is zero (or less) concurrency is not limited and if
+// 'per_second' is zero (or less) the rate is not limited.
+func NewLimiter(max_concurrent int, per_second float64) *Limiter {
+
+	l := &Limiter{
+		mu: new(sync.Mutex),
+	}
+
+	if max_concurrent > 0 {
+		l.slots = make(chan bool, max_concurrent)
+	}
+
+	if per_second > 0 {
+		l.interval = time.Duration(float64(time.Second) / per_second)
+	}
+
+	return l
+}
+
+// Acquire blocks until an operation is allowed to start, or 'ctx' is cancelled. It returns a function that must be
+// called when the operation has completed.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+
+	release := func() {}
+
+	if l == nil {
+		return release, nil
+	}
+
+	if l.slots != nil {
+
+		select {
+		case l.slots <- true:
+			release = func() {
+				<-l.slots
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if l.interval > 0 {
+
+		l.mu.Lock()
+
+		now := time.Now()
+
+		if l.next.Before(now) {
+			l.next = now
+		}
+
+		wait := l.next.Sub(now)
+		l.next = l.next.Add(l.interval)
+
+		l.mu.Unlock()
+
+		if wait > 0 {
+
+			timer := time.NewTimer(wait)
+
+			select {
+			case <-timer.C:
+				// pass
+			case <-ctx.Done():
+				timer.Stop()
+				release()
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	return release, nil
+}
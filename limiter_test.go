@@ -0,0 +1,95 @@
+package publish
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+
+	ctx := context.Background()
+
+	l := NewLimiter(2, 0)
+
+	var active int32
+	var max_active int32
+
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < 10; i++ {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			release, err := l.Acquire(ctx)
+
+			if err != nil {
+				t.Errorf("Failed to acquire limiter, %v", err)
+				return
+			}
+
+			defer release()
+
+			n := atomic.AddInt32(&active, 1)
+
+			for {
+				m := atomic.LoadInt32(&max_active)
+
+				if n <= m || atomic.CompareAndSwapInt32(&max_active, m, n) {
+					break
+				}
+			}
+
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&active, -1)
+		}()
+	}
+
+	wg.Wait()
+
+	if max_active > 2 {
+		t.Fatalf("Expected at most 2 concurrent operations, got %d", max_active)
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+
+	ctx := context.Background()
+
+	l := NewLimiter(0, 100)
+
+	t1 := time.Now()
+
+	for i := 0; i < 5; i++ {
+
+		release, err := l.Acquire(ctx)
+
+		if err != nil {
+			t.Fatalf("Failed to acquire limiter, %v", err)
+		}
+
+		release()
+	}
+
+	// The first operation starts immediately, the remaining four are spaced 10ms apart
+
+	if time.Since(t1) < 40*time.Millisecond {
+		t.Fatalf("Operations were not rate limited")
+	}
+
+	var nil_limiter *Limiter
+
+	release, err := nil_limiter.Acquire(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to acquire nil limiter, %v", err)
+	}
+
+	release()
+}
@@ -13,9 +13,125 @@ import (
 	"github.com/whosonfirst/go-whosonfirst-iterate/v2/iterator"
 )
 
-func BuildLookup(ctx context.Context, indexer_uri string, indexer_path string) (*sync.Map, error) {
+// Lookup is an interface for mapping (SFO Museum) media IDs and Instagram media paths to WOF IDs.
+type Lookup interface {
+	// Load returns the WOF ID associated with a media ID or media path and a boolean value indicating whether it exists.
+	Load(context.Context, string) (int64, bool)
+	// Store associates a media ID or media path with a WOF ID.
+	Store(context.Context, string, int64) error
+	// Range invokes a callback function for each key and WOF ID in the lookup until that function returns false.
+	Range(context.Context, func(string, int64) bool)
+}
+
+// MemoryLookup implements the `Lookup` interface using an in-memory map.
+type MemoryLookup struct {
+	mu      *sync.RWMutex
+	entries map[string]int64
+}
+
+// NewMemoryLookup returns a new (empty) `MemoryLookup` instance.
+func NewMemoryLookup() *MemoryLookup {
+
+	l := &MemoryLookup{
+		mu:      new(sync.RWMutex),
+		entries: make(map[string]int64),
+	}
+
+	return l
+}
+
+// Load returns the WOF ID associated with 'key' and a boolean value indicating whether it exists.
+func (l *MemoryLookup) Load(ctx context.Context, key string) (int64, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	id, ok := l.entries[key]
+	return id, ok
+}
+
+// Store associates 'key' with 'id'.
+func (l *MemoryLookup) Store(ctx context.Context, key string, id int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[key] = id
+	return nil
+}
+
+// Range invokes 'cb' for each key and WOF ID in 'l' until 'cb' returns false.
+func (l *MemoryLookup) Range(ctx context.Context, cb func(string, int64) bool) {
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for k, id := range l.entries {
+
+		if !cb(k, id) {
+			break
+		}
+	}
+}
+
+// removeIds removes every key in 'l' associated with one of the WOF IDs in 'ids'.
+func (l *MemoryLookup) removeIds(ids map[int64]bool) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for k, id := range l.entries {
+
+		if ids[id] {
+			delete(l.entries, k)
+		}
+	}
+}
+
+// BuildLookup returns a new `MemoryLookup` instance populated with records from 'indexer_path' crawled using
+// a whosonfirst/go-whosonfirst-iterate/v2 iterator defined by 'indexer_uri'.
+func BuildLookup(ctx context.Context, indexer_uri string, indexer_path string) (Lookup, error) {
+
+	lookup := NewMemoryLookup()
+
+	err := PopulateLookup(ctx, lookup, indexer_uri, indexer_path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return lookup, nil
+}
+
+// PopulateLookupOptions defines configuration options for the `PopulateLookupWithOptions` method.
+type PopulateLookupOptions struct {
+	// Lookup is the `Lookup` instance to add media ID and media path pointers to.
+	Lookup Lookup
+	// HashIndex is an optional `PerceptualHashIndex` instance to add perceptual hashes to.
+	HashIndex *PerceptualHashIndex
+	// Audit is an optional `LookupAudit` instance used to record conflicting media IDs (including slide media IDs) and
+	// paths, and records missing perceptual hashes. If present conflicting slide media IDs and paths will be recorded
+	// rather than triggering an error.
+	Audit *LookupAudit
+	// IteratorURI is a valid whosonfirst/go-whosonfirst-iterate/v2 URI used to crawl IteratorSource.
+	IteratorURI string
+	// IteratorSource is the URI of the data repository to crawl.
+	IteratorSource string
+}
+
+// PopulateLookup adds media ID and media path pointers for records in 'indexer_path' crawled using a
+// whosonfirst/go-whosonfirst-iterate/v2 iterator defined by 'indexer_uri' to 'lookup'.
+func PopulateLookup(ctx context.Context, lookup Lookup, indexer_uri string, indexer_path string) error {
+
+	opts := &PopulateLookupOptions{
+		Lookup:         lookup,
+		IteratorURI:    indexer_uri,
+		IteratorSource: indexer_path,
+	}
+
+	return PopulateLookupWithOptions(ctx, opts)
+}
+
+// PopulateLookupWithOptions adds media ID and media path pointers (and optionally perceptual hashes) for records
+// crawled using the iterator defined in 'opts'.
+func PopulateLookupWithOptions(ctx context.Context, opts *PopulateLookupOptions) error {
 
-	lookup := new(sync.Map)
 	count := int32(0)
 
 	indexer_cb := func(ctx context.Context, path string, fh io.ReadSeeker, args ...interface{}) error {
@@ -26,72 +142,201 @@ func BuildLookup(ctx context.Context, indexer_uri string, indexer_path string) (
 			return err
 		}
 
-		wof_rsp := gjson.GetBytes(body, "properties.wof:id")
+		err = indexRecord(ctx, opts, path, body)
+
+		if err != nil {
+			return err
+		}
+
+		atomic.AddInt32(&count, 1)
+		return nil
+	}
+
+	iter, err := iterator.NewIterator(ctx, opts.IteratorURI, indexer_cb)
+
+	if err != nil {
+		return err
+	}
+
+	err = iter.IterateURIs(ctx, opts.IteratorSource)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// indexRecord adds the media ID and media path pointers (and optionally perceptual hashes) for the WOF record 'body',
+// read from 'path', to the lookup (and hash index) defined in 'opts'. These are the same keys whether a record is
+// indexed while building the lookup or after it has been written by `PublishMedia`.
+func indexRecord(ctx context.Context, opts *PopulateLookupOptions, path string, body []byte) error {
+
+	lookup := opts.Lookup
+
+	wof_rsp := gjson.GetBytes(body, "properties.wof:id")
+
+	if !wof_rsp.Exists() {
+		return fmt.Errorf("Missing WOF ID")
+	}
+
+	wof_id := wof_rsp.Int()
+
+	// Records that have been merged in to another record (see merge.go) are skipped so that
+	// their media IDs and paths, which are stored as historical properties of the superseding
+	// record, point to that record instead.
+
+	if len(gjson.GetBytes(body, "properties.wof:superseded_by").Array()) > 0 {
+		return nil
+	}
+
+	// See notes about lookup_keys (and media_id) in publish.go. Media IDs are derived from perceptual
+	// hashes or, for videos and media that can't be decoded as images, file hashes (see notes about
+	// video hashes in DeriveMediaId). Records without either are still added to the lookup using
+	// their media path, below.
+
+	phash_rsp := gjson.GetBytes(body, "properties.instagram:post.perceptual_hash")
+	fhash_rsp := gjson.GetBytes(body, "properties.instagram:post.file_hash")
+
+	if phash_rsp.Exists() || fhash_rsp.Exists() {
 
-		if !wof_rsp.Exists() {
-			return fmt.Errorf("Missing WOF ID")
+		media_id, err := DeriveMediaId(body, "properties.instagram:post")
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive media ID for %s, %w", path, err)
+		}
+
+		if opts.Audit != nil {
+			opts.Audit.AddKey(CONFLICT_MEDIA_ID, media_id, wof_id, path)
 		}
 
-		wof_id := wof_rsp.Int()
+		// See notes about carousels in publish.go
 
-		// See notes about lookup_keys (and media_id) in publish.go
+		slide_ids, err := DeriveSlideMediaIds(body, "properties.instagram:post")
 
-		var media_id string
+		if err != nil {
+			return fmt.Errorf("Failed to derive slide media IDs for %s, %w", path, err)
+		}
 
-		phash_rsp := gjson.GetBytes(body, "properties.instagram:post.perceptual_hash")
+		for _, slide_id := range slide_ids {
 
-		if phash_rsp.Exists() {
+			if opts.Audit != nil {
+				opts.Audit.AddKey(CONFLICT_MEDIA_ID, slide_id, wof_id, path)
+			}
 
-			m, err := DeriveMediaId(body, "properties.instagram:post")
+			v, exists := lookup.Load(ctx, slide_id)
+
+			if exists && v != wof_id && opts.Audit == nil {
+				return fmt.Errorf("Failed to store slide media ID (%s) for %d because there is already an entry for %d", slide_id, wof_id, v)
+			}
+
+			err = lookup.Store(ctx, slide_id, wof_id)
 
 			if err != nil {
-				return fmt.Errorf("Failed to derive media ID for %s, %w", path, err)
+				return fmt.Errorf("Failed to store slide media ID (%s) for %d, %w", slide_id, wof_id, err)
 			}
+		}
+
+		err = lookup.Store(ctx, media_id, wof_id)
 
-			media_id = m
+		if err != nil {
+			return fmt.Errorf("Failed to store media ID (%s) for %d, %w", media_id, wof_id, err)
+		}
 
-		} else {
+	} else if !gjson.GetBytes(body, "properties.instagram:post.video_hash").Exists() {
 
-			log.Printf("%s is missing hash\n", path)
-			return nil
+		log.Printf("%s is missing hash\n", path)
+
+		if opts.Audit != nil {
+			opts.Audit.AddMissingHash(wof_id, path)
 		}
+	}
 
-		lookup.Store(media_id, wof_id)
+	// Videos are fuzzy-matched using their video hash
 
-		// Add path to the file as a fallback because apparently IG does stuff to the
-		// photos between archive runs that causes the percaptual hash to change. Good
-		// times...
+	if !phash_rsp.Exists() {
+		phash_rsp = gjson.GetBytes(body, "properties.instagram:post.video_hash")
+	}
+
+	if opts.HashIndex != nil && phash_rsp.Exists() {
+
+		taken_rsp := gjson.GetBytes(body, "properties.instagram:post.taken_at")
+
+		err := opts.HashIndex.Add(ctx, taken_rsp.String(), phash_rsp.String(), wof_id)
 
-		path_rsp := gjson.GetBytes(body, "properties.instagram:post.media_id")
+		if err != nil {
+			return fmt.Errorf("Failed to index perceptual hash for %s, %w", path, err)
+		}
+	}
 
-		if path_rsp.Exists() {
+	// Add path to the file as a fallback because apparently IG does stuff to the
+	// photos between archive runs that causes the percaptual hash to change. Good
+	// times...
 
-			path := path_rsp.String()
+	path_rsp := gjson.GetBytes(body, "properties.instagram:post.media_id")
 
-			v, exists := lookup.Load(path)
+	if path_rsp.Exists() {
 
-			if exists && v.(int64) != wof_id {
-				return fmt.Errorf("Failed to store path (%s) for %d because there is already an entry for %d", path, wof_id, v.(int64))
-			}
+		media_path := path_rsp.String()
 
-			lookup.Store(path, wof_id)
+		if opts.Audit != nil {
+			opts.Audit.AddKey(CONFLICT_PATH, media_path, wof_id, path)
 		}
 
-		atomic.AddInt32(&count, 1)
-		return nil
+		v, exists := lookup.Load(ctx, media_path)
+
+		if exists && v != wof_id && opts.Audit == nil {
+			return fmt.Errorf("Failed to store path (%s) for %d because there is already an entry for %d", media_path, wof_id, v)
+		}
+
+		err := lookup.Store(ctx, media_path, wof_id)
+
+		if err != nil {
+			return fmt.Errorf("Failed to store path (%s) for %d, %w", media_path, wof_id, err)
+		}
 	}
 
-	iter, err := iterator.NewIterator(ctx, indexer_uri, indexer_cb)
+	// Media IDs, paths and hashes of records that have been merged in to this one
 
-	if err != nil {
-		return nil, err
+	for _, rsp := range gjson.GetBytes(body, "properties."+HISTORICAL_MEDIA_IDS_PROPERTY).Array() {
+
+		if opts.Audit != nil {
+			opts.Audit.AddKey(CONFLICT_MEDIA_ID, rsp.String(), wof_id, path)
+		}
+
+		err := lookup.Store(ctx, rsp.String(), wof_id)
+
+		if err != nil {
+			return fmt.Errorf("Failed to store historical media ID (%s) for %d, %w", rsp.String(), wof_id, err)
+		}
 	}
 
-	err = iter.IterateURIs(ctx, indexer_path)
+	for _, rsp := range gjson.GetBytes(body, "properties."+HISTORICAL_PATHS_PROPERTY).Array() {
 
-	if err != nil {
-		return nil, err
+		if opts.Audit != nil {
+			opts.Audit.AddKey(CONFLICT_PATH, rsp.String(), wof_id, path)
+		}
+
+		err := lookup.Store(ctx, rsp.String(), wof_id)
+
+		if err != nil {
+			return fmt.Errorf("Failed to store historical path (%s) for %d, %w", rsp.String(), wof_id, err)
+		}
 	}
 
-	return lookup, nil
+	if opts.HashIndex != nil {
+
+		taken_rsp := gjson.GetBytes(body, "properties.instagram:post.taken_at")
+
+		for _, rsp := range gjson.GetBytes(body, "properties."+HISTORICAL_HASHES_PROPERTY).Array() {
+
+			err := opts.HashIndex.Add(ctx, taken_rsp.String(), rsp.String(), wof_id)
+
+			if err != nil {
+				return fmt.Errorf("Failed to index historical perceptual hash for %s, %w", path, err)
+			}
+		}
+	}
+
+	return nil
 }
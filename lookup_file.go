@@ -0,0 +1,405 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+	"github.com/tidwall/gjson"
+)
+
+// LOOKUP_SNAPSHOT_VERSION is the version of the on-disk format used by `FileLookup` snapshots. Snapshots
+// with a different version are considered stale.
+const LOOKUP_SNAPSHOT_VERSION int = 3
+
+// LookupSnapshot is a struct representing the on-disk (JSON) encoding of a `FileLookup` instance.
+type LookupSnapshot struct {
+	// Version is the version of the snapshot format.
+	Version int `json:"version"`
+	// Head is the git commit hash of the data repository at the time the snapshot was built.
+	Head string `json:"head"`
+	// LastModified is the Unix timestamp when the snapshot was last saved.
+	LastModified int64 `json:"lastmodified"`
+	// Entries is the map of media IDs and media paths to WOF IDs.
+	Entries map[string]int64 `json:"entries"`
+	// Hashes is the map of (normalized) taken_at datetime strings to perceptual hash entries.
+	Hashes map[string][]*PerceptualHashEntry `json:"hashes,omitempty"`
+}
+
+// FileLookup implements the `Lookup` interface using an in-memory map that can be saved to, and loaded from,
+// a JSON snapshot on disk. Entries added with `Store` (for example after a record is written) are included
+// the next time the snapshot is saved. Each snapshot also contains a `PerceptualHashIndex` for fuzzy matching.
+type FileLookup struct {
+	*MemoryLookup
+	path   string
+	head   string
+	hashes *PerceptualHashIndex
+}
+
+// OpenFileLookup returns a new `FileLookup` instance for the snapshot at 'path'. If 'path' does not exist an empty
+// lookup will be returned.
+func OpenFileLookup(ctx context.Context, path string) (*FileLookup, error) {
+
+	l := &FileLookup{
+		MemoryLookup: NewMemoryLookup(),
+		path:         path,
+		hashes:       NewPerceptualHashIndex(),
+	}
+
+	r, err := os.Open(path)
+
+	if err != nil {
+
+		if errors.Is(err, os.ErrNotExist) {
+			return l, nil
+		}
+
+		return nil, fmt.Errorf("Failed to open %s, %w", path, err)
+	}
+
+	defer r.Close()
+
+	var snapshot LookupSnapshot
+
+	dec := json.NewDecoder(r)
+	err = dec.Decode(&snapshot)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode %s, %w", path, err)
+	}
+
+	// Snapshots written with a different format are treated as though they were empty
+	// so that they will be rebuilt.
+
+	if snapshot.Version != LOOKUP_SNAPSHOT_VERSION {
+		return l, nil
+	}
+
+	l.head = snapshot.Head
+
+	if snapshot.Entries != nil {
+		l.entries = snapshot.Entries
+	}
+
+	if snapshot.Hashes != nil {
+		l.hashes.entries = snapshot.Hashes
+	}
+
+	return l, nil
+}
+
+// HashIndex returns the `PerceptualHashIndex` instance associated with 'l'.
+func (l *FileLookup) HashIndex() *PerceptualHashIndex {
+	return l.hashes
+}
+
+// Head returns the git commit hash of the data repository that 'l' was built from. It will be empty if 'l' has never been built.
+func (l *FileLookup) Head() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.head
+}
+
+// IsStale returns a boolean value indicating whether 'l' needs to be rebuilt. That is the case if it is empty, if
+// the git HEAD it was built from is not an ancestor of the git HEAD of the data repository at 'repo_path' or if any
+// records have been removed since then. Otherwise the records changed since the lookup was built can be applied using
+// `Update`, which is what happens in the normal publish-then-commit workflow.
+func (l *FileLookup) IsStale(ctx context.Context, repo_path string) (bool, error) {
+
+	_, _, ok, err := l.changedRecords(ctx, repo_path)
+
+	if err != nil {
+		return false, err
+	}
+
+	return !ok, nil
+}
+
+// Update updates the media ID and media path pointers (and perceptual hashes) in 'l' for the records in 'repo_path'
+// that have changed between the git HEAD that 'l' was built from and the current git HEAD of 'repo_path', and records
+// the latter. The existing entries for those records are removed first so that keys a record no longer has, and the
+// keys of records that have been superseded (for example by `Merge`), are dropped. It returns an error if 'l' is
+// stale (see `IsStale`) and needs to be rebuilt instead.
+func (l *FileLookup) Update(ctx context.Context, repo_path string) error {
+
+	repo_head, paths, ok, err := l.changedRecords(ctx, repo_path)
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("Lookup is stale and needs to be rebuilt")
+	}
+
+	bodies := make(map[string][]byte)
+	ids := make(map[int64]bool)
+
+	for _, path := range paths {
+
+		body, err := os.ReadFile(path)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read %s, %w", path, err)
+		}
+
+		wof_rsp := gjson.GetBytes(body, "properties.wof:id")
+
+		if !wof_rsp.Exists() {
+			return fmt.Errorf("%s is missing WOF ID", path)
+		}
+
+		bodies[path] = body
+		ids[wof_rsp.Int()] = true
+	}
+
+	l.removeIds(ids)
+	l.hashes.removeIds(ids)
+
+	index_opts := &PopulateLookupOptions{
+		Lookup:    l,
+		HashIndex: l.hashes,
+	}
+
+	for _, path := range paths {
+
+		err = indexRecord(ctx, index_opts, path, bodies[path])
+
+		if err != nil {
+			return fmt.Errorf("Failed to update lookup with %s, %w", path, err)
+		}
+	}
+
+	l.mu.Lock()
+	l.head = repo_head
+	l.mu.Unlock()
+
+	return nil
+}
+
+// Rebuild will discard all the entries in 'l' and populate it with records from 'indexer_path' crawled using
+// a whosonfirst/go-whosonfirst-iterate/v2 iterator defined by 'indexer_uri'. The git HEAD of 'indexer_path'
+// will be recorded so that future runs can determine whether the lookup is stale.
+func (l *FileLookup) Rebuild(ctx context.Context, indexer_uri string, indexer_path string) error {
+
+	head, err := GitHead(ctx, indexer_path)
+
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.entries = make(map[string]int64)
+	l.head = ""
+	l.mu.Unlock()
+
+	l.hashes.mu.Lock()
+	l.hashes.entries = make(map[string][]*PerceptualHashEntry)
+	l.hashes.mu.Unlock()
+
+	populate_opts := &PopulateLookupOptions{
+		Lookup:         l,
+		HashIndex:      l.hashes,
+		IteratorURI:    indexer_uri,
+		IteratorSource: indexer_path,
+	}
+
+	err = PopulateLookupWithOptions(ctx, populate_opts)
+
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.head = head
+	l.mu.Unlock()
+
+	return nil
+}
+
+// Save writes 'l' to disk as a JSON snapshot.
+func (l *FileLookup) Save(ctx context.Context) error {
+
+	l.mu.RLock()
+	l.hashes.mu.RLock()
+
+	snapshot := &LookupSnapshot{
+		Version:      LOOKUP_SNAPSHOT_VERSION,
+		Head:         l.head,
+		LastModified: time.Now().Unix(),
+		Entries:      l.entries,
+		Hashes:       l.hashes.entries,
+	}
+
+	enc_snapshot, err := json.Marshal(snapshot)
+
+	l.hashes.mu.RUnlock()
+	l.mu.RUnlock()
+
+	if err != nil {
+		return fmt.Errorf("Failed to marshal snapshot, %w", err)
+	}
+
+	// Write to a temporary file and then rename it so that a failed save doesn't clobber an existing snapshot.
+
+	wr, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path))
+
+	if err != nil {
+		return fmt.Errorf("Failed to create temporary file for %s, %w", l.path, err)
+	}
+
+	defer os.Remove(wr.Name())
+
+	_, err = wr.Write(enc_snapshot)
+
+	if err != nil {
+		wr.Close()
+		return fmt.Errorf("Failed to write %s, %w", wr.Name(), err)
+	}
+
+	err = wr.Close()
+
+	if err != nil {
+		return fmt.Errorf("Failed to close %s, %w", wr.Name(), err)
+	}
+
+	err = os.Rename(wr.Name(), l.path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to rename %s, %w", wr.Name(), err)
+	}
+
+	return nil
+}
+
+// changedRecords returns the git HEAD of the data repository at 'repo_path', the list of (absolute) paths of the
+// records that have been added or modified since the git HEAD that 'l' was built from and a boolean value indicating
+// whether those changes can be applied to 'l' (rather than rebuilding it).
+func (l *FileLookup) changedRecords(ctx context.Context, repo_path string) (string, []string, bool, error) {
+
+	paths := make([]string, 0)
+
+	head := l.Head()
+
+	if head == "" {
+		return "", paths, false, nil
+	}
+
+	repo, err := gogit.PlainOpen(repo_path)
+
+	if err != nil {
+		return "", nil, false, fmt.Errorf("Failed to open git repository %s, %w", repo_path, err)
+	}
+
+	ref, err := repo.Head()
+
+	if err != nil {
+		return "", nil, false, fmt.Errorf("Failed to determine HEAD for %s, %w", repo_path, err)
+	}
+
+	repo_head := ref.Hash().String()
+
+	if repo_head == head {
+		return repo_head, paths, true, nil
+	}
+
+	// The commit the lookup was built from may have been removed (for example by a rebase) or
+	// belong to a different repository in which case there is nothing to compare against.
+
+	from, err := repo.CommitObject(plumbing.NewHash(head))
+
+	if err != nil {
+		slog.Debug("Lookup commit not found", "head", head, "error", err)
+		return repo_head, nil, false, nil
+	}
+
+	to, err := repo.CommitObject(ref.Hash())
+
+	if err != nil {
+		return "", nil, false, fmt.Errorf("Failed to load commit %s, %w", repo_head, err)
+	}
+
+	is_ancestor, err := from.IsAncestor(to)
+
+	if err != nil {
+		return "", nil, false, fmt.Errorf("Failed to determine whether %s is an ancestor of %s, %w", head, repo_head, err)
+	}
+
+	if !is_ancestor {
+		return repo_head, nil, false, nil
+	}
+
+	from_tree, err := from.Tree()
+
+	if err != nil {
+		return "", nil, false, fmt.Errorf("Failed to load tree for %s, %w", head, err)
+	}
+
+	to_tree, err := to.Tree()
+
+	if err != nil {
+		return "", nil, false, fmt.Errorf("Failed to load tree for %s, %w", repo_head, err)
+	}
+
+	changes, err := object.DiffTreeWithOptions(ctx, from_tree, to_tree, nil)
+
+	if err != nil {
+		return "", nil, false, fmt.Errorf("Failed to compare %s and %s, %w", head, repo_head, err)
+	}
+
+	for _, ch := range changes {
+
+		action, err := ch.Action()
+
+		if err != nil {
+			return "", nil, false, fmt.Errorf("Failed to determine change action, %w", err)
+		}
+
+		// Removing a record would leave its media IDs and paths pointing to it so the lookup
+		// needs to be rebuilt.
+
+		if action == merkletrie.Delete {
+
+			if filepath.Ext(ch.From.Name) == ".geojson" {
+				return repo_head, nil, false, nil
+			}
+
+			continue
+		}
+
+		if filepath.Ext(ch.To.Name) != ".geojson" {
+			continue
+		}
+
+		paths = append(paths, filepath.Join(repo_path, filepath.FromSlash(ch.To.Name)))
+	}
+
+	return repo_head, paths, true, nil
+}
+
+// GitHead returns the commit hash of the HEAD of the git repository at 'repo_path'.
+func GitHead(ctx context.Context, repo_path string) (string, error) {
+
+	repo, err := gogit.PlainOpen(repo_path)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to open git repository %s, %w", repo_path, err)
+	}
+
+	ref, err := repo.Head()
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to determine HEAD for %s, %w", repo_path, err)
+	}
+
+	return ref.Hash().String(), nil
+}
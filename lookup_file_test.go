@@ -0,0 +1,348 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+)
+
+func TestFileLookup(t *testing.T) {
+
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "lookup.json")
+
+	l, err := OpenFileLookup(ctx, path)
+
+	if err != nil {
+		t.Fatalf("Failed to open lookup, %v", err)
+	}
+
+	stale, err := l.IsStale(ctx, ".")
+
+	if err != nil {
+		t.Fatalf("Failed to determine whether lookup is stale, %v", err)
+	}
+
+	if !stale {
+		t.Fatalf("Expected empty lookup to be stale")
+	}
+
+	err = l.Store(ctx, "8b1f0e1d5c2a", 1729355025)
+
+	if err != nil {
+		t.Fatalf("Failed to store entry, %v", err)
+	}
+
+	err = l.Save(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to save lookup, %v", err)
+	}
+
+	l2, err := OpenFileLookup(ctx, path)
+
+	if err != nil {
+		t.Fatalf("Failed to reopen lookup, %v", err)
+	}
+
+	id, ok := l2.Load(ctx, "8b1f0e1d5c2a")
+
+	if !ok {
+		t.Fatalf("Expected entry to exist after reopening lookup")
+	}
+
+	if id != 1729355025 {
+		t.Fatalf("Unexpected WOF ID, %d", id)
+	}
+}
+
+// newLookupTestRepo returns the path of a new (empty) git repository and its worktree.
+func newLookupTestRepo(t *testing.T) (string, *gogit.Worktree) {
+
+	repo_path := t.TempDir()
+
+	repo, err := gogit.PlainInit(repo_path, false)
+
+	if err != nil {
+		t.Fatalf("Failed to create git repository, %v", err)
+	}
+
+	wt, err := repo.Worktree()
+
+	if err != nil {
+		t.Fatalf("Failed to create worktree, %v", err)
+	}
+
+	return repo_path, wt
+}
+
+// lookupTestRecord returns a minimal WOF record with a media path and a perceptual hash.
+func lookupTestRecord(wof_id int64, media_path string, phash string) []byte {
+	body := fmt.Sprintf(`{"type":"Feature","properties":{"wof:id":%d,"instagram:post":{"media_id":"%s","perceptual_hash":"%s","taken_at":"Nov 26, 2024 4:00 PM"}}}`, wof_id, media_path, phash)
+	return []byte(body)
+}
+
+// writeLookupTestRecord writes (but does not commit) the WOF record 'body' to the data repository 'repo_path'
+// and returns its path relative to the data repository.
+func writeLookupTestRecord(t *testing.T, repo_path string, body []byte) string {
+
+	wof_id := gjson.GetBytes(body, "properties.wof:id").Int()
+
+	rel_path, err := uri.Id2RelPath(wof_id)
+
+	if err != nil {
+		t.Fatalf("Failed to derive path for %d, %v", wof_id, err)
+	}
+
+	rel_path = filepath.Join("data", rel_path)
+	abs_path := filepath.Join(repo_path, rel_path)
+
+	err = os.MkdirAll(filepath.Dir(abs_path), 0755)
+
+	if err != nil {
+		t.Fatalf("Failed to create data directory, %v", err)
+	}
+
+	err = os.WriteFile(abs_path, body, 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write record, %v", err)
+	}
+
+	return rel_path
+}
+
+// commitLookupTestRecords adds 'rel_paths' to the worktree 'wt' and commits them.
+func commitLookupTestRecords(t *testing.T, wt *gogit.Worktree, rel_paths ...string) {
+
+	for _, rel_path := range rel_paths {
+
+		_, err := wt.Add(rel_path)
+
+		if err != nil {
+			t.Fatalf("Failed to add %s, %v", rel_path, err)
+		}
+	}
+
+	commitLookupTestChanges(t, wt)
+}
+
+// commitLookupTestChanges commits the changes staged in the worktree 'wt'.
+func commitLookupTestChanges(t *testing.T, wt *gogit.Worktree) {
+
+	commit_opts := &gogit.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	}
+
+	_, err := wt.Commit("Update records", commit_opts)
+
+	if err != nil {
+		t.Fatalf("Failed to commit, %v", err)
+	}
+}
+
+func TestFileLookupCommit(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo_path, wt := newLookupTestRepo(t)
+
+	path_1 := writeLookupTestRecord(t, repo_path, lookupTestRecord(1, "media/posts/a.jpg", "p:b867679231ccc633"))
+	commitLookupTestRecords(t, wt, path_1)
+
+	lookup_path := filepath.Join(t.TempDir(), "lookup.json")
+
+	l, err := OpenFileLookup(ctx, lookup_path)
+
+	if err != nil {
+		t.Fatalf("Failed to open lookup, %v", err)
+	}
+
+	err = l.Rebuild(ctx, "repo://", repo_path)
+
+	if err != nil {
+		t.Fatalf("Failed to rebuild lookup, %v", err)
+	}
+
+	// A record written during a run is stored in the lookup, which is saved, and then committed
+
+	path_2 := writeLookupTestRecord(t, repo_path, lookupTestRecord(2, "media/posts/b.jpg", "p:4c3c3c3c3c3c3c3c"))
+
+	err = l.Store(ctx, "media/posts/b.jpg", 2)
+
+	if err != nil {
+		t.Fatalf("Failed to store entry, %v", err)
+	}
+
+	err = l.Save(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to save lookup, %v", err)
+	}
+
+	commitLookupTestRecords(t, wt, path_2)
+
+	// A record written by something other than publish
+
+	path_3 := writeLookupTestRecord(t, repo_path, lookupTestRecord(3, "media/posts/c.jpg", "p:0f0f0f0f0f0f0f0f"))
+	commitLookupTestRecords(t, wt, path_3)
+
+	l2, err := OpenFileLookup(ctx, lookup_path)
+
+	if err != nil {
+		t.Fatalf("Failed to reopen lookup, %v", err)
+	}
+
+	stale, err := l2.IsStale(ctx, repo_path)
+
+	if err != nil {
+		t.Fatalf("Failed to determine whether lookup is stale, %v", err)
+	}
+
+	if stale {
+		t.Fatalf("Expected lookup to not be stale after committing records")
+	}
+
+	err = l2.Update(ctx, repo_path)
+
+	if err != nil {
+		t.Fatalf("Failed to update lookup, %v", err)
+	}
+
+	for k, expected := range map[string]int64{
+		"media/posts/a.jpg": 1,
+		"media/posts/b.jpg": 2,
+		"media/posts/c.jpg": 3,
+	} {
+
+		id, ok := l2.Load(ctx, k)
+
+		if !ok || id != expected {
+			t.Fatalf("Expected %s to point to %d, got %d (%t)", k, expected, id, ok)
+		}
+	}
+
+	repo_head, err := GitHead(ctx, repo_path)
+
+	if err != nil {
+		t.Fatalf("Failed to determine HEAD, %v", err)
+	}
+
+	if l2.Head() != repo_head {
+		t.Fatalf("Expected lookup HEAD to be %s, got %s", repo_head, l2.Head())
+	}
+
+	// Removing a record means the lookup needs to be rebuilt
+
+	_, err = wt.Remove(path_1)
+
+	if err != nil {
+		t.Fatalf("Failed to remove %s, %v", path_1, err)
+	}
+
+	commitLookupTestChanges(t, wt)
+
+	stale, err = l2.IsStale(ctx, repo_path)
+
+	if err != nil {
+		t.Fatalf("Failed to determine whether lookup is stale, %v", err)
+	}
+
+	if !stale {
+		t.Fatalf("Expected lookup to be stale after removing a record")
+	}
+}
+
+func TestFileLookupUpdateMerged(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo_path, wt := newLookupTestRepo(t)
+
+	body_1 := lookupTestRecord(1, "media/posts/a.jpg", "p:b867679231ccc633")
+	body_2 := lookupTestRecord(2, "media/posts/b.jpg", "p:4c3c3c3c3c3c3c3c")
+	body_3 := lookupTestRecord(3, "media/posts/c.jpg", "p:0f0f0f0f0f0f0f0f")
+
+	path_1 := writeLookupTestRecord(t, repo_path, body_1)
+	path_2 := writeLookupTestRecord(t, repo_path, body_2)
+	path_3 := writeLookupTestRecord(t, repo_path, body_3)
+
+	commitLookupTestRecords(t, wt, path_1, path_2, path_3)
+
+	media_id_2, err := DeriveMediaId(body_2, "properties.instagram:post")
+
+	if err != nil {
+		t.Fatalf("Failed to derive media ID, %v", err)
+	}
+
+	l, err := OpenFileLookup(ctx, filepath.Join(t.TempDir(), "lookup.json"))
+
+	if err != nil {
+		t.Fatalf("Failed to open lookup, %v", err)
+	}
+
+	err = l.Rebuild(ctx, "repo://", repo_path)
+
+	if err != nil {
+		t.Fatalf("Failed to rebuild lookup, %v", err)
+	}
+
+	// Merge record 2 in to record 1 and change the media path of record 3
+
+	canonical, others, err := MergeRecords(ctx, body_1, [][]byte{body_2})
+
+	if err != nil {
+		t.Fatalf("Failed to merge records, %v", err)
+	}
+
+	writeLookupTestRecord(t, repo_path, canonical)
+	writeLookupTestRecord(t, repo_path, others[0])
+	writeLookupTestRecord(t, repo_path, lookupTestRecord(3, "media/posts/c2.jpg", "p:0f0f0f0f0f0f0f0f"))
+
+	commitLookupTestRecords(t, wt, path_1, path_2, path_3)
+
+	err = l.Update(ctx, repo_path)
+
+	if err != nil {
+		t.Fatalf("Failed to update lookup, %v", err)
+	}
+
+	for k, expected := range map[string]int64{
+		"media/posts/a.jpg":  1,
+		"media/posts/b.jpg":  1,
+		media_id_2:           1,
+		"media/posts/c2.jpg": 3,
+	} {
+
+		id, ok := l.Load(ctx, k)
+
+		if !ok || id != expected {
+			t.Fatalf("Expected %s to point to %d, got %d (%t)", k, expected, id, ok)
+		}
+	}
+
+	_, ok := l.Load(ctx, "media/posts/c.jpg")
+
+	if ok {
+		t.Fatalf("Expected the previous media path of record 3 to be removed")
+	}
+
+	for _, entries := range l.HashIndex().entries {
+
+		for _, e := range entries {
+
+			if e.WOFId == 2 {
+				t.Fatalf("Expected hash index entries for superseded record to be removed")
+			}
+		}
+	}
+}
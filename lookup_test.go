@@ -0,0 +1,68 @@
+package publish
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPopulateLookupSlideConflict(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo := t.TempDir()
+
+	// The second slide of record 1 and the single image of record 2 derive the same media ID
+
+	records := map[string]string{
+		"1.geojson": `{"type":"Feature","properties":{"wof:id":1,"instagram:post":{"media_id":"media/posts/a.jpg","perceptual_hash":"p:b867679231ccc633","taken_at":"Nov 26, 2024 4:00 PM","media":[{"perceptual_hash":"p:b867679231ccc633"},{"perceptual_hash":"p:4c3c3c3c3c3c3c3c"}]}}}`,
+		"2.geojson": `{"type":"Feature","properties":{"wof:id":2,"instagram:post":{"media_id":"media/posts/b.jpg","perceptual_hash":"p:4c3c3c3c3c3c3c3c","taken_at":"Nov 26, 2024 4:00 PM"}}}`,
+	}
+
+	for fname, body := range records {
+
+		err := os.WriteFile(filepath.Join(repo, fname), []byte(body), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write record, %v", err)
+		}
+	}
+
+	media_id, err := DeriveMediaId([]byte(records["2.geojson"]), "properties.instagram:post")
+
+	if err != nil {
+		t.Fatalf("Failed to derive media ID, %v", err)
+	}
+
+	audit := NewLookupAudit()
+
+	populate_opts := &PopulateLookupOptions{
+		Lookup:         NewMemoryLookup(),
+		Audit:          audit,
+		IteratorURI:    "directory://",
+		IteratorSource: repo,
+	}
+
+	err = PopulateLookupWithOptions(ctx, populate_opts)
+
+	if err != nil {
+		t.Fatalf("Failed to populate lookup, %v", err)
+	}
+
+	conflicts := audit.Conflicts()
+
+	if len(conflicts) != 1 {
+		t.Fatalf("Expected 1 conflict, got %d", len(conflicts))
+	}
+
+	c := conflicts[0]
+
+	if c.Type != CONFLICT_MEDIA_ID || c.Key != media_id {
+		t.Fatalf("Unexpected conflict: %s %s", c.Type, c.Key)
+	}
+
+	if len(c.WOFIds) != 2 || c.WOFIds[0] != 1 || c.WOFIds[1] != 2 {
+		t.Fatalf("Unexpected WOF IDs for conflict: %v", c.WOFIds)
+	}
+}
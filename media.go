@@ -2,6 +2,8 @@ package publish
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/sfomuseum/go-sfomuseum-instagram/media"
 	"github.com/tidwall/gjson"
@@ -9,19 +11,111 @@ import (
 
 // DeriveMediaId will derive a (hopefully) persistent SFO Museum specific media ID
 // from JSON properties in 'body'. This might be a `go-sfomuseum-instagram/media.Photo`
-// instance or a WOF-style SFO Museum record.
+// instance or a WOF-style SFO Museum record. For carousel posts (with more than one
+// element in the `media` property) the media ID is derived from the sorted list of
+// each element's hash so that it does not change if Instagram reorders the slides.
+// Perceptual hashes are preferred over file hashes. Video hashes are never used because
+// whether (and how) a video is assigned one depends on the options a run was started with
+// (for example whether H.264 keyframes can be decoded) and media IDs must not; they are only
+// used for fuzzy matching. A re-encoded video is therefore assigned a different media ID.
 func DeriveMediaId(body []byte, prefix string) (string, error) {
 
 	path_taken := "taken_at"
 	path_phash := "perceptual_hash"
 	path_fhash := "file_hash"
+	path_media := "media"
 
 	if prefix != "" {
 		path_taken = fmt.Sprintf("%s.%s", prefix, path_taken)
 		path_phash = fmt.Sprintf("%s.%s", prefix, path_phash)
 		path_fhash = fmt.Sprintf("%s.%s", prefix, path_fhash)
+		path_media = fmt.Sprintf("%s.%s", prefix, path_media)
 	}
 
+	taken_at, err := deriveTakenAt(body, path_taken)
+
+	if err != nil {
+		return "", err
+	}
+
+	media_rsp := gjson.GetBytes(body, path_media)
+
+	if len(media_rsp.Array()) > 1 {
+
+		hashes, err := deriveSlideHashes(media_rsp)
+
+		if err != nil {
+			return "", err
+		}
+
+		sort.Strings(hashes)
+
+		media_id := fmt.Sprintf("%s %s", taken_at, strings.Join(hashes, " "))
+		return media.DeriveMediaIdFromString(media_id), nil
+	}
+
+	hash_rsp := gjson.GetBytes(body, path_phash)
+
+	if !hash_rsp.Exists() {
+		hash_rsp = gjson.GetBytes(body, path_fhash)
+	}
+
+	if !hash_rsp.Exists() {
+		return "", fmt.Errorf("Missing both '%s' and '%s' property (video hashes are not used to derive media IDs)", path_phash, path_fhash)
+	}
+
+	hash := hash_rsp.String()
+
+	media_id := fmt.Sprintf("%s %s", taken_at, hash)
+
+	// log.Println("Derive", media_id)
+	return media.DeriveMediaIdFromString(media_id), nil
+}
+
+// DeriveSlideMediaIds will derive a media ID for each element (slide) of a carousel post in 'body', using the
+// same rules as `DeriveMediaId` for single-media posts. These are used to match carousel posts where Instagram
+// has re-encoded some, but not all, of the slides (or which were previously published as individual posts).
+// It returns an empty list for posts that are not carousels.
+func DeriveSlideMediaIds(body []byte, prefix string) ([]string, error) {
+
+	path_taken := "taken_at"
+	path_media := "media"
+
+	if prefix != "" {
+		path_taken = fmt.Sprintf("%s.%s", prefix, path_taken)
+		path_media = fmt.Sprintf("%s.%s", prefix, path_media)
+	}
+
+	media_ids := make([]string, 0)
+
+	media_rsp := gjson.GetBytes(body, path_media)
+
+	if len(media_rsp.Array()) < 2 {
+		return media_ids, nil
+	}
+
+	taken_at, err := deriveTakenAt(body, path_taken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	hashes, err := deriveSlideHashes(media_rsp)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hash := range hashes {
+		media_id := fmt.Sprintf("%s %s", taken_at, hash)
+		media_ids = append(media_ids, media.DeriveMediaIdFromString(media_id))
+	}
+
+	return media_ids, nil
+}
+
+func deriveTakenAt(body []byte, path_taken string) (string, error) {
+
 	taken_rsp := gjson.GetBytes(body, path_taken)
 
 	if !taken_rsp.Exists() {
@@ -43,21 +137,29 @@ func DeriveMediaId(body []byte, prefix string) (string, error) {
 
 	// END OF ok, see this?
 
-	hash_rsp := gjson.GetBytes(body, path_phash)
+	return taken_at, nil
+}
 
-	if !hash_rsp.Exists() {
-		hash_rsp = gjson.GetBytes(body, path_fhash)
-	}
+func deriveSlideHashes(media_rsp gjson.Result) ([]string, error) {
 
-	if !hash_rsp.Exists() {
-		return "", fmt.Errorf("Missing both '%s' and '%s' property", path_phash, path_fhash)
+	hashes := make([]string, 0)
 
-	}
+	for i, m := range media_rsp.Array() {
 
-	hash := hash_rsp.String()
+		// See notes about video hashes in DeriveMediaId
 
-	media_id := fmt.Sprintf("%s %s", taken_at, hash)
+		hash_rsp := m.Get("perceptual_hash")
 
-	// log.Println("Derive", media_id)
-	return media.DeriveMediaIdFromString(media_id), nil
+		if !hash_rsp.Exists() {
+			hash_rsp = m.Get("file_hash")
+		}
+
+		if !hash_rsp.Exists() {
+			return nil, fmt.Errorf("Missing both 'perceptual_hash' and 'file_hash' property for media element %d (video hashes are not used to derive media IDs)", i)
+		}
+
+		hashes = append(hashes, hash_rsp.String())
+	}
+
+	return hashes, nil
 }
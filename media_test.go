@@ -0,0 +1,77 @@
+package publish
+
+import (
+	"testing"
+)
+
+func TestDeriveMediaIdCarousel(t *testing.T) {
+
+	a := []byte(`{"taken_at":"Nov 26, 2024 4:00 PM","media":[{"path":"a.jpg","perceptual_hash":"p:b867679231ccc633"},{"path":"b.jpg","perceptual_hash":"p:4798986dce3339cc"}]}`)
+	b := []byte(`{"taken_at":"Nov 26, 2024 4:00 PM","media":[{"path":"b.jpg","perceptual_hash":"p:4798986dce3339cc"},{"path":"a.jpg","perceptual_hash":"p:b867679231ccc633"}]}`)
+
+	id_a, err := DeriveMediaId(a, "")
+
+	if err != nil {
+		t.Fatalf("Failed to derive media ID, %v", err)
+	}
+
+	id_b, err := DeriveMediaId(b, "")
+
+	if err != nil {
+		t.Fatalf("Failed to derive media ID, %v", err)
+	}
+
+	if id_a != id_b {
+		t.Fatalf("Expected media IDs for reordered carousel to match, %s != %s", id_a, id_b)
+	}
+
+	// Slide media IDs should match the media ID of an equivalent single-image post
+
+	single := []byte(`{"taken_at":"Nov 26, 2024 4:00 PM","perceptual_hash":"p:b867679231ccc633"}`)
+
+	single_id, err := DeriveMediaId(single, "")
+
+	if err != nil {
+		t.Fatalf("Failed to derive media ID, %v", err)
+	}
+
+	slide_ids, err := DeriveSlideMediaIds(a, "")
+
+	if err != nil {
+		t.Fatalf("Failed to derive slide media IDs, %v", err)
+	}
+
+	if len(slide_ids) != 2 || slide_ids[0] != single_id {
+		t.Fatalf("Unexpected slide media IDs, %v", slide_ids)
+	}
+}
+
+func TestDeriveMediaIdVideo(t *testing.T) {
+
+	// A video's media ID must not depend on whether (or how) it was assigned a video hash
+
+	without_vhash := []byte(`{"taken_at":"Nov 26, 2024 4:00 PM","file_hash":"6a09e667f3bcc908"}`)
+	with_vhash := []byte(`{"taken_at":"Nov 26, 2024 4:00 PM","file_hash":"6a09e667f3bcc908","video_hash":"p:b867679231ccc633,p:4798986dce3339cc"}`)
+
+	id_a, err := DeriveMediaId(without_vhash, "")
+
+	if err != nil {
+		t.Fatalf("Failed to derive media ID, %v", err)
+	}
+
+	id_b, err := DeriveMediaId(with_vhash, "")
+
+	if err != nil {
+		t.Fatalf("Failed to derive media ID, %v", err)
+	}
+
+	if id_a != id_b {
+		t.Fatalf("Expected media ID to ignore video hash, %s != %s", id_a, id_b)
+	}
+
+	_, err = DeriveMediaId([]byte(`{"taken_at":"Nov 26, 2024 4:00 PM","video_hash":"p:b867679231ccc633"}`), "")
+
+	if err == nil {
+		t.Fatalf("Expected error deriving media ID from a video hash alone")
+	}
+}
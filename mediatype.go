@@ -0,0 +1,232 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/sfomuseum/go-sfomuseum-instagram-publish/mp4"
+	"github.com/sfomuseum/go-sfomuseum-instagram/hash"
+	"github.com/tidwall/sjson"
+	"gocloud.dev/blob"
+)
+
+// MEDIA_TYPE_IMAGE is the media type for still images.
+const MEDIA_TYPE_IMAGE string = "image"
+
+// MEDIA_TYPE_VIDEO is the media type for video files.
+const MEDIA_TYPE_VIDEO string = "video"
+
+// MEDIA_TYPE_UNKNOWN is the media type for files that can not be identified.
+const MEDIA_TYPE_UNKNOWN string = "unknown"
+
+// ftyp_brands maps ISO base media file format (MP4, QuickTime, HEIF) "ftyp" brands to MIME types.
+var ftyp_brands = map[string]string{
+	"qt  ": "video/quicktime",
+	"isom": "video/mp4",
+	"iso2": "video/mp4",
+	"iso4": "video/mp4",
+	"iso5": "video/mp4",
+	"iso6": "video/mp4",
+	"mp41": "video/mp4",
+	"mp42": "video/mp4",
+	"avc1": "video/mp4",
+	"dash": "video/mp4",
+	"M4V ": "video/x-m4v",
+	"3gp4": "video/3gpp",
+	"3gp5": "video/3gpp",
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"heim": "image/heic",
+	"heis": "image/heic",
+	"hevc": "image/heic-sequence",
+	"hevx": "image/heic-sequence",
+	"mif1": "image/heif",
+	"msf1": "image/heif-sequence",
+	"avif": "image/avif",
+}
+
+// perceptual_mimetypes is the list of MIME types that can be decoded (and perceptually hashed) by the `image` package.
+var perceptual_mimetypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// DetectMimeType returns the MIME type for 'body', the contents (or at least the first 512 bytes) of a media file.
+// Magic bytes are inspected first, including the "ftyp" box of ISO base media files (MP4, QuickTime and HEIF)
+// which `net/http.DetectContentType` does not fully support, falling back to the extension of 'path'. It returns
+// "application/octet-stream" if the MIME type can not be determined.
+func DetectMimeType(path string, body []byte) string {
+
+	if len(body) >= 12 && string(body[4:8]) == "ftyp" {
+
+		t, ok := ftyp_brands[string(body[8:12])]
+
+		if ok {
+			return t
+		}
+	}
+
+	t := http.DetectContentType(body)
+
+	// DetectContentType returns "application/octet-stream" and "text/plain" for anything it doesn't recognize
+
+	if t != "application/octet-stream" && !strings.HasPrefix(t, "text/plain") {
+		return t
+	}
+
+	ext_t := mime.TypeByExtension(strings.ToLower(filepath.Ext(path)))
+
+	if ext_t != "" {
+		return ext_t
+	}
+
+	return "application/octet-stream"
+}
+
+// MediaTypeForMimeType returns the (SFO Museum) media type, one of `MEDIA_TYPE_IMAGE`, `MEDIA_TYPE_VIDEO` or `MEDIA_TYPE_UNKNOWN`, for 'mime_type'.
+func MediaTypeForMimeType(mime_type string) string {
+
+	switch {
+	case strings.HasPrefix(mime_type, "image/"):
+		return MEDIA_TYPE_IMAGE
+	case strings.HasPrefix(mime_type, "video/"):
+		return MEDIA_TYPE_VIDEO
+	default:
+		return MEDIA_TYPE_UNKNOWN
+	}
+}
+
+// video_mimetypes is the list of MIME types for video files whose container can be parsed by the `mp4` package.
+var video_mimetypes = map[string]bool{
+	"video/mp4":       true,
+	"video/quicktime": true,
+	"video/x-m4v":     true,
+	"video/3gpp":      true,
+}
+
+// AppendMediaOptions is a struct containing configuration options for the `AppendMediaTypeAndHashes` method.
+type AppendMediaOptions struct {
+	// Bucket is the `blob.Bucket` instance where media files are read from.
+	Bucket *blob.Bucket
+	// VideoFrameDecoder is an optional `VideoFrameDecoder` instance used to decode video keyframes when deriving
+	// video perceptual hashes.
+	VideoFrameDecoder VideoFrameDecoder
+	// VideoHashFrames is the number of keyframes used to derive video perceptual hashes. If zero
+	// `DEFAULT_VIDEO_HASH_FRAMES` will be used.
+	VideoHashFrames int
+	// Limiter is an optional `Limiter` instance used to limit reads from Bucket.
+	Limiter *Limiter
+}
+
+// AppendMediaTypeAndHashes reads the media file defined by the relative 'path' from 'opts.Bucket', detects its MIME
+// type using `DetectMimeType` and appends "media_type" and "mime_type" properties to 'body'. Images that can be
+// decoded are assigned a "perceptual_hash" property; everything else (videos, HEIC and WebP images, unknown files) is
+// assigned a "file_hash" (SHA-256) property. This mirrors the hashing strategy `DeriveMediaId` expects. Videos whose
+// keyframes can be decoded are also assigned a "video_hash" property (see `VideoPerceptualHash`).
+func AppendMediaTypeAndHashes(ctx context.Context, opts *AppendMediaOptions, path string, body []byte) ([]byte, error) {
+
+	media_body, err := readMedia(ctx, opts, path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mime_type := DetectMimeType(path, media_body)
+	media_type := MediaTypeForMimeType(mime_type)
+
+	updates := map[string]string{
+		"media_type": media_type,
+		"mime_type":  mime_type,
+	}
+
+	if perceptual_mimetypes[mime_type] {
+
+		p_hash, err := hash.PerceptualHash(bytes.NewReader(media_body))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to generate perceptual hash for %s, %w", path, err)
+		}
+
+		updates["perceptual_hash"] = p_hash
+
+	} else {
+
+		file_hash, err := hash.FileHash(bytes.NewReader(media_body))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to generate file hash for %s, %w", path, err)
+		}
+
+		updates["file_hash"] = file_hash
+	}
+
+	if video_mimetypes[mime_type] {
+
+		frames := opts.VideoHashFrames
+
+		if frames == 0 {
+			frames = DEFAULT_VIDEO_HASH_FRAMES
+		}
+
+		v_hash, err := VideoPerceptualHash(ctx, bytes.NewReader(media_body), opts.VideoFrameDecoder, frames)
+
+		switch {
+		case errors.Is(err, ErrUnsupportedCodec), errors.Is(err, mp4.ErrNoVideoTrack):
+			// Fall back to the file hash
+		case err != nil:
+			return nil, fmt.Errorf("Failed to generate video hash for %s, %w", path, err)
+		default:
+			updates["video_hash"] = v_hash
+		}
+	}
+
+	for k, v := range updates {
+
+		body, err = sjson.SetBytes(body, k, v)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to assign %s (%s), %w", k, v, err)
+		}
+	}
+
+	return body, nil
+}
+
+// readMedia reads the media file defined by the relative 'path' from 'opts.Bucket', subject to 'opts.Limiter'.
+func readMedia(ctx context.Context, opts *AppendMediaOptions, path string) ([]byte, error) {
+
+	release, err := opts.Limiter.Acquire(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	media_r, err := opts.Bucket.NewReader(ctx, path, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s, %w", path, err)
+	}
+
+	defer media_r.Close()
+
+	media_body, err := io.ReadAll(media_r)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s, %w", path, err)
+	}
+
+	return media_body, nil
+}
@@ -0,0 +1,49 @@
+package publish
+
+import (
+	"testing"
+)
+
+func TestDetectMimeType(t *testing.T) {
+
+	tests := []struct {
+		path     string
+		body     []byte
+		expected string
+	}{
+		{"photo.jpg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), "image/jpeg"},
+		{"photo", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"), "image/png"},
+		{"video.mov", []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00"), "video/quicktime"},
+		{"video.jpg", []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00"), "video/mp4"},
+		{"photo.heic", []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), "image/heic"},
+		{"unknown", []byte("\x00\x01\x02\x03"), "application/octet-stream"},
+	}
+
+	for _, test := range tests {
+
+		mime_type := DetectMimeType(test.path, test.body)
+
+		if mime_type != test.expected {
+			t.Fatalf("Unexpected MIME type for %s: %s (expected %s)", test.path, mime_type, test.expected)
+		}
+	}
+}
+
+func TestMediaTypeForMimeType(t *testing.T) {
+
+	tests := map[string]string{
+		"image/jpeg":               MEDIA_TYPE_IMAGE,
+		"image/heic":               MEDIA_TYPE_IMAGE,
+		"video/quicktime":          MEDIA_TYPE_VIDEO,
+		"application/octet-stream": MEDIA_TYPE_UNKNOWN,
+	}
+
+	for mime_type, expected := range tests {
+
+		media_type := MediaTypeForMimeType(mime_type)
+
+		if media_type != expected {
+			t.Fatalf("Unexpected media type for %s: %s (expected %s)", mime_type, media_type, expected)
+		}
+	}
+}
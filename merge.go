@@ -0,0 +1,417 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	sfom_reader "github.com/sfomuseum/go-sfomuseum-reader"
+	sfom_writer "github.com/sfomuseum/go-sfomuseum-writer/v3"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-writer/v3"
+)
+
+// HISTORICAL_MEDIA_IDS_PROPERTY is the WOF property where the (SFO Museum) media IDs, and slide media IDs, of records
+// merged in to a record are stored. They are added to the lookup, pointing to the record, by `PopulateLookupWithOptions`.
+const HISTORICAL_MEDIA_IDS_PROPERTY string = "instagram:historical_media_ids"
+
+// HISTORICAL_PATHS_PROPERTY is the WOF property where the media paths (`instagram:post.media_id` properties) of
+// records merged in to a record are stored. They are added to the lookup, pointing to the record, by
+// `PopulateLookupWithOptions`.
+const HISTORICAL_PATHS_PROPERTY string = "instagram:historical_paths"
+
+// HISTORICAL_HASHES_PROPERTY is the WOF property where the perceptual (or video perceptual) hashes of records merged
+// in to a record are stored. They are added to the perceptual hash index by `PopulateLookupWithOptions`.
+const HISTORICAL_HASHES_PROPERTY string = "instagram:historical_hashes"
+
+// MergeOptions is a struct containing configuration options for the `Merge` method.
+type MergeOptions struct {
+	// Reader is the `reader.Reader` instance used to read the records to merge.
+	Reader reader.Reader
+	// Writer is the `writer.Writer` instance used to write merged records. It may be nil if DryRun is true.
+	Writer writer.Writer
+	// Canonical is the optional WOF ID of the record the other records will be merged in to. If zero the canonical
+	// record is chosen using `ChooseCanonical`.
+	Canonical int64
+	// DryRun is a boolean flag indicating that records should be merged but not written.
+	DryRun bool
+}
+
+// MergeResult is a struct describing the outcome of merging duplicate records.
+type MergeResult struct {
+	// Canonical is the WOF ID of the record the other records were merged in to.
+	Canonical int64 `json:"canonical"`
+	// Superseded is the sorted list of WOF IDs of the records that were merged in to (and superseded by) Canonical.
+	Superseded []int64 `json:"superseded"`
+	// Changes is the list of property-level changes made to each record, keyed by WOF ID.
+	Changes map[int64][]*PropertyChange `json:"changes"`
+}
+
+// ChooseCanonical returns the offset of the record in 'records' that other records should be merged in to. This
+// is the record with the lowest WOF ID, which is to say the first one to have been published, that has not been
+// superseded or deprecated.
+func ChooseCanonical(records [][]byte) (int, error) {
+
+	if len(records) == 0 {
+		return -1, fmt.Errorf("No records")
+	}
+
+	idx := -1
+	canonical_id := int64(0)
+
+	for i, body := range records {
+
+		if isSupersededOrDeprecated(body) {
+			continue
+		}
+
+		id := gjson.GetBytes(body, "properties.wof:id").Int()
+
+		if idx == -1 || id < canonical_id {
+			idx = i
+			canonical_id = id
+		}
+	}
+
+	if idx == -1 {
+		return -1, fmt.Errorf("Every record has been superseded or deprecated")
+	}
+
+	return idx, nil
+}
+
+// Merge merges the records identified by 'wof_ids' in to a single canonical record (defined by 'opts.Canonical' or
+// chosen using `ChooseCanonical`), using `MergeRecords`, and writes every record using `sfom_writer.WriteBytes`
+// unless 'opts.DryRun' is true.
+func Merge(ctx context.Context, opts *MergeOptions, wof_ids []int64) (*MergeResult, error) {
+
+	if len(wof_ids) < 2 {
+		return nil, fmt.Errorf("Merging requires at least two records")
+	}
+
+	records := make([][]byte, 0)
+	seen := make(map[int64]bool)
+
+	for _, id := range wof_ids {
+
+		if seen[id] {
+			continue
+		}
+
+		seen[id] = true
+
+		body, err := sfom_reader.LoadBytesFromID(ctx, opts.Reader, id)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load record %d, %w", id, err)
+		}
+
+		records = append(records, body)
+	}
+
+	if len(records) < 2 {
+		return nil, fmt.Errorf("Merging requires at least two records")
+	}
+
+	idx := -1
+
+	if opts.Canonical != 0 {
+
+		for i, body := range records {
+
+			if gjson.GetBytes(body, "properties.wof:id").Int() == opts.Canonical {
+				idx = i
+				break
+			}
+		}
+
+		if idx == -1 {
+			return nil, fmt.Errorf("Canonical record %d is not one of the records being merged", opts.Canonical)
+		}
+
+	} else {
+
+		i, err := ChooseCanonical(records)
+
+		if err != nil {
+			return nil, err
+		}
+
+		idx = i
+	}
+
+	canonical := records[idx]
+	others := make([][]byte, 0)
+
+	for i, body := range records {
+
+		if i != idx {
+			others = append(others, body)
+		}
+	}
+
+	new_canonical, new_others, err := MergeRecords(ctx, canonical, others)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MergeResult{
+		Canonical:  gjson.GetBytes(canonical, "properties.wof:id").Int(),
+		Superseded: make([]int64, len(others)),
+		Changes:    make(map[int64][]*PropertyChange),
+	}
+
+	old_records := append([][]byte{canonical}, others...)
+	new_records := append([][]byte{new_canonical}, new_others...)
+
+	for i, old_body := range old_records {
+
+		id := gjson.GetBytes(old_body, "properties.wof:id").Int()
+
+		if i > 0 {
+			result.Superseded[i-1] = id
+		}
+
+		changes, err := DiffRecords(old_body, new_records[i])
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to diff record %d, %w", id, err)
+		}
+
+		result.Changes[id] = changes
+	}
+
+	sort.Slice(result.Superseded, func(i, j int) bool {
+		return result.Superseded[i] < result.Superseded[j]
+	})
+
+	if opts.DryRun {
+		slog.Debug("Dry run, skip writing merged records", "canonical", result.Canonical, "superseded", result.Superseded)
+		return result, nil
+	}
+
+	for _, body := range new_records {
+
+		_, err := sfom_writer.WriteBytes(ctx, opts.Writer, body)
+
+		if err != nil {
+			id := gjson.GetBytes(body, "properties.wof:id").Int()
+			slog.Error("Failed to write merged record", "id", id, "error", err)
+			return nil, fmt.Errorf("Failed to write record %d, %w", id, err)
+		}
+	}
+
+	return result, nil
+}
+
+// MergeRecords merges the WOF records 'others' in to the WOF record 'canonical'. The media IDs, media paths and
+// perceptual hashes of 'others' (and any they had already had merged in to them) are appended to the historical
+// properties of 'canonical' and their WOF IDs to its `wof:supersedes` property. Each of 'others' is marked as
+// deprecated and superseded by 'canonical'. It returns the updated canonical record and the updated list of others.
+// It returns an error if 'canonical' has itself been superseded or deprecated.
+func MergeRecords(ctx context.Context, canonical []byte, others [][]byte) ([]byte, [][]byte, error) {
+
+	canonical_id := gjson.GetBytes(canonical, "properties.wof:id").Int()
+
+	// Otherwise the supersession chain for others would end in a deprecated record
+
+	if isSupersededOrDeprecated(canonical) {
+		return nil, nil, fmt.Errorf("Canonical record %d has been superseded or deprecated", canonical_id)
+	}
+
+	canonical_media_ids, err := recordMediaIds(canonical)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to derive media IDs for %d, %w", canonical_id, err)
+	}
+
+	current := map[string]map[string]bool{
+		HISTORICAL_MEDIA_IDS_PROPERTY: make(map[string]bool),
+		HISTORICAL_PATHS_PROPERTY: {
+			gjson.GetBytes(canonical, "properties.instagram:post.media_id").String(): true,
+		},
+		HISTORICAL_HASHES_PROPERTY: {
+			recordHash(canonical): true,
+		},
+	}
+
+	for _, id := range canonical_media_ids {
+		current[HISTORICAL_MEDIA_IDS_PROPERTY][id] = true
+	}
+
+	historical := make(map[string]map[string]bool)
+
+	for k := range current {
+
+		historical[k] = make(map[string]bool)
+
+		for _, v := range gjson.GetBytes(canonical, "properties."+k).Array() {
+			historical[k][v.String()] = true
+		}
+	}
+
+	supersedes := make(map[int64]bool)
+
+	for _, v := range gjson.GetBytes(canonical, "properties.wof:supersedes").Array() {
+		supersedes[v.Int()] = true
+	}
+
+	deprecated := time.Now().Format("2006-01-02")
+
+	new_others := make([][]byte, len(others))
+
+	for i, body := range others {
+
+		wof_id := gjson.GetBytes(body, "properties.wof:id").Int()
+
+		if wof_id == canonical_id {
+			return nil, nil, fmt.Errorf("Can not merge record %d in to itself", wof_id)
+		}
+
+		superseded_by := gjson.GetBytes(body, "properties.wof:superseded_by").Array()
+
+		if len(superseded_by) > 0 {
+			return nil, nil, fmt.Errorf("Record %d has already been superseded by %s", wof_id, gjson.GetBytes(body, "properties.wof:superseded_by").Raw)
+		}
+
+		media_ids, err := recordMediaIds(body)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to derive media IDs for %d, %w", wof_id, err)
+		}
+
+		for _, id := range media_ids {
+			historical[HISTORICAL_MEDIA_IDS_PROPERTY][id] = true
+		}
+
+		historical[HISTORICAL_PATHS_PROPERTY][gjson.GetBytes(body, "properties.instagram:post.media_id").String()] = true
+		historical[HISTORICAL_HASHES_PROPERTY][recordHash(body)] = true
+
+		for k := range historical {
+
+			for _, v := range gjson.GetBytes(body, "properties."+k).Array() {
+				historical[k][v.String()] = true
+			}
+		}
+
+		supersedes[wof_id] = true
+
+		updates := map[string]interface{}{
+			"properties.wof:superseded_by": []int64{canonical_id},
+			"properties.mz:is_current":     0,
+			"properties.edtf:deprecated":   deprecated,
+		}
+
+		for k, v := range updates {
+
+			body, err = sjson.SetBytes(body, k, v)
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("Failed to assign %s to %d, %w", k, wof_id, err)
+			}
+		}
+
+		new_others[i] = body
+	}
+
+	for k, values := range historical {
+
+		list := make([]string, 0)
+
+		for v := range values {
+
+			if v == "" || current[k][v] {
+				continue
+			}
+
+			list = append(list, v)
+		}
+
+		if len(list) == 0 {
+			continue
+		}
+
+		sort.Strings(list)
+
+		canonical, err = sjson.SetBytes(canonical, "properties."+k, list)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to assign %s to %d, %w", k, canonical_id, err)
+		}
+	}
+
+	supersedes_list := make([]int64, 0)
+
+	for id := range supersedes {
+		supersedes_list = append(supersedes_list, id)
+	}
+
+	sort.Slice(supersedes_list, func(i, j int) bool {
+		return supersedes_list[i] < supersedes_list[j]
+	})
+
+	canonical, err = sjson.SetBytes(canonical, "properties.wof:supersedes", supersedes_list)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to assign wof:supersedes to %d, %w", canonical_id, err)
+	}
+
+	return canonical, new_others, nil
+}
+
+// recordMediaIds returns the media ID, and any slide media IDs, derived from the WOF record 'body'. Records without
+// a perceptual or file hash (from which media IDs are derived) return an empty list.
+func recordMediaIds(body []byte) ([]string, error) {
+
+	media_ids := make([]string, 0)
+
+	// See notes about video hashes in DeriveMediaId
+
+	if !gjson.GetBytes(body, "properties.instagram:post.perceptual_hash").Exists() && !gjson.GetBytes(body, "properties.instagram:post.file_hash").Exists() {
+		return media_ids, nil
+	}
+
+	media_id, err := DeriveMediaId(body, "properties.instagram:post")
+
+	if err != nil {
+		return nil, err
+	}
+
+	media_ids = append(media_ids, media_id)
+
+	slide_ids, err := DeriveSlideMediaIds(body, "properties.instagram:post")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(media_ids, slide_ids...), nil
+}
+
+// recordHash returns the perceptual (or video perceptual) hash of the WOF record 'body' or an empty string.
+func recordHash(body []byte) string {
+
+	phash_rsp := gjson.GetBytes(body, "properties.instagram:post.perceptual_hash")
+
+	if !phash_rsp.Exists() {
+		phash_rsp = gjson.GetBytes(body, "properties.instagram:post.video_hash")
+	}
+
+	return phash_rsp.String()
+}
+
+// isSupersededOrDeprecated returns a boolean value indicating whether the WOF record 'body' has been superseded by
+// another record or deprecated.
+func isSupersededOrDeprecated(body []byte) bool {
+
+	if len(gjson.GetBytes(body, "properties.wof:superseded_by").Array()) > 0 {
+		return true
+	}
+
+	return gjson.GetBytes(body, "properties.edtf:deprecated").String() != ""
+}
@@ -0,0 +1,138 @@
+package publish
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestMergeRecords(t *testing.T) {
+
+	ctx := context.Background()
+
+	a := []byte(`{"type":"Feature","properties":{"wof:id":2,"mz:is_current":1,"instagram:post":{"media_id":"a.jpg","perceptual_hash":"p:b867679231ccc633","taken_at":"Nov 26, 2024 4:00 PM"}}}`)
+	b := []byte(`{"type":"Feature","properties":{"wof:id":1,"mz:is_current":1,"instagram:post":{"media_id":"b.jpg","perceptual_hash":"p:b867679231ccc632","taken_at":"Nov 26, 2024 4:00 PM"}}}`)
+
+	idx, err := ChooseCanonical([][]byte{a, b})
+
+	if err != nil {
+		t.Fatalf("Failed to choose canonical record, %v", err)
+	}
+
+	if idx != 1 {
+		t.Fatalf("Expected canonical record at offset 1, got %d", idx)
+	}
+
+	canonical, others, err := MergeRecords(ctx, b, [][]byte{a})
+
+	if err != nil {
+		t.Fatalf("Failed to merge records, %v", err)
+	}
+
+	a_media_id, err := DeriveMediaId(a, "properties.instagram:post")
+
+	if err != nil {
+		t.Fatalf("Failed to derive media ID, %v", err)
+	}
+
+	tests := map[string]string{
+		"properties.wof:supersedes":                   "[2]",
+		"properties." + HISTORICAL_MEDIA_IDS_PROPERTY: `["` + a_media_id + `"]`,
+		"properties." + HISTORICAL_PATHS_PROPERTY:     `["a.jpg"]`,
+		"properties." + HISTORICAL_HASHES_PROPERTY:    `["p:b867679231ccc633"]`,
+	}
+
+	for path, expected := range tests {
+
+		v := gjson.GetBytes(canonical, path).Raw
+
+		if v != expected {
+			t.Fatalf("Unexpected value for %s: %s", path, v)
+		}
+	}
+
+	if gjson.GetBytes(others[0], "properties.wof:superseded_by").Raw != "[1]" {
+		t.Fatalf("Unexpected wof:superseded_by: %s", gjson.GetBytes(others[0], "properties.wof:superseded_by").Raw)
+	}
+
+	if gjson.GetBytes(others[0], "properties.mz:is_current").Int() != 0 || !gjson.GetBytes(others[0], "properties.edtf:deprecated").Exists() {
+		t.Fatalf("Expected superseded record to be deprecated")
+	}
+
+	_, _, err = MergeRecords(ctx, canonical, others)
+
+	if err == nil {
+		t.Fatalf("Expected error merging a record that has already been superseded")
+	}
+
+	// A superseded (or deprecated) record can not be the canonical record
+
+	c := []byte(`{"type":"Feature","properties":{"wof:id":3,"mz:is_current":1,"instagram:post":{"media_id":"c.jpg","perceptual_hash":"p:b867679231ccc631","taken_at":"Nov 26, 2024 4:00 PM"}}}`)
+
+	_, _, err = MergeRecords(ctx, others[0], [][]byte{c})
+
+	if err == nil {
+		t.Fatalf("Expected error merging in to a record that has been superseded")
+	}
+
+	idx, err = ChooseCanonical([][]byte{c, others[0]})
+
+	if err != nil {
+		t.Fatalf("Failed to choose canonical record, %v", err)
+	}
+
+	if idx != 0 {
+		t.Fatalf("Expected canonical record at offset 0, got %d", idx)
+	}
+
+	_, err = ChooseCanonical([][]byte{others[0]})
+
+	if err == nil {
+		t.Fatalf("Expected error choosing canonical record from superseded records")
+	}
+
+	// The superseded record's media ID and path should point to the canonical record
+
+	repo := t.TempDir()
+
+	for fname, body := range map[string][]byte{"1.geojson": canonical, "2.geojson": others[0]} {
+
+		err := os.WriteFile(filepath.Join(repo, fname), body, 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write record, %v", err)
+		}
+	}
+
+	lookup := NewMemoryLookup()
+	audit := NewLookupAudit()
+
+	populate_opts := &PopulateLookupOptions{
+		Lookup:         lookup,
+		Audit:          audit,
+		IteratorURI:    "directory://",
+		IteratorSource: repo,
+	}
+
+	err = PopulateLookupWithOptions(ctx, populate_opts)
+
+	if err != nil {
+		t.Fatalf("Failed to populate lookup, %v", err)
+	}
+
+	for _, k := range []string{a_media_id, "a.jpg", "b.jpg"} {
+
+		id, ok := lookup.Load(ctx, k)
+
+		if !ok || id != 1 {
+			t.Fatalf("Expected %s to point to 1, got %d (%t)", k, id, ok)
+		}
+	}
+
+	if len(audit.Conflicts()) != 0 {
+		t.Fatalf("Unexpected conflicts: %d", len(audit.Conflicts()))
+	}
+}
@@ -0,0 +1,257 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// StageMetrics is a struct containing the time spent in an individual pipeline stage.
+type StageMetrics struct {
+	// Count is the number of times the stage was run.
+	Count int64 `json:"count"`
+	// Seconds is the total time spent in the stage, in seconds.
+	Seconds float64 `json:"seconds"`
+}
+
+// MetricsSummary is a struct summarizing a publish run.
+type MetricsSummary struct {
+	// Started is the Unix timestamp when the run started.
+	Started int64 `json:"started"`
+	// Seconds is the duration of the run, in seconds.
+	Seconds float64 `json:"seconds"`
+	// Total is the number of posts to be processed, if known.
+	Total int64 `json:"total"`
+	// Processed is the number of posts processed (including failures).
+	Processed int64 `json:"processed"`
+	// New is the number of new records created.
+	New int64 `json:"new"`
+	// Updated is the number of existing records updated.
+	Updated int64 `json:"updated"`
+	// Unchanged is the number of existing records that were already up to date.
+	Unchanged int64 `json:"unchanged"`
+	// Skipped is the number of posts skipped because of their status in the checkpoint journal.
+	Skipped int64 `json:"skipped"`
+	// PathMatches is the number of posts matched to existing records using the (fallback) media path.
+	PathMatches int64 `json:"path_matches"`
+	// FuzzyMatches is the number of posts matched to existing records using perceptual hashes.
+	FuzzyMatches int64 `json:"fuzzy_matches"`
+	// Failures is the number of posts that failed to publish.
+	Failures int64 `json:"failures"`
+	// Stages is the time spent in each pipeline stage.
+	Stages map[StageName]*StageMetrics `json:"stages"`
+}
+
+// Progress is a struct describing the progress of a publish run.
+type Progress struct {
+	// Processed is the number of posts processed (including failures).
+	Processed int64
+	// Total is the number of posts to be processed, if known.
+	Total int64
+	// Rate is the number of posts processed per second.
+	Rate float64
+	// ETA is the estimated time remaining. It is zero if Total is not known.
+	ETA time.Duration
+}
+
+// Metrics is a thread-safe collection of counts and timings for a publish run.
+type Metrics struct {
+	mu      *sync.RWMutex
+	started time.Time
+	summary *MetricsSummary
+}
+
+// NewMetrics returns a new `Metrics` instance whose start time is now.
+func NewMetrics() *Metrics {
+
+	now := time.Now()
+
+	m := &Metrics{
+		mu:      new(sync.RWMutex),
+		started: now,
+		summary: &MetricsSummary{
+			Started: now.Unix(),
+			Stages:  make(map[StageName]*StageMetrics),
+		},
+	}
+
+	return m
+}
+
+// AddTotal adds 'count' to the number of posts to be processed.
+func (m *Metrics) AddTotal(count int) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.summary.Total += int64(count)
+}
+
+// AddResult records the outcome of a published post.
+func (m *Metrics) AddResult(result *Result) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.summary.Processed += 1
+
+	switch result.Action {
+	case ACTION_NEW:
+		m.summary.New += 1
+	case ACTION_UPDATE:
+		m.summary.Updated += 1
+	case ACTION_UNCHANGED:
+		m.summary.Unchanged += 1
+	case ACTION_SKIPPED:
+		m.summary.Skipped += 1
+	}
+
+	switch result.MatchedBy {
+	case MATCH_PATH:
+		m.summary.PathMatches += 1
+	case MATCH_PERCEPTUAL_HASH:
+		m.summary.FuzzyMatches += 1
+	}
+}
+
+// AddFailure records a post that failed to publish.
+func (m *Metrics) AddFailure() {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.summary.Processed += 1
+	m.summary.Failures += 1
+}
+
+// AddStageDuration records 'd' as time spent in the stage named 'name'.
+func (m *Metrics) AddStageDuration(name StageName, d time.Duration) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.summary.Stages[name]
+
+	if !ok {
+		s = &StageMetrics{}
+		m.summary.Stages[name] = s
+	}
+
+	s.Count += 1
+	s.Seconds += d.Seconds()
+}
+
+// Progress returns the current progress of the run.
+func (m *Metrics) Progress() *Progress {
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p := &Progress{
+		Processed: m.summary.Processed,
+		Total:     m.summary.Total,
+	}
+
+	elapsed := time.Since(m.started).Seconds()
+
+	if elapsed > 0 {
+		p.Rate = float64(p.Processed) / elapsed
+	}
+
+	if p.Rate > 0 && p.Total > p.Processed {
+		remaining := float64(p.Total-p.Processed) / p.Rate
+		p.ETA = time.Duration(remaining * float64(time.Second))
+	}
+
+	return p
+}
+
+// LogProgress logs the progress of the run, using 'logger', every 'interval' until 'ctx' is cancelled.
+func (m *Metrics) LogProgress(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+
+			p := m.Progress()
+
+			logger.Info("Progress",
+				"processed", p.Processed,
+				"total", p.Total,
+				"rate", fmt.Sprintf("%.2f/s", p.Rate),
+				"eta", p.ETA.Round(time.Second).String())
+		}
+	}
+}
+
+// Summary returns a copy of the `MetricsSummary` for the run so far.
+func (m *Metrics) Summary() *MetricsSummary {
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := *m.summary
+	s.Seconds = time.Since(m.started).Seconds()
+	s.Stages = make(map[StageName]*StageMetrics)
+
+	for k, v := range m.summary.Stages {
+		st := *v
+		s.Stages[k] = &st
+	}
+
+	return &s
+}
+
+// WriteSummary writes tables of counts and the time spent per pipeline stage to 'wr'.
+func (m *Metrics) WriteSummary(wr io.Writer) error {
+
+	s := m.Summary()
+
+	tw := tabwriter.NewWriter(wr, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "METRIC\tVALUE\n")
+	fmt.Fprintf(tw, "processed\t%d\n", s.Processed)
+	fmt.Fprintf(tw, "new\t%d\n", s.New)
+	fmt.Fprintf(tw, "updated\t%d\n", s.Updated)
+	fmt.Fprintf(tw, "unchanged\t%d\n", s.Unchanged)
+	fmt.Fprintf(tw, "skipped\t%d\n", s.Skipped)
+	fmt.Fprintf(tw, "path matches\t%d\n", s.PathMatches)
+	fmt.Fprintf(tw, "fuzzy matches\t%d\n", s.FuzzyMatches)
+	fmt.Fprintf(tw, "failures\t%d\n", s.Failures)
+	fmt.Fprintf(tw, "seconds\t%.2f\n", s.Seconds)
+	fmt.Fprintf(tw, "\n")
+
+	names := make([]string, 0)
+
+	for k := range s.Stages {
+		names = append(names, string(k))
+	}
+
+	sort.Strings(names)
+
+	fmt.Fprintf(tw, "STAGE\tCOUNT\tSECONDS\tMEAN (ms)\n")
+
+	for _, n := range names {
+
+		st := s.Stages[StageName(n)]
+		mean := 0.0
+
+		if st.Count > 0 {
+			mean = st.Seconds / float64(st.Count) * 1000
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%.2f\t%.2f\n", n, st.Count, st.Seconds, mean)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,45 @@
+package publish
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+
+	m := NewMetrics()
+	m.AddTotal(4)
+
+	m.AddResult(&Result{Action: ACTION_NEW})
+	m.AddResult(&Result{Action: ACTION_UPDATE, MatchedBy: MATCH_PATH})
+	m.AddFailure()
+
+	m.AddStageDuration(STAGE_HASHING, 100*time.Millisecond)
+	m.AddStageDuration(STAGE_HASHING, 300*time.Millisecond)
+
+	s := m.Summary()
+
+	if s.Processed != 3 {
+		t.Fatalf("Unexpected processed count: %d", s.Processed)
+	}
+
+	if s.New != 1 || s.Updated != 1 || s.PathMatches != 1 || s.Failures != 1 {
+		t.Fatalf("Unexpected summary: %v", s)
+	}
+
+	st, ok := s.Stages[STAGE_HASHING]
+
+	if !ok {
+		t.Fatalf("Missing hashing stage metrics")
+	}
+
+	if st.Count != 2 || st.Seconds < 0.39 || st.Seconds > 0.41 {
+		t.Fatalf("Unexpected hashing stage metrics: %v", st)
+	}
+
+	p := m.Progress()
+
+	if p.Total != 4 || p.Processed != 3 {
+		t.Fatalf("Unexpected progress: %v", p)
+	}
+}
@@ -0,0 +1,380 @@
+// package migrate provides a registry of named, versioned migrations for records in the
+// sfomuseum-data-socialmedia-instagram repository and methods for applying them. Migrations are idempotent: the
+// version of each migration that changed a record is stored in its `instagram:migrations` property and a migration
+// is only applied to records where it has not already been applied at the same (or a later) version. Records that a
+// migration makes no changes to are left as-is, so that it can be run against them again. For example:
+//
+//	import (
+//		"context"
+//
+//		"github.com/sfomuseum/go-sfomuseum-instagram-publish/migrate"
+//		"github.com/tidwall/sjson"
+//	)
+//
+//	func init() {
+//
+//		migrate.RegisterMigration(&migrate.Migration{
+//			Name:        "example",
+//			Version:     1,
+//			Description: "Assign an example property.",
+//			Migrate: func(ctx context.Context, env *migrate.Environment, body []byte) ([]byte, error) {
+//				return sjson.SetBytes(body, "properties.instagram:example", true)
+//			},
+//		})
+//	}
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sfomuseum/go-sfomuseum-instagram-publish"
+	sfom_writer "github.com/sfomuseum/go-sfomuseum-writer/v3"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/iterator"
+	"github.com/whosonfirst/go-writer/v3"
+	"gocloud.dev/blob"
+)
+
+// MIGRATIONS_PROPERTY is the WOF property where the versions of the migrations applied to a record are stored,
+// keyed by migration name.
+const MIGRATIONS_PROPERTY string = "instagram:migrations"
+
+// ErrSkip is returned by a `MigrateFunc` to indicate that a migration can not be applied to a record (for example
+// because a media file is missing). The record is left unchanged and the migration's version is not recorded so it
+// will be retried the next time the migration is run.
+var ErrSkip = errors.New("Migration skipped")
+
+// Environment is a struct containing resources that migrations may need.
+type Environment struct {
+	// MediaBucket is an optional gocloud.dev/blob bucket containing SFO Museum Instagram media files.
+	MediaBucket *blob.Bucket
+	// MediaLimiter is an optional `publish.Limiter` instance used to limit reads from MediaBucket.
+	MediaLimiter *publish.Limiter
+	// Timezone is the timezone of the (wall-clock) "taken_at" datetime strings in records.
+	Timezone *time.Location
+	// FixDatesIds is an optional lookup of the WOF IDs of records whose "taken_at" datetime strings were rewritten
+	// by the fix-dates tool, which used a fixed (Pacific Standard Time) offset rather than Timezone.
+	FixDatesIds map[int64]bool
+}
+
+// MigrateFunc is a function which updates (and returns) the WOF record 'body'. It must be idempotent.
+type MigrateFunc func(ctx context.Context, env *Environment, body []byte) ([]byte, error)
+
+// Migration is a struct defining a named, versioned migration.
+type Migration struct {
+	// Name is the unique name of the migration.
+	Name string
+	// Version is the version of the migration. Increment it to re-apply a migration whose logic has changed.
+	Version int64
+	// Description is a short description of what the migration does.
+	Description string
+	// Migrate is the function which updates a record.
+	Migrate MigrateFunc
+}
+
+var migrations = new(sync.Map)
+
+// RegisterMigration adds 'm' to the registry of migrations. It returns an error if a migration with the same name
+// has already been registered.
+func RegisterMigration(m *Migration) error {
+
+	if m.Name == "" || m.Version < 1 || m.Migrate == nil {
+		return fmt.Errorf("Invalid migration '%s'", m.Name)
+	}
+
+	_, exists := migrations.LoadOrStore(m.Name, m)
+
+	if exists {
+		return fmt.Errorf("Migration '%s' has already been registered", m.Name)
+	}
+
+	return nil
+}
+
+// GetMigration returns the registered migration named 'name'.
+func GetMigration(name string) (*Migration, error) {
+
+	v, ok := migrations.Load(name)
+
+	if !ok {
+		return nil, fmt.Errorf("Migration '%s' not found", name)
+	}
+
+	return v.(*Migration), nil
+}
+
+// Migrations returns the list of registered migrations sorted by name.
+func Migrations() []*Migration {
+
+	list := make([]*Migration, 0)
+
+	migrations.Range(func(k interface{}, v interface{}) bool {
+		list = append(list, v.(*Migration))
+		return true
+	})
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// AppliedVersion returns the version of the migration named 'name' applied to the WOF record 'body', or zero
+// if it has not been applied.
+func AppliedVersion(body []byte, name string) int64 {
+	path := fmt.Sprintf("properties.%s.%s", MIGRATIONS_PROPERTY, gjsonEscape(name))
+	return gjson.GetBytes(body, path).Int()
+}
+
+// Apply applies 'm' to the WOF record 'body', unless it has already been applied at the same (or a later) version,
+// and records its version if it changed the record. It returns a boolean value indicating whether the migration
+// changed the record and the (updated) record. If the migration made no changes 'body' is returned as-is, without
+// the migration's version, so that records are never rewritten only to record that a migration has been run.
+func Apply(ctx context.Context, env *Environment, m *Migration, body []byte) (bool, []byte, error) {
+
+	if AppliedVersion(body, m.Name) >= m.Version {
+		return false, body, nil
+	}
+
+	new_body, err := m.Migrate(ctx, env, body)
+
+	if errors.Is(err, ErrSkip) {
+		return false, body, nil
+	}
+
+	if err != nil {
+		return false, nil, fmt.Errorf("Failed to apply migration '%s', %w", m.Name, err)
+	}
+
+	if bytes.Equal(new_body, body) {
+		return false, body, nil
+	}
+
+	path := fmt.Sprintf("properties.%s.%s", MIGRATIONS_PROPERTY, gjsonEscape(m.Name))
+
+	new_body, err = sjson.SetBytes(new_body, path, m.Version)
+
+	if err != nil {
+		return false, nil, fmt.Errorf("Failed to record version of migration '%s', %w", m.Name, err)
+	}
+
+	return true, new_body, nil
+}
+
+// RunOptions is a struct containing configuration options for the `Run` method.
+type RunOptions struct {
+	// Migrations is the list of migrations to apply, in order.
+	Migrations []*Migration
+	// Environment contains the resources that migrations may need.
+	Environment *Environment
+	// IteratorURI is a valid whosonfirst/go-whosonfirst-iterate/v2 URI.
+	IteratorURI string
+	// IteratorSource is the URI (or path) of the data repository to iterate.
+	IteratorSource string
+	// Writer is the `writer.Writer` instance used to write updated records. It may be nil if DryRun is true.
+	Writer writer.Writer
+	// DryRun is a boolean flag indicating that the changes each migration would make should be logged but not written.
+	DryRun bool
+}
+
+// Summary is a thread-safe collection of counts for a migration run.
+type Summary struct {
+	mu      *sync.RWMutex
+	checked int
+	written int
+	applied map[string]int
+}
+
+// NewSummary returns a new (empty) `Summary` instance.
+func NewSummary() *Summary {
+
+	s := &Summary{
+		mu:      new(sync.RWMutex),
+		applied: make(map[string]int),
+	}
+
+	return s
+}
+
+// Applied returns the number of records each migration was (or would be, in dry-run mode) applied to.
+func (s *Summary) Applied(name string) int {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.applied[name]
+}
+
+// Written returns the number of records that were (or would be, in dry-run mode) written.
+func (s *Summary) Written() int {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.written
+}
+
+// WriteSummary writes a table of the number of records each migration was applied to, and the number of records
+// checked and written, to 'wr'.
+func (s *Summary) WriteSummary(wr io.Writer) error {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0)
+
+	for k := range s.applied {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(wr, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "MIGRATION\tAPPLIED\n")
+
+	for _, n := range names {
+		fmt.Fprintf(tw, "%s\t%d\n", n, s.applied[n])
+	}
+
+	fmt.Fprintf(tw, "checked\t%d\n", s.checked)
+	fmt.Fprintf(tw, "written\t%d\n", s.written)
+
+	return tw.Flush()
+}
+
+// Run applies the migrations defined in 'opts' to every record in the data repository defined in 'opts', writing
+// the records that were changed (unless 'opts.DryRun' is true, in which case the property-level changes are logged).
+// Records that no migration changed are not written.
+func Run(ctx context.Context, opts *RunOptions) (*Summary, error) {
+
+	summary := NewSummary()
+
+	for _, m := range opts.Migrations {
+		summary.applied[m.Name] = 0
+	}
+
+	env := opts.Environment
+
+	if env == nil {
+		env = &Environment{}
+	}
+
+	iter_cb := func(ctx context.Context, path string, r io.ReadSeeker, args ...interface{}) error {
+
+		logger := slog.Default().With("path", path)
+
+		body, err := io.ReadAll(r)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read %s, %w", path, err)
+		}
+
+		new_body := body
+		applied := make([]string, 0)
+
+		for _, m := range opts.Migrations {
+
+			ok, b, err := Apply(ctx, env, m, new_body)
+
+			if err != nil {
+				logger.Error("Failed to apply migration", "migration", m.Name, "error", err)
+				return fmt.Errorf("Failed to migrate %s, %w", path, err)
+			}
+
+			if ok {
+				applied = append(applied, m.Name)
+			}
+
+			new_body = b
+		}
+
+		changed := len(applied) > 0
+
+		summary.mu.Lock()
+
+		summary.checked += 1
+
+		for _, n := range applied {
+			summary.applied[n] += 1
+		}
+
+		if changed {
+			summary.written += 1
+		}
+
+		summary.mu.Unlock()
+
+		if !changed {
+			return nil
+		}
+
+		if opts.DryRun {
+
+			changes, err := publish.DiffRecords(body, new_body)
+
+			if err != nil {
+				return fmt.Errorf("Failed to diff %s, %w", path, err)
+			}
+
+			for _, ch := range changes {
+				logger.Info("Property would change", "property", ch.Property, "old", ch.Old, "new", ch.New)
+			}
+
+			return nil
+		}
+
+		_, err = sfom_writer.WriteBytes(ctx, opts.Writer, new_body)
+
+		if err != nil {
+			logger.Error("Failed to write record", "error", err)
+			return fmt.Errorf("Failed to write %s, %w", path, err)
+		}
+
+		logger.Debug("Migrated record", "migrations", applied)
+		return nil
+	}
+
+	iter, err := iterator.NewIterator(ctx, opts.IteratorURI, iter_cb)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create iterator, %w", err)
+	}
+
+	err = iter.IterateURIs(ctx, opts.IteratorSource)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to iterate %s, %w", opts.IteratorSource, err)
+	}
+
+	return summary, nil
+}
+
+// gjsonEscape escapes characters in 'k' which have special meaning in gjson (and sjson) paths.
+func gjsonEscape(k string) string {
+
+	escaped := make([]rune, 0, len(k))
+
+	for _, r := range k {
+
+		switch r {
+		case '.', '*', '?', '|', '#', '@', '\\':
+			escaped = append(escaped, '\\')
+		}
+
+		escaped = append(escaped, r)
+	}
+
+	return string(escaped)
+}
@@ -0,0 +1,188 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+func TestApply(t *testing.T) {
+
+	ctx := context.Background()
+
+	calls := 0
+
+	m := &Migration{
+		Name:    "test.example",
+		Version: 2,
+		Migrate: func(ctx context.Context, env *Environment, body []byte) ([]byte, error) {
+			calls += 1
+			return sjson.SetBytes(body, "properties.example", true)
+		},
+	}
+
+	body := []byte(`{"type":"Feature","properties":{"instagram:migrations":{"test.example":1}}}`)
+
+	for i := 0; i < 2; i++ {
+
+		_, new_body, err := Apply(ctx, &Environment{}, m, body)
+
+		if err != nil {
+			t.Fatalf("Failed to apply migration, %v", err)
+		}
+
+		body = new_body
+	}
+
+	if calls != 1 {
+		t.Fatalf("Expected migration to be applied once, applied %d times", calls)
+	}
+
+	if AppliedVersion(body, "test.example") != 2 {
+		t.Fatalf("Unexpected applied version: %d", AppliedVersion(body, "test.example"))
+	}
+}
+
+func TestRun(t *testing.T) {
+
+	ctx := context.Background()
+
+	for _, name := range []string{PerceptualHashMigration.Name, TakenTimestampsMigration.Name} {
+
+		_, err := GetMigration(name)
+
+		if err != nil {
+			t.Fatalf("Failed to get migration, %v", err)
+		}
+	}
+
+	repo := t.TempDir()
+
+	body := []byte(`{"type":"Feature","properties":{"wof:id":1,"instagram:post":{"taken_at":"Jul 4, 2021 1:30 PM","perceptual_hash":"p:8040205408542205"}}}`)
+
+	err := os.WriteFile(filepath.Join(repo, "1.geojson"), body, 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write record, %v", err)
+	}
+
+	opts := &RunOptions{
+		Migrations:     []*Migration{PerceptualHashMigration, TakenTimestampsMigration},
+		IteratorURI:    "directory://",
+		IteratorSource: repo,
+		DryRun:         true,
+	}
+
+	summary, err := Run(ctx, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to run migrations, %v", err)
+	}
+
+	// The record already has a perceptual hash so that migration makes no changes
+
+	if summary.Applied(PerceptualHashMigration.Name) != 0 {
+		t.Fatalf("Unexpected summary for %s", PerceptualHashMigration.Name)
+	}
+
+	if summary.Applied(TakenTimestampsMigration.Name) != 1 {
+		t.Fatalf("Unexpected summary for %s", TakenTimestampsMigration.Name)
+	}
+
+	if summary.Written() != 1 {
+		t.Fatalf("Expected 1 record to be written, got %d", summary.Written())
+	}
+
+	ok, unchanged_body, err := Apply(ctx, &Environment{}, PerceptualHashMigration, body)
+
+	if err != nil {
+		t.Fatalf("Failed to apply migration, %v", err)
+	}
+
+	if ok {
+		t.Fatalf("Expected %s to make no changes", PerceptualHashMigration.Name)
+	}
+
+	if !bytes.Equal(unchanged_body, body) {
+		t.Fatalf("Expected record to be left unchanged (and the version of %s to not be recorded)", PerceptualHashMigration.Name)
+	}
+
+	// A record that no migration changes is not written
+
+	summary, err = Run(ctx, &RunOptions{
+		Migrations:     []*Migration{PerceptualHashMigration},
+		IteratorURI:    "directory://",
+		IteratorSource: repo,
+		DryRun:         true,
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to run migrations, %v", err)
+	}
+
+	if summary.Written() != 0 {
+		t.Fatalf("Expected no records to be written, got %d", summary.Written())
+	}
+
+	_, new_body, err := Apply(ctx, &Environment{}, TakenTimestampsMigration, body)
+
+	if err != nil {
+		t.Fatalf("Failed to apply migration, %v", err)
+	}
+
+	if gjson.GetBytes(new_body, "properties.edtf:inception").String() != "2021-07-04T13:30:00-07:00" {
+		t.Fatalf("Unexpected inception: %s", gjson.GetBytes(new_body, "properties.edtf:inception").String())
+	}
+}
+
+func TestTakenTimestampsFixDates(t *testing.T) {
+
+	ctx := context.Background()
+
+	// Taken at 13:30 Pacific Daylight Time and rewritten by fix-dates, using a fixed -0800 offset, as 12:30
+
+	body := []byte(`{"type":"Feature","properties":{"wof:id":1,"instagram:post":{"taken_at":"Jul 4, 2021 12:30 PM"}}}`)
+
+	env := &Environment{
+		FixDatesIds: map[int64]bool{1: true},
+	}
+
+	_, new_body, err := Apply(ctx, env, TakenTimestampsMigration, body)
+
+	if err != nil {
+		t.Fatalf("Failed to apply migration, %v", err)
+	}
+
+	if gjson.GetBytes(new_body, "properties.edtf:inception").String() != "2021-07-04T13:30:00-07:00" {
+		t.Fatalf("Unexpected inception: %s", gjson.GetBytes(new_body, "properties.edtf:inception").String())
+	}
+
+	if gjson.GetBytes(new_body, "properties.instagram:post.taken_at_utc").String() != "2021-07-04T20:30:00Z" {
+		t.Fatalf("Unexpected UTC time: %s", gjson.GetBytes(new_body, "properties.instagram:post.taken_at_utc").String())
+	}
+
+	if gjson.GetBytes(new_body, "properties.instagram:post.taken_at").String() != "Jul 4, 2021 12:30 PM" {
+		t.Fatalf("Expected taken_at to be left unchanged")
+	}
+
+	if gjson.GetBytes(new_body, "properties.instagram:post.creation_timestamp").Exists() {
+		t.Fatalf("Expected creation timestamp to not be assigned")
+	}
+
+	// Records not fixed by fix-dates are interpreted using the environment's timezone
+
+	_, new_body, err = Apply(ctx, &Environment{}, TakenTimestampsMigration, body)
+
+	if err != nil {
+		t.Fatalf("Failed to apply migration, %v", err)
+	}
+
+	if gjson.GetBytes(new_body, "properties.edtf:inception").String() != "2021-07-04T12:30:00-07:00" {
+		t.Fatalf("Unexpected inception: %s", gjson.GetBytes(new_body, "properties.edtf:inception").String())
+	}
+}
@@ -0,0 +1,80 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/sfomuseum/go-sfomuseum-instagram-publish"
+	"github.com/sfomuseum/go-sfomuseum-instagram/hash"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// PerceptualHashMigration assigns an `instagram:post.perceptual_hash` property, derived from the original ("_o.jpg")
+// image in the media bucket, to records which don't have one. It replaces the assign-hash tool.
+var PerceptualHashMigration = &Migration{
+	Name:        "perceptual_hash",
+	Version:     1,
+	Description: "Assign instagram:post.perceptual_hash properties, derived from the original image in the media bucket, to records that don't have one.",
+	Migrate:     migratePerceptualHash,
+}
+
+func init() {
+
+	err := RegisterMigration(PerceptualHashMigration)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func migratePerceptualHash(ctx context.Context, env *Environment, body []byte) ([]byte, error) {
+
+	if gjson.GetBytes(body, "properties.instagram:post.perceptual_hash").Exists() {
+		return body, nil
+	}
+
+	// Videos are hashed by publish itself
+
+	if gjson.GetBytes(body, "properties.instagram:post.media_type").String() == publish.MEDIA_TYPE_VIDEO {
+		return body, nil
+	}
+
+	if env.MediaBucket == nil {
+		return nil, fmt.Errorf("Migration requires a media bucket")
+	}
+
+	id_rsp := gjson.GetBytes(body, "properties.instagram:post.media_id")
+
+	if !id_rsp.Exists() {
+		return nil, fmt.Errorf("Record is missing instagram:post.media_id property")
+	}
+
+	im_path := publish.DerivativeKey(id_rsp.String(), publish.SIZE_ORIGINAL, "jpg")
+
+	release, err := env.MediaLimiter.Acquire(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	im_r, err := env.MediaBucket.NewReader(ctx, im_path, nil)
+
+	if err != nil {
+		slog.Warn("Failed to open original image, skipping", "key", im_path, "error", err)
+		return nil, ErrSkip
+	}
+
+	defer im_r.Close()
+
+	phash, err := hash.PerceptualHash(im_r)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate perceptual hash for %s, %w", im_path, err)
+	}
+
+	return sjson.SetBytes(body, "properties.instagram:post.perceptual_hash", phash)
+}
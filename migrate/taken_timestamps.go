@@ -0,0 +1,119 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sfomuseum/go-sfomuseum-instagram-publish"
+	"github.com/sfomuseum/go-sfomuseum-instagram/media"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// TakenTimestampsMigration (re)derives the `instagram:post.taken` timestamp, the `instagram:post.taken_at_local`,
+// `instagram:post.taken_at_utc` and `instagram:post.timezone` properties and the `wof:created`, `edtf:inception` and
+// `edtf:cessation` properties of records by interpreting their (wall-clock) "taken_at" datetime strings in the
+// environment's timezone (or `publish.DEFAULT_TIMEZONE`). It supersedes the fix-dates tool.
+//
+// The fix-dates tool rewrote "taken_at" using a fixed -0800 offset, even during daylight saving time, so the
+// "taken_at" strings of records it fixed that were taken in the summer are one hour behind local time. Records
+// listed in the environment's `FixDatesIds` lookup have their "taken_at" strings interpreted using that offset
+// instead. Their "taken_at" strings are not modified, so media IDs derived from them remain the same.
+var TakenTimestampsMigration = &Migration{
+	Name:        "taken_timestamps",
+	Version:     1,
+	Description: "Derive timezone-aware taken timestamps (and local and UTC datetime strings) from instagram:post.taken_at.",
+	Migrate:     migrateTakenTimestamps,
+}
+
+// fix_dates_zone is the fixed offset (Pacific Standard Time) used by the fix-dates tool.
+var fix_dates_zone = time.FixedZone("America/Los_Angeles", -8*60*60)
+
+func init() {
+
+	err := RegisterMigration(TakenTimestampsMigration)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func migrateTakenTimestamps(ctx context.Context, env *Environment, body []byte) ([]byte, error) {
+
+	loc := env.Timezone
+
+	if loc == nil {
+
+		l, err := time.LoadLocation(publish.DEFAULT_TIMEZONE)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load default timezone, %w", err)
+		}
+
+		loc = l
+	}
+
+	post_rsp := gjson.GetBytes(body, "properties.instagram:post")
+
+	if !post_rsp.Exists() {
+		return nil, fmt.Errorf("Record is missing instagram:post property")
+	}
+
+	post := []byte(post_rsp.Raw)
+
+	wof_id := gjson.GetBytes(body, "properties.wof:id").Int()
+
+	if env.FixDatesIds[wof_id] {
+
+		taken_rsp := gjson.GetBytes(post, "taken_at")
+
+		if !taken_rsp.Exists() {
+			return nil, fmt.Errorf("Record is missing instagram:post.taken_at property")
+		}
+
+		wall, err := media.ParseTime(taken_rsp.String())
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse taken_at (%s), %w", taken_rsp.String(), err)
+		}
+
+		t := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, fix_dates_zone)
+
+		// The creation timestamp takes precedence over taken_at (see publish.AppendTakenTimestamps) and is
+		// only used to derive the properties below; it is not assigned to the record.
+
+		post, err = sjson.SetBytes(post, "creation_timestamp", t.Unix())
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to assign creation timestamp, %w", err)
+		}
+	}
+
+	post, err := publish.AppendTakenTimestamps(ctx, post, loc)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive taken timestamps, %w", err)
+	}
+
+	updates := map[string]interface{}{
+		"properties.instagram:post.taken":          gjson.GetBytes(post, "taken").Int(),
+		"properties.instagram:post.taken_at_local": gjson.GetBytes(post, "taken_at_local").String(),
+		"properties.instagram:post.taken_at_utc":   gjson.GetBytes(post, "taken_at_utc").String(),
+		"properties.instagram:post.timezone":       gjson.GetBytes(post, "timezone").String(),
+		"properties.wof:created":                   gjson.GetBytes(post, "taken").Int(),
+		"properties.edtf:inception":                gjson.GetBytes(post, "taken_at_local").String(),
+		"properties.edtf:cessation":                gjson.GetBytes(post, "taken_at_local").String(),
+	}
+
+	for k, v := range updates {
+
+		body, err = sjson.SetBytes(body, k, v)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to assign %s, %w", k, err)
+		}
+	}
+
+	return body, nil
+}
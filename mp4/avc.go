@@ -0,0 +1,91 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// annexb_start_code is the start code used to delimit NAL units in an H.264 Annex B byte stream.
+var annexb_start_code = []byte{0x00, 0x00, 0x00, 0x01}
+
+// IsAVC returns a boolean value indicating whether the track is encoded using H.264 (AVC).
+func (t *Track) IsAVC() bool {
+	return t.Codec == "avc1" || t.Codec == "avc3"
+}
+
+// AnnexB converts 'sample', a length-prefixed H.264 sample read from the track, in to an Annex B byte stream
+// prefixed with the track's sequence and picture parameter sets. The result can be decoded on its own (provided
+// 'sample' is a keyframe) by most H.264 decoders.
+func (t *Track) AnnexB(sample []byte) ([]byte, error) {
+
+	if !t.IsAVC() {
+		return nil, fmt.Errorf("Track is not H.264 encoded (%s)", t.Codec)
+	}
+
+	// See ISO/IEC 14496-15 section 5.3.3.1 (AVCDecoderConfigurationRecord)
+
+	cfg := t.Config
+
+	if len(cfg) < 7 {
+		return nil, fmt.Errorf("Missing or truncated avcC box")
+	}
+
+	length_size := int(cfg[4]&0x03) + 1
+
+	out := make([]byte, 0, len(sample)+len(cfg)+32)
+
+	offset := 5
+
+	for _, mask := range []byte{0x1f, 0xff} {
+
+		if offset >= len(cfg) {
+			return nil, fmt.Errorf("Truncated avcC box")
+		}
+
+		count := int(cfg[offset] & mask)
+		offset += 1
+
+		for i := 0; i < count; i++ {
+
+			if offset+2 > len(cfg) {
+				return nil, fmt.Errorf("Truncated avcC box")
+			}
+
+			sz := int(binary.BigEndian.Uint16(cfg[offset:]))
+			offset += 2
+
+			if offset+sz > len(cfg) {
+				return nil, fmt.Errorf("Truncated avcC box")
+			}
+
+			out = append(out, annexb_start_code...)
+			out = append(out, cfg[offset:offset+sz]...)
+			offset += sz
+		}
+	}
+
+	for i := 0; i < len(sample); {
+
+		if i+length_size > len(sample) {
+			return nil, fmt.Errorf("Truncated NAL unit length at offset %d", i)
+		}
+
+		sz := 0
+
+		for j := 0; j < length_size; j++ {
+			sz = sz<<8 | int(sample[i+j])
+		}
+
+		i += length_size
+
+		if sz > len(sample)-i {
+			return nil, fmt.Errorf("NAL unit at offset %d exceeds sample size", i)
+		}
+
+		out = append(out, annexb_start_code...)
+		out = append(out, sample[i:i+sz]...)
+		i += sz
+	}
+
+	return out, nil
+}
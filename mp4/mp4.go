@@ -0,0 +1,810 @@
+// package mp4 provides a minimal, pure-Go parser for ISO base media files (MP4 and QuickTime) sufficient to locate,
+// select and read the keyframes of a video track. It does not decode video frames. For example:
+//
+//	mov, err := mp4.Parse(fh)
+//	track, err := mov.VideoTrack()
+//
+//	for _, s := range mp4.SelectKeyframes(track, 3) {
+//		frame, err := track.ReadSample(fh, s)
+//	}
+//
+// Fragmented MP4 files are not supported.
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// HANDLER_VIDEO is the handler type for video tracks.
+const HANDLER_VIDEO string = "vide"
+
+// max_moov_size is the maximum size of a "moov" box that will be read in to memory.
+const max_moov_size int64 = 64 * 1024 * 1024
+
+// ErrNoVideoTrack is returned by `Movie.VideoTrack` when a file does not contain a video track.
+var ErrNoVideoTrack = errors.New("No video track")
+
+// ErrFragmented is returned by `Parse` for fragmented MP4 files, which are not supported.
+var ErrFragmented = errors.New("Fragmented MP4 files are not supported")
+
+// Movie is a struct describing the tracks in an MP4 (or QuickTime) file.
+type Movie struct {
+	// Timescale is the number of time units per second for Duration.
+	Timescale uint32
+	// Duration is the duration of the movie, in Timescale units.
+	Duration uint64
+	// Tracks is the list of tracks in the movie.
+	Tracks []*Track
+}
+
+// Track is a struct describing an individual track in an MP4 (or QuickTime) file.
+type Track struct {
+	// ID is the track ID.
+	ID uint32
+	// Handler is the handler type of the track, for example "vide" or "soun".
+	Handler string
+	// Codec is the four-character code of the track's (first) sample entry, for example "avc1" or "hvc1".
+	Codec string
+	// Width is the width of the track, in pixels, as defined by its sample entry.
+	Width uint16
+	// Height is the height of the track, in pixels, as defined by its sample entry.
+	Height uint16
+	// Timescale is the number of time units per second for Duration and each sample's Time.
+	Timescale uint32
+	// Duration is the duration of the track, in Timescale units.
+	Duration uint64
+	// Config is the body of the codec configuration box ("avcC" or "hvcC") of the track's sample entry, if present.
+	Config []byte
+	// Samples is the ordered list of samples in the track.
+	Samples []*Sample
+}
+
+// Sample is a struct describing an individual sample (frame) in a track.
+type Sample struct {
+	// Number is the (1-based) sample number.
+	Number uint32
+	// Offset is the absolute offset of the sample in the file.
+	Offset int64
+	// Size is the size of the sample, in bytes.
+	Size uint32
+	// Time is the decoding time of the sample, in track Timescale units.
+	Time uint64
+	// Sync is a boolean value indicating whether the sample is a sync sample (a keyframe).
+	Sync bool
+}
+
+// Parse parses the boxes in 'r' and returns a `Movie` instance describing its tracks. Only the "moov" box is
+// read in to memory; media data is skipped.
+func Parse(r io.ReadSeeker) (*Movie, error) {
+
+	var moov []byte
+	fragmented := false
+
+	for {
+
+		box_type, size, header_size, err := readBoxHeader(r)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read box header, %w", err)
+		}
+
+		payload_size := size - header_size
+
+		switch box_type {
+		case "moov":
+
+			if size == 0 {
+
+				moov, err = io.ReadAll(io.LimitReader(r, max_moov_size))
+
+				if err != nil {
+					return nil, fmt.Errorf("Failed to read moov box, %w", err)
+				}
+
+				break
+			}
+
+			if payload_size > max_moov_size {
+				return nil, fmt.Errorf("moov box exceeds maximum size (%d bytes)", payload_size)
+			}
+
+			moov = make([]byte, payload_size)
+
+			_, err := io.ReadFull(r, moov)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read moov box, %w", err)
+			}
+
+			continue
+
+		case "moof":
+			fragmented = true
+		}
+
+		if size == 0 {
+			break
+		}
+
+		_, err = r.Seek(payload_size, io.SeekCurrent)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to skip %s box, %w", box_type, err)
+		}
+	}
+
+	if moov == nil {
+		return nil, fmt.Errorf("Missing moov box")
+	}
+
+	mov, err := parseMoov(moov)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if fragmented {
+
+		for _, t := range mov.Tracks {
+
+			if len(t.Samples) == 0 {
+				return nil, ErrFragmented
+			}
+		}
+	}
+
+	return mov, nil
+}
+
+// VideoTrack returns the first video track in the movie.
+func (mov *Movie) VideoTrack() (*Track, error) {
+
+	for _, t := range mov.Tracks {
+
+		if t.Handler == HANDLER_VIDEO {
+			return t, nil
+		}
+	}
+
+	return nil, ErrNoVideoTrack
+}
+
+// Keyframes returns the list of sync samples (keyframes) in the track.
+func (t *Track) Keyframes() []*Sample {
+
+	keyframes := make([]*Sample, 0)
+
+	for _, s := range t.Samples {
+
+		if s.Sync {
+			keyframes = append(keyframes, s)
+		}
+	}
+
+	return keyframes
+}
+
+// ReadSample reads the (raw, undecoded) contents of sample 's' from 'r'.
+func (t *Track) ReadSample(r io.ReaderAt, s *Sample) ([]byte, error) {
+
+	buf := make([]byte, s.Size)
+
+	_, err := r.ReadAt(buf, s.Offset)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read sample %d, %w", s.Number, err)
+	}
+
+	return buf, nil
+}
+
+// SelectKeyframes returns up to 'count' keyframes from 't', evenly distributed across the duration of the track:
+// the first keyframe followed by the keyframes closest to each subsequent 1/count interval. Keyframes are only
+// returned once, so fewer than 'count' keyframes may be returned for short videos.
+func SelectKeyframes(t *Track, count int) []*Sample {
+
+	keyframes := t.Keyframes()
+	selected := make([]*Sample, 0)
+
+	if len(keyframes) == 0 || count < 1 {
+		return selected
+	}
+
+	duration := t.Duration
+
+	if duration == 0 {
+		last := t.Samples[len(t.Samples)-1]
+		duration = last.Time
+	}
+
+	seen := make(map[uint32]bool)
+
+	for i := 0; i < count; i++ {
+
+		target := duration * uint64(i) / uint64(count)
+
+		var closest *Sample
+		var closest_delta uint64
+
+		for _, s := range keyframes {
+
+			delta := s.Time - target
+
+			if s.Time < target {
+				delta = target - s.Time
+			}
+
+			if closest == nil || delta < closest_delta {
+				closest = s
+				closest_delta = delta
+			}
+		}
+
+		if seen[closest.Number] {
+			continue
+		}
+
+		seen[closest.Number] = true
+		selected = append(selected, closest)
+	}
+
+	return selected
+}
+
+func readBoxHeader(r io.Reader) (string, int64, int64, error) {
+
+	var hdr [8]byte
+
+	_, err := io.ReadFull(r, hdr[:])
+
+	if err != nil {
+
+		if err == io.ErrUnexpectedEOF {
+			return "", 0, 0, fmt.Errorf("Truncated box header")
+		}
+
+		return "", 0, 0, err
+	}
+
+	size := int64(binary.BigEndian.Uint32(hdr[0:4]))
+	box_type := string(hdr[4:8])
+	header_size := int64(8)
+
+	if size == 1 {
+
+		var ext [8]byte
+
+		_, err := io.ReadFull(r, ext[:])
+
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("Failed to read extended size for %s box, %w", box_type, err)
+		}
+
+		size = int64(binary.BigEndian.Uint64(ext[:]))
+		header_size = 16
+	}
+
+	if size != 0 && size < header_size {
+		return "", 0, 0, fmt.Errorf("Invalid size (%d) for %s box", size, box_type)
+	}
+
+	return box_type, size, header_size, nil
+}
+
+// box is an in-memory ISO base media box.
+type box struct {
+	Type string
+	Body []byte
+}
+
+// readBoxes returns the list of (child) boxes contained in 'body'.
+func readBoxes(body []byte) ([]*box, error) {
+
+	boxes := make([]*box, 0)
+	r := bytes.NewReader(body)
+
+	for r.Len() > 0 {
+
+		box_type, size, header_size, err := readBoxHeader(r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		payload_size := size - header_size
+
+		if size == 0 {
+			payload_size = int64(r.Len())
+		}
+
+		if payload_size > int64(r.Len()) {
+			return nil, fmt.Errorf("Truncated %s box", box_type)
+		}
+
+		payload := make([]byte, payload_size)
+		r.Read(payload)
+
+		boxes = append(boxes, &box{Type: box_type, Body: payload})
+	}
+
+	return boxes, nil
+}
+
+// findBox returns the first box of type 'box_type' in 'boxes'.
+func findBox(boxes []*box, box_type string) *box {
+
+	for _, b := range boxes {
+
+		if b.Type == box_type {
+			return b
+		}
+	}
+
+	return nil
+}
+
+func parseMoov(body []byte) (*Movie, error) {
+
+	boxes, err := readBoxes(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read moov box, %w", err)
+	}
+
+	mov := &Movie{
+		Tracks: make([]*Track, 0),
+	}
+
+	mvhd := findBox(boxes, "mvhd")
+
+	if mvhd != nil {
+
+		timescale, duration, err := parseTimescaleAndDuration(mvhd.Body)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse mvhd box, %w", err)
+		}
+
+		mov.Timescale = timescale
+		mov.Duration = duration
+	}
+
+	for _, b := range boxes {
+
+		if b.Type != "trak" {
+			continue
+		}
+
+		t, err := parseTrak(b.Body)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse track, %w", err)
+		}
+
+		mov.Tracks = append(mov.Tracks, t)
+	}
+
+	return mov, nil
+}
+
+// parseTimescaleAndDuration parses the timescale and duration of a "mvhd" or "mdhd" box, which share the same layout.
+func parseTimescaleAndDuration(body []byte) (uint32, uint64, error) {
+
+	if len(body) < 4 {
+		return 0, 0, fmt.Errorf("Truncated box")
+	}
+
+	// Version 1 boxes use 64-bit creation and modification times and durations
+
+	if body[0] == 1 {
+
+		if len(body) < 32 {
+			return 0, 0, fmt.Errorf("Truncated box")
+		}
+
+		timescale := binary.BigEndian.Uint32(body[20:24])
+		duration := binary.BigEndian.Uint64(body[24:32])
+		return timescale, duration, nil
+	}
+
+	if len(body) < 20 {
+		return 0, 0, fmt.Errorf("Truncated box")
+	}
+
+	timescale := binary.BigEndian.Uint32(body[12:16])
+	duration := uint64(binary.BigEndian.Uint32(body[16:20]))
+	return timescale, duration, nil
+}
+
+func parseTrak(body []byte) (*Track, error) {
+
+	boxes, err := readBoxes(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Track{
+		Samples: make([]*Sample, 0),
+	}
+
+	tkhd := findBox(boxes, "tkhd")
+
+	if tkhd != nil && len(tkhd.Body) >= 24 {
+
+		id_offset := 12
+
+		if tkhd.Body[0] == 1 {
+			id_offset = 20
+		}
+
+		t.ID = binary.BigEndian.Uint32(tkhd.Body[id_offset:])
+	}
+
+	mdia := findBox(boxes, "mdia")
+
+	if mdia == nil {
+		return nil, fmt.Errorf("Missing mdia box")
+	}
+
+	mdia_boxes, err := readBoxes(mdia.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mdhd := findBox(mdia_boxes, "mdhd")
+
+	if mdhd != nil {
+
+		timescale, duration, err := parseTimescaleAndDuration(mdhd.Body)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse mdhd box, %w", err)
+		}
+
+		t.Timescale = timescale
+		t.Duration = duration
+	}
+
+	hdlr := findBox(mdia_boxes, "hdlr")
+
+	if hdlr != nil && len(hdlr.Body) >= 12 {
+		t.Handler = string(hdlr.Body[8:12])
+	}
+
+	minf := findBox(mdia_boxes, "minf")
+
+	if minf == nil {
+		return nil, fmt.Errorf("Missing minf box")
+	}
+
+	minf_boxes, err := readBoxes(minf.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stbl := findBox(minf_boxes, "stbl")
+
+	if stbl == nil {
+		return nil, fmt.Errorf("Missing stbl box")
+	}
+
+	err = parseStbl(t, stbl.Body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse sample table, %w", err)
+	}
+
+	return t, nil
+}
+
+func parseStbl(t *Track, body []byte) error {
+
+	boxes, err := readBoxes(body)
+
+	if err != nil {
+		return err
+	}
+
+	stsd := findBox(boxes, "stsd")
+
+	if stsd != nil {
+
+		err := parseStsd(t, stsd.Body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to parse stsd box, %w", err)
+		}
+	}
+
+	stsz := findBox(boxes, "stsz")
+
+	if stsz == nil {
+		// Fragmented files have empty sample tables
+		return nil
+	}
+
+	sizes, err := parseStsz(stsz.Body)
+
+	if err != nil {
+		return fmt.Errorf("Failed to parse stsz box, %w", err)
+	}
+
+	if len(sizes) == 0 {
+		return nil
+	}
+
+	var chunk_offsets []int64
+
+	stco := findBox(boxes, "stco")
+	co64 := findBox(boxes, "co64")
+
+	switch {
+	case stco != nil:
+		chunk_offsets, err = parseChunkOffsets(stco.Body, 4)
+	case co64 != nil:
+		chunk_offsets, err = parseChunkOffsets(co64.Body, 8)
+	default:
+		err = fmt.Errorf("Missing chunk offsets")
+	}
+
+	if err != nil {
+		return fmt.Errorf("Failed to parse chunk offsets, %w", err)
+	}
+
+	stsc := findBox(boxes, "stsc")
+
+	if stsc == nil {
+		return fmt.Errorf("Missing stsc box")
+	}
+
+	stsc_entries, err := parseTable(stsc.Body, 12)
+
+	if err != nil {
+		return fmt.Errorf("Failed to parse stsc box, %w", err)
+	}
+
+	stts := findBox(boxes, "stts")
+
+	if stts == nil {
+		return fmt.Errorf("Missing stts box")
+	}
+
+	stts_entries, err := parseTable(stts.Body, 8)
+
+	if err != nil {
+		return fmt.Errorf("Failed to parse stts box, %w", err)
+	}
+
+	// No stss box means every sample is a sync sample
+
+	var sync_samples map[uint32]bool
+
+	stss := findBox(boxes, "stss")
+
+	if stss != nil {
+
+		stss_entries, err := parseTable(stss.Body, 4)
+
+		if err != nil {
+			return fmt.Errorf("Failed to parse stss box, %w", err)
+		}
+
+		sync_samples = make(map[uint32]bool)
+
+		for _, e := range stss_entries {
+			sync_samples[binary.BigEndian.Uint32(e)] = true
+		}
+	}
+
+	samples := make([]*Sample, len(sizes))
+
+	for i, sz := range sizes {
+
+		number := uint32(i + 1)
+
+		samples[i] = &Sample{
+			Number: number,
+			Size:   sz,
+			Sync:   sync_samples == nil || sync_samples[number],
+		}
+	}
+
+	// Decoding times
+
+	idx := 0
+	var time uint64
+
+	for _, e := range stts_entries {
+
+		count := binary.BigEndian.Uint32(e[0:4])
+		delta := binary.BigEndian.Uint32(e[4:8])
+
+		for j := uint32(0); j < count && idx < len(samples); j++ {
+			samples[idx].Time = time
+			time += uint64(delta)
+			idx++
+		}
+	}
+
+	// Offsets
+
+	idx = 0
+
+	for i, e := range stsc_entries {
+
+		first_chunk := binary.BigEndian.Uint32(e[0:4])
+		per_chunk := binary.BigEndian.Uint32(e[4:8])
+
+		last_chunk := uint32(len(chunk_offsets))
+
+		if i+1 < len(stsc_entries) {
+			last_chunk = binary.BigEndian.Uint32(stsc_entries[i+1][0:4]) - 1
+		}
+
+		if first_chunk < 1 || last_chunk > uint32(len(chunk_offsets)) {
+			return fmt.Errorf("Invalid chunk range %d-%d", first_chunk, last_chunk)
+		}
+
+		for c := first_chunk; c <= last_chunk; c++ {
+
+			offset := chunk_offsets[c-1]
+
+			for j := uint32(0); j < per_chunk && idx < len(samples); j++ {
+				samples[idx].Offset = offset
+				offset += int64(samples[idx].Size)
+				idx++
+			}
+		}
+	}
+
+	if idx != len(samples) {
+		return fmt.Errorf("Sample to chunk table describes %d samples but there are %d", idx, len(samples))
+	}
+
+	t.Samples = samples
+	return nil
+}
+
+func parseStsd(t *Track, body []byte) error {
+
+	if len(body) < 8 {
+		return fmt.Errorf("Truncated box")
+	}
+
+	entries, err := readBoxes(body[8:])
+
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	entry := entries[0]
+	t.Codec = entry.Type
+
+	if t.Handler != HANDLER_VIDEO {
+		return nil
+	}
+
+	// A VisualSampleEntry is 78 bytes (following its header) followed by child boxes
+
+	if len(entry.Body) < 78 {
+		return fmt.Errorf("Truncated %s sample entry", entry.Type)
+	}
+
+	t.Width = binary.BigEndian.Uint16(entry.Body[24:26])
+	t.Height = binary.BigEndian.Uint16(entry.Body[26:28])
+
+	children, err := readBoxes(entry.Body[78:])
+
+	if err != nil {
+		// Some encoders write trailing garbage after the sample entry; the configuration is optional
+		return nil
+	}
+
+	for _, b := range children {
+
+		if b.Type == "avcC" || b.Type == "hvcC" {
+			t.Config = b.Body
+			break
+		}
+	}
+
+	return nil
+}
+
+func parseStsz(body []byte) ([]uint32, error) {
+
+	if len(body) < 12 {
+		return nil, fmt.Errorf("Truncated box")
+	}
+
+	sample_size := binary.BigEndian.Uint32(body[4:8])
+	count := binary.BigEndian.Uint32(body[8:12])
+
+	if sample_size != 0 {
+
+		// Guard against absurd counts in corrupt files
+		if count > 1<<24 {
+			return nil, fmt.Errorf("Invalid sample count (%d)", count)
+		}
+
+		sizes := make([]uint32, count)
+
+		for i := range sizes {
+			sizes[i] = sample_size
+		}
+
+		return sizes, nil
+	}
+
+	if uint64(len(body)-12) < uint64(count)*4 {
+		return nil, fmt.Errorf("Truncated box")
+	}
+
+	sizes := make([]uint32, count)
+
+	for i := range sizes {
+		sizes[i] = binary.BigEndian.Uint32(body[12+i*4:])
+	}
+
+	return sizes, nil
+}
+
+func parseChunkOffsets(body []byte, width int) ([]int64, error) {
+
+	entries, err := parseTable(body, width)
+
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make([]int64, len(entries))
+
+	for i, e := range entries {
+
+		if width == 8 {
+			offsets[i] = int64(binary.BigEndian.Uint64(e))
+		} else {
+			offsets[i] = int64(binary.BigEndian.Uint32(e))
+		}
+	}
+
+	return offsets, nil
+}
+
+// parseTable parses a "full" box consisting of a version and flags, an entry count and a list of fixed-width entries.
+func parseTable(body []byte, width int) ([][]byte, error) {
+
+	if len(body) < 8 {
+		return nil, fmt.Errorf("Truncated box")
+	}
+
+	count := binary.BigEndian.Uint32(body[4:8])
+
+	if uint64(len(body)-8) < uint64(count)*uint64(width) {
+		return nil, fmt.Errorf("Truncated box")
+	}
+
+	entries := make([][]byte, count)
+
+	for i := range entries {
+		offset := 8 + i*width
+		entries[i] = body[offset : offset+width]
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,171 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func testBox(box_type string, children ...[]byte) []byte {
+
+	body := bytes.Join(children, nil)
+
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b[0:4], uint32(8+len(body)))
+	copy(b[4:8], box_type)
+
+	return append(b, body...)
+}
+
+func testUint32s(values ...uint32) []byte {
+
+	b := make([]byte, 4*len(values))
+
+	for i, v := range values {
+		binary.BigEndian.PutUint32(b[i*4:], v)
+	}
+
+	return b
+}
+
+// testMovie returns a movie with a single 4-sample H.264 video track, whose first and third samples are keyframes,
+// stored in a single chunk.
+func testMovie(t *testing.T) ([]byte, [][]byte) {
+
+	samples := [][]byte{
+		{0, 0, 0, 2, 0x65, 0x01},
+		{0, 0, 0, 1, 0x41},
+		{0, 0, 0, 3, 0x65, 0x02, 0x03},
+		{0, 0, 0, 1, 0x41},
+	}
+
+	ftyp := testBox("ftyp", []byte("isom"), testUint32s(0x200))
+	mdat := testBox("mdat", bytes.Join(samples, nil))
+
+	chunk_offset := uint32(len(ftyp) + 8)
+
+	// avcC: version, profile, compatibility, level, length size (4), 1 SPS, 1 PPS
+	avcc := []byte{1, 0x42, 0x00, 0x1e, 0xff, 0xe1, 0, 2, 0x67, 0xaa, 1, 0, 1, 0x68}
+
+	entry := make([]byte, 78)
+	binary.BigEndian.PutUint16(entry[24:26], 640)
+	binary.BigEndian.PutUint16(entry[26:28], 480)
+
+	sizes := make([]uint32, 0)
+
+	for _, s := range samples {
+		sizes = append(sizes, uint32(len(s)))
+	}
+
+	stbl := testBox("stbl",
+		testBox("stsd", testUint32s(0, 1), testBox("avc1", entry, testBox("avcC", avcc))),
+		testBox("stts", testUint32s(0, 1, 4, 1000)),
+		testBox("stss", testUint32s(0, 2, 1, 3)),
+		testBox("stsc", testUint32s(0, 1, 1, 4, 1)),
+		testBox("stsz", testUint32s(0, 0, 4), testUint32s(sizes...)),
+		testBox("stco", testUint32s(0, 1, chunk_offset)),
+	)
+
+	trak := testBox("trak",
+		testBox("tkhd", testUint32s(0, 0, 0, 7, 0, 0)),
+		testBox("mdia",
+			testBox("mdhd", testUint32s(0, 0, 0, 1000, 4000, 0)),
+			testBox("hdlr", testUint32s(0, 0), []byte(HANDLER_VIDEO), testUint32s(0, 0, 0)),
+			testBox("minf", stbl),
+		),
+	)
+
+	moov := testBox("moov", testBox("mvhd", testUint32s(0, 0, 0, 1000, 4000)), trak)
+
+	return bytes.Join([][]byte{ftyp, mdat, moov}, nil), samples
+}
+
+func TestParse(t *testing.T) {
+
+	body, samples := testMovie(t)
+	r := bytes.NewReader(body)
+
+	mov, err := Parse(r)
+
+	if err != nil {
+		t.Fatalf("Failed to parse movie, %v", err)
+	}
+
+	if mov.Timescale != 1000 || mov.Duration != 4000 {
+		t.Fatalf("Unexpected timescale (%d) or duration (%d)", mov.Timescale, mov.Duration)
+	}
+
+	track, err := mov.VideoTrack()
+
+	if err != nil {
+		t.Fatalf("Failed to derive video track, %v", err)
+	}
+
+	if track.ID != 7 || track.Codec != "avc1" || track.Width != 640 || track.Height != 480 {
+		t.Fatalf("Unexpected track %d (%s) %dx%d", track.ID, track.Codec, track.Width, track.Height)
+	}
+
+	if len(track.Samples) != len(samples) {
+		t.Fatalf("Unexpected number of samples: %d", len(track.Samples))
+	}
+
+	keyframes := track.Keyframes()
+
+	if len(keyframes) != 2 || keyframes[0].Number != 1 || keyframes[1].Number != 3 {
+		t.Fatalf("Unexpected keyframes")
+	}
+
+	for i, s := range track.Samples {
+
+		if s.Time != uint64(i*1000) {
+			t.Fatalf("Unexpected time for sample %d: %d", s.Number, s.Time)
+		}
+
+		sample, err := track.ReadSample(r, s)
+
+		if err != nil {
+			t.Fatalf("Failed to read sample %d, %v", s.Number, err)
+		}
+
+		if !bytes.Equal(sample, samples[i]) {
+			t.Fatalf("Unexpected contents for sample %d", s.Number)
+		}
+	}
+
+	stream, err := track.AnnexB(samples[2])
+
+	if err != nil {
+		t.Fatalf("Failed to derive Annex B stream, %v", err)
+	}
+
+	expected := []byte{0, 0, 0, 1, 0x67, 0xaa, 0, 0, 0, 1, 0x68, 0, 0, 0, 1, 0x65, 0x02, 0x03}
+
+	if !bytes.Equal(stream, expected) {
+		t.Fatalf("Unexpected Annex B stream: %x", stream)
+	}
+}
+
+func TestSelectKeyframes(t *testing.T) {
+
+	body, _ := testMovie(t)
+
+	mov, err := Parse(bytes.NewReader(body))
+
+	if err != nil {
+		t.Fatalf("Failed to parse movie, %v", err)
+	}
+
+	track, err := mov.VideoTrack()
+
+	if err != nil {
+		t.Fatalf("Failed to derive video track, %v", err)
+	}
+
+	// Targets are 0, 1333 and 2666 which map to samples 1, 1 (again) and 3
+
+	selected := SelectKeyframes(track, 3)
+
+	if len(selected) != 2 || selected[0].Number != 1 || selected[1].Number != 3 {
+		t.Fatalf("Unexpected keyframes selected")
+	}
+}
@@ -0,0 +1,268 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// StageName is a string label identifying a stage of the publishing pipeline.
+type StageName string
+
+// STAGE_TIMESTAMP is the stage where the "taken" timestamp is derived from a post's "taken_at" datetime string.
+const STAGE_TIMESTAMP StageName = "timestamp"
+
+// STAGE_HASHING is the stage where the media file(s) for a post are read, typed and hashed.
+const STAGE_HASHING StageName = "hashing"
+
+// STAGE_CAPTION is the stage where a post's caption is expanded.
+const STAGE_CAPTION StageName = "caption"
+
+// STAGE_MEDIA_ID is the stage where a post's media ID (and slide media IDs) are derived.
+const STAGE_MEDIA_ID StageName = "media_id"
+
+// STAGE_MATCH is the stage where a post is matched to an existing WOF record.
+const STAGE_MATCH StageName = "match"
+
+// STAGE_READ is the stage where an existing WOF record is read, or a new record created from a template.
+const STAGE_READ StageName = "read"
+
+// STAGE_RECORD is the stage where the properties of the (new or updated) WOF record for a post are assigned.
+const STAGE_RECORD StageName = "record"
+
+// STAGE_UPLOAD is the stage where a post's original media file, and resized derivatives, are uploaded to a media
+// bucket and their sizes assigned to the WOF record. It does nothing unless a derivatives bucket is configured.
+const STAGE_UPLOAD StageName = "upload"
+
+// STAGE_DIFF is the stage where an updated WOF record is compared to the existing record. Unchanged records
+// are not written.
+const STAGE_DIFF StageName = "diff"
+
+// STAGE_PROVENANCE is the stage where the provenance of the export bundle is assigned to new and updated WOF records.
+// It runs after `STAGE_DIFF` so that provenance alone does not cause an otherwise unchanged record to be written.
+const STAGE_PROVENANCE StageName = "provenance"
+
+// STAGE_WRITE is the stage where the WOF record for a post is written (and the lookup updated).
+const STAGE_WRITE StageName = "write"
+
+// PostState is a struct containing the state of an Instagram post as it moves through the stages of a `Pipeline`.
+type PostState struct {
+	// Path is the (relative) media path of the post.
+	Path string
+	// Body is the (JSON-encoded) Instagram post. Stages before `STAGE_RECORD` may modify it; it is assigned
+	// to the record's `instagram:post` property.
+	Body []byte
+	// MediaId is the SFO Museum media ID derived for the post.
+	MediaId string
+	// SlideIds is the list of media IDs derived for each slide of a carousel post.
+	SlideIds []string
+	// WOFId is the WOF ID of the existing record the post was matched to, or the record that was written.
+	WOFId int64
+	// Matched is a boolean value indicating whether the post was matched to an existing record.
+	Matched bool
+	// Record is the (GeoJSON-encoded) WOF record being assembled for the post. It is assigned by `STAGE_READ`.
+	Record []byte
+	// ExistingRecord is the (GeoJSON-encoded) existing WOF record, if the post was matched to one.
+	ExistingRecord []byte
+	// Result is the `Result` describing what was (or would be) done.
+	Result *Result
+	// Logger is a `slog.Logger` instance scoped to the post.
+	Logger *slog.Logger
+	// Done is a boolean value that a stage can set to stop processing the post without error (for example because
+	// its record is unchanged).
+	Done bool
+}
+
+// Stage is an interface for individual stages of the publishing pipeline.
+type Stage interface {
+	// Name returns the name of the stage.
+	Name() StageName
+	// Process processes the post in 'state'.
+	Process(context.Context, *PublishOptions, *PostState) error
+}
+
+// StageFunc is a function used to process a post in a stage created by `NewStage`.
+type StageFunc func(context.Context, *PublishOptions, *PostState) error
+
+type funcStage struct {
+	name StageName
+	fn   StageFunc
+}
+
+// NewStage returns a new `Stage` instance named 'name' which invokes 'fn'.
+func NewStage(name StageName, fn StageFunc) Stage {
+
+	s := &funcStage{
+		name: name,
+		fn:   fn,
+	}
+
+	return s
+}
+
+// Name returns the name of the stage.
+func (s *funcStage) Name() StageName {
+	return s.name
+}
+
+// Process processes the post in 'state'.
+func (s *funcStage) Process(ctx context.Context, opts *PublishOptions, state *PostState) error {
+	return s.fn(ctx, opts, state)
+}
+
+// ErrStageNotFound is returned by `Pipeline` methods when a named stage does not exist.
+var ErrStageNotFound = errors.New("Stage not found")
+
+// Pipeline is an ordered list of `Stage` instances used to publish an Instagram post. Custom stages (for example to
+// add alt text or tags) can be inserted and built-in stages removed or replaced. Stages that modify the post should be
+// inserted before `STAGE_RECORD`; stages that modify the WOF record should be inserted after it. Pipelines should not be
+// modified while posts are being published.
+type Pipeline struct {
+	stages []Stage
+}
+
+// NewPipeline returns a new `Pipeline` instance for 'stages'.
+func NewPipeline(stages ...Stage) *Pipeline {
+
+	p := &Pipeline{
+		stages: stages,
+	}
+
+	return p
+}
+
+// DefaultPipeline returns a new `Pipeline` instance with the built-in stages, in order: `STAGE_TIMESTAMP`,
+// `STAGE_HASHING`, `STAGE_CAPTION`, `STAGE_MEDIA_ID`, `STAGE_MATCH`, `STAGE_READ`, `STAGE_RECORD`, `STAGE_UPLOAD`,
+// `STAGE_DIFF`, `STAGE_PROVENANCE` and `STAGE_WRITE`.
+func DefaultPipeline() *Pipeline {
+
+	return NewPipeline(
+		NewStage(STAGE_TIMESTAMP, timestampStage),
+		NewStage(STAGE_HASHING, hashingStage),
+		NewStage(STAGE_CAPTION, captionStage),
+		NewStage(STAGE_MEDIA_ID, mediaIdStage),
+		NewStage(STAGE_MATCH, matchStage),
+		NewStage(STAGE_READ, readStage),
+		NewStage(STAGE_RECORD, recordStage),
+		NewStage(STAGE_UPLOAD, uploadStage),
+		NewStage(STAGE_DIFF, diffStage),
+		NewStage(STAGE_PROVENANCE, provenanceStage),
+		NewStage(STAGE_WRITE, writeStage),
+	)
+}
+
+// Stages returns the names of the stages in the pipeline, in order.
+func (p *Pipeline) Stages() []StageName {
+
+	names := make([]StageName, len(p.stages))
+
+	for i, s := range p.stages {
+		names[i] = s.Name()
+	}
+
+	return names
+}
+
+// InsertBefore inserts 's' before the stage named 'name'.
+func (p *Pipeline) InsertBefore(name StageName, s Stage) error {
+
+	idx, err := p.index(name)
+
+	if err != nil {
+		return err
+	}
+
+	p.insert(idx, s)
+	return nil
+}
+
+// InsertAfter inserts 's' after the stage named 'name'.
+func (p *Pipeline) InsertAfter(name StageName, s Stage) error {
+
+	idx, err := p.index(name)
+
+	if err != nil {
+		return err
+	}
+
+	p.insert(idx+1, s)
+	return nil
+}
+
+// Append appends 's' to the end of the pipeline.
+func (p *Pipeline) Append(s Stage) {
+	p.stages = append(p.stages, s)
+}
+
+// Replace replaces the stage named 'name' with 's'.
+func (p *Pipeline) Replace(name StageName, s Stage) error {
+
+	idx, err := p.index(name)
+
+	if err != nil {
+		return err
+	}
+
+	p.stages[idx] = s
+	return nil
+}
+
+// Remove removes (disables) the stage named 'name'.
+func (p *Pipeline) Remove(name StageName) error {
+
+	idx, err := p.index(name)
+
+	if err != nil {
+		return err
+	}
+
+	p.stages = append(p.stages[:idx], p.stages[idx+1:]...)
+	return nil
+}
+
+// Run processes 'state' with each stage in the pipeline, in order, until a stage fails or sets 'state.Done'.
+// If 'opts.Metrics' is not nil the time spent in each stage is recorded. Errors are returned as `PublishError`
+// instances recording the stage that failed.
+func (p *Pipeline) Run(ctx context.Context, opts *PublishOptions, state *PostState) error {
+
+	for _, s := range p.stages {
+
+		t1 := time.Now()
+
+		err := s.Process(ctx, opts, state)
+
+		if opts.Metrics != nil {
+			opts.Metrics.AddStageDuration(s.Name(), time.Since(t1))
+		}
+
+		if err != nil {
+			return NewPublishError(state.Path, s.Name(), err)
+		}
+
+		if state.Done {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (p *Pipeline) index(name StageName) (int, error) {
+
+	for i, s := range p.stages {
+
+		if s.Name() == name {
+			return i, nil
+		}
+	}
+
+	return -1, fmt.Errorf("%w (%s)", ErrStageNotFound, name)
+}
+
+func (p *Pipeline) insert(idx int, s Stage) {
+	p.stages = append(p.stages, nil)
+	copy(p.stages[idx+1:], p.stages[idx:])
+	p.stages[idx] = s
+}
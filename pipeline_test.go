@@ -0,0 +1,162 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+
+	ctx := context.Background()
+
+	default_stages := []StageName{
+		STAGE_TIMESTAMP, STAGE_HASHING, STAGE_CAPTION, STAGE_MEDIA_ID, STAGE_MATCH, STAGE_READ,
+		STAGE_RECORD, STAGE_UPLOAD, STAGE_DIFF, STAGE_PROVENANCE, STAGE_WRITE,
+	}
+
+	if fmt.Sprintf("%v", DefaultPipeline().Stages()) != fmt.Sprintf("%v", default_stages) {
+		t.Fatalf("Unexpected default stages: %v", DefaultPipeline().Stages())
+	}
+
+	calls := make([]StageName, 0)
+
+	// new_stage returns a stage that records that it was called
+
+	new_stage := func(name StageName) Stage {
+
+		fn := func(ctx context.Context, opts *PublishOptions, state *PostState) error {
+			calls = append(calls, name)
+			return nil
+		}
+
+		return NewStage(name, fn)
+	}
+
+	p := NewPipeline(new_stage("a"), new_stage("b"), new_stage("c"))
+
+	err := p.InsertBefore("b", new_stage("before_b"))
+
+	if err != nil {
+		t.Fatalf("Failed to insert stage, %v", err)
+	}
+
+	err = p.InsertAfter("c", new_stage("after_c"))
+
+	if err != nil {
+		t.Fatalf("Failed to insert stage, %v", err)
+	}
+
+	p.Append(new_stage("d"))
+
+	err = p.Replace("a", new_stage("z"))
+
+	if err != nil {
+		t.Fatalf("Failed to replace stage, %v", err)
+	}
+
+	err = p.Remove("c")
+
+	if err != nil {
+		t.Fatalf("Failed to remove stage, %v", err)
+	}
+
+	expected := []StageName{"z", "before_b", "b", "after_c", "d"}
+
+	if fmt.Sprintf("%v", p.Stages()) != fmt.Sprintf("%v", expected) {
+		t.Fatalf("Unexpected stages: %v", p.Stages())
+	}
+
+	err = p.Run(ctx, &PublishOptions{}, &PostState{Path: "media/posts/a.jpg"})
+
+	if err != nil {
+		t.Fatalf("Failed to run pipeline, %v", err)
+	}
+
+	if fmt.Sprintf("%v", calls) != fmt.Sprintf("%v", expected) {
+		t.Fatalf("Unexpected stages called: %v", calls)
+	}
+
+	for label, fn := range map[string]func() error{
+		"InsertBefore": func() error { return p.InsertBefore("missing", new_stage("x")) },
+		"InsertAfter":  func() error { return p.InsertAfter("missing", new_stage("x")) },
+		"Replace":      func() error { return p.Replace("missing", new_stage("x")) },
+		"Remove":       func() error { return p.Remove("missing") },
+	} {
+
+		err := fn()
+
+		if !errors.Is(err, ErrStageNotFound) {
+			t.Fatalf("Expected %s to return ErrStageNotFound for unknown stage, got %v", label, err)
+		}
+	}
+
+	if len(p.Stages()) != len(expected) {
+		t.Fatalf("Expected pipeline to be unchanged by failed operations: %v", p.Stages())
+	}
+}
+
+func TestPipelineRunError(t *testing.T) {
+
+	ctx := context.Background()
+
+	stage_err := errors.New("Nope")
+	called := false
+
+	p := NewPipeline(
+		NewStage("ok", func(ctx context.Context, opts *PublishOptions, state *PostState) error {
+			return nil
+		}),
+		NewStage("fail", func(ctx context.Context, opts *PublishOptions, state *PostState) error {
+			return stage_err
+		}),
+		NewStage("never", func(ctx context.Context, opts *PublishOptions, state *PostState) error {
+			called = true
+			return nil
+		}),
+	)
+
+	err := p.Run(ctx, &PublishOptions{}, &PostState{Path: "media/posts/a.jpg"})
+
+	var publish_err *PublishError
+
+	if !errors.As(err, &publish_err) {
+		t.Fatalf("Expected PublishError, got %v", err)
+	}
+
+	if publish_err.Stage != "fail" || publish_err.Path != "media/posts/a.jpg" {
+		t.Fatalf("Unexpected stage or path for error: %s %s", publish_err.Stage, publish_err.Path)
+	}
+
+	if !errors.Is(err, stage_err) {
+		t.Fatalf("Expected error to wrap stage error")
+	}
+
+	if called {
+		t.Fatalf("Expected pipeline to stop after failed stage")
+	}
+
+	// Stages can stop processing a post without error
+
+	p = NewPipeline(
+		NewStage("done", func(ctx context.Context, opts *PublishOptions, state *PostState) error {
+			state.Done = true
+			return nil
+		}),
+		NewStage("never", func(ctx context.Context, opts *PublishOptions, state *PostState) error {
+			called = true
+			return nil
+		}),
+	)
+
+	err = p.Run(ctx, &PublishOptions{}, &PostState{Path: "media/posts/a.jpg"})
+
+	if err != nil {
+		t.Fatalf("Failed to run pipeline, %v", err)
+	}
+
+	if called {
+		t.Fatalf("Expected pipeline to stop after state.Done was set")
+	}
+}
@@ -0,0 +1,155 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sfomuseum/go-sfomuseum-instagram/media"
+)
+
+// POSTS_JSON_PATH_TEMPLATE is a fmt.Sprintf template for the relative paths of the (numbered, starting at 1)
+// "posts" JSON files in (current) Instagram export bundles.
+const POSTS_JSON_PATH_TEMPLATE string = "your_instagram_activity/content/posts_%d.json"
+
+// DEFAULT_TIMEZONE is the timezone used to derive `taken_at` datetime strings from the Unix timestamps in
+// Instagram "posts" JSON files. Datetime strings in older (HTML and media.json) exports were recorded as
+// wall-clock times for this timezone so using it ensures that media IDs remain stable across export formats.
+const DEFAULT_TIMEZONE string = "America/Los_Angeles"
+
+// PostMedia is a struct representing an individual media element in an Instagram "posts" JSON file.
+type PostMedia struct {
+	// URI is the path of the media file relative to the root of the export bundle.
+	URI string `json:"uri"`
+	// CreationTimestamp is the Unix timestamp when the media element was created.
+	CreationTimestamp int64 `json:"creation_timestamp"`
+	// Title is the caption of the media element. For posts with a single media element this is the caption of the post.
+	Title string `json:"title"`
+}
+
+// Post is a struct representing a single post in an Instagram "posts" JSON file (for example
+// your_instagram_activity/content/posts_1.json).
+type Post struct {
+	// Media is the list of media elements associated with the post.
+	Media []*PostMedia `json:"media"`
+	// Title is the caption of the post. It is only present for posts with multiple media elements.
+	Title string `json:"title,omitempty"`
+	// CreationTimestamp is the Unix timestamp when the post was created. It is only present for posts with multiple media elements.
+	CreationTimestamp int64 `json:"creation_timestamp,omitempty"`
+}
+
+// IsPostsJSON returns a boolean value indicating whether 'body' is an Instagram "posts" JSON file (as opposed
+// to a legacy media.json file).
+func IsPostsJSON(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	return len(body) > 0 && body[0] == '['
+}
+
+// NormalizeArchive returns a JSON-encoded `Archive` derived from 'body' which may be either a legacy media.json
+// file or an Instagram "posts" JSON file. Datetime strings for the latter will be derived using 'loc'.
+func NormalizeArchive(ctx context.Context, body []byte, loc *time.Location) ([]byte, error) {
+
+	if !IsPostsJSON(body) {
+		return body, nil
+	}
+
+	var posts []*Post
+
+	err := json.Unmarshal(body, &posts)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal posts, %w", err)
+	}
+
+	photos, err := DerivePhotosFromPosts(ctx, posts, loc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{
+		Photos: photos,
+	}
+
+	return json.Marshal(archive)
+}
+
+// DerivePhotosFromPosts returns a list of `Photo` instances derived from 'posts' with datetime strings
+// derived using 'loc'. Posts with multiple media elements (carousels) will produce a single `Photo` whose
+// `Path` is the first media element and whose `Media` property lists all the media elements, in order.
+func DerivePhotosFromPosts(ctx context.Context, posts []*Post, loc *time.Location) ([]*Photo, error) {
+
+	photos := make([]*Photo, 0)
+
+	for i, p := range posts {
+
+		if len(p.Media) == 0 {
+			return nil, fmt.Errorf("Post at offset %d has no media", i)
+		}
+
+		caption := p.Title
+		created := p.CreationTimestamp
+
+		if caption == "" {
+			caption = p.Media[0].Title
+		}
+
+		if created == 0 {
+			created = p.Media[0].CreationTimestamp
+		}
+
+		if created == 0 {
+			return nil, fmt.Errorf("Post at offset %d is missing creation timestamp", i)
+		}
+
+		taken_at := time.Unix(created, 0).In(loc).Format(media.TIME_FORMAT)
+
+		ph := &Photo{
+			Caption:           FixMojibake(caption),
+			TakenAt:           taken_at,
+			CreationTimestamp: created,
+			Path:              p.Media[0].URI,
+		}
+
+		if len(p.Media) > 1 {
+
+			ph.Media = make([]*PhotoMedia, len(p.Media))
+
+			for j, m := range p.Media {
+				ph.Media[j] = &PhotoMedia{
+					Path: m.URI,
+				}
+			}
+		}
+
+		photos = append(photos, ph)
+	}
+
+	return photos, nil
+}
+
+// FixMojibake returns a copy of 's' with UTF-8 byte sequences that have been (mis)encoded as individual Latin-1
+// characters, which is how Instagram (and Facebook) encode non-ASCII characters in their JSON exports, restored.
+// For example "â\u0080\u0099" becomes "’". Strings which can not be restored are returned unchanged.
+func FixMojibake(s string) string {
+
+	b := make([]byte, 0, len(s))
+
+	for _, r := range s {
+
+		if r > 0xff {
+			return s
+		}
+
+		b = append(b, byte(r))
+	}
+
+	if !utf8.Valid(b) {
+		return s
+	}
+
+	return string(b)
+}
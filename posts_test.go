@@ -0,0 +1,81 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestFixMojibake(t *testing.T) {
+
+	tests := map[string]string{
+		"SFO Museumâ\u0080\u0099s collection": "SFO Museum’s collection",
+		"CafÃ©":                               "Café",
+		"Hello world":                         "Hello world",
+		"Already fixed ’":                     "Already fixed ’",
+	}
+
+	for input, expected := range tests {
+
+		v := FixMojibake(input)
+
+		if v != expected {
+			t.Fatalf("Invalid string. Expected '%s', got '%s'", expected, v)
+		}
+	}
+}
+
+func TestNormalizeArchive(t *testing.T) {
+
+	ctx := context.Background()
+
+	body := []byte(`[
+  {"media":[{"uri":"media/posts/202411/a.jpg","creation_timestamp":1732665600,"title":"CafÃ© #sfo"}]},
+  {"media":[{"uri":"media/posts/202411/b.jpg","creation_timestamp":0,"title":""},{"uri":"media/posts/202411/c.jpg","creation_timestamp":0,"title":""}],"title":"Carousel","creation_timestamp":1732665600}
+]`)
+
+	loc, err := time.LoadLocation(DEFAULT_TIMEZONE)
+
+	if err != nil {
+		t.Skipf("Failed to load timezone, %v", err)
+	}
+
+	enc_archive, err := NormalizeArchive(ctx, body, loc)
+
+	if err != nil {
+		t.Fatalf("Failed to normalize archive, %v", err)
+	}
+
+	var archive Archive
+
+	err = json.Unmarshal(enc_archive, &archive)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal archive, %v", err)
+	}
+
+	if len(archive.Photos) != 2 {
+		t.Fatalf("Expected 2 photos, got %d", len(archive.Photos))
+	}
+
+	ph := archive.Photos[0]
+
+	if ph.Caption != "Café #sfo" {
+		t.Fatalf("Unexpected caption '%s'", ph.Caption)
+	}
+
+	if ph.TakenAt != "Nov 26, 2024 4:00 PM" {
+		t.Fatalf("Unexpected taken_at '%s'", ph.TakenAt)
+	}
+
+	carousel := archive.Photos[1]
+
+	if carousel.Caption != "Carousel" || carousel.Path != "media/posts/202411/b.jpg" {
+		t.Fatalf("Unexpected carousel photo, %v", carousel)
+	}
+
+	if len(carousel.Media) != 2 || carousel.Media[1].Path != "media/posts/202411/c.jpg" {
+		t.Fatalf("Unexpected carousel media, %v", carousel.Media)
+	}
+}
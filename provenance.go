@@ -0,0 +1,171 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// PROVENANCE_PROPERTY is the WOF property where the provenance of the most recent export to update a record is stored.
+const PROVENANCE_PROPERTY string = "instagram:provenance"
+
+// PROVENANCE_HISTORY_PROPERTY is the WOF property where the provenance of earlier exports to update a record is
+// stored, oldest first.
+const PROVENANCE_HISTORY_PROPERTY string = "instagram:provenance_history"
+
+// MODULE_PATH is the Go module path for this package, used to derive the tool version.
+const MODULE_PATH string = "github.com/sfomuseum/go-sfomuseum-instagram-publish"
+
+// TOOL_VERSION is the version of the tool that writes records. It is empty by default and is meant to be assigned
+// at build time, for example `go build -ldflags "-X github.com/sfomuseum/go-sfomuseum-instagram-publish.TOOL_VERSION=v1.2.3"`
+// (see the Makefile). If empty the version is derived from the binary's build information.
+var TOOL_VERSION string
+
+var re_bundle_date = regexp.MustCompile(`(\d{4})-?(\d{2})-?(\d{2})`)
+
+// Provenance is a struct describing the Instagram export bundle that created or last updated a WOF record.
+type Provenance struct {
+	// Bundle is the name of the Instagram export bundle, if known.
+	Bundle string `json:"bundle,omitempty"`
+	// ExportDate is the date (YYYY-MM-DD) the export bundle was created, if it can be derived from its name.
+	ExportDate string `json:"export_date,omitempty"`
+	// SourcePath is the path of the post's media file inside the export bundle.
+	SourcePath string `json:"source_path"`
+	// ToolVersion is the version of the tool that wrote the record.
+	ToolVersion string `json:"tool_version"`
+}
+
+// BundleNameFromURI derives the name of an Instagram export bundle from the URI of the (gocloud.dev/blob) bucket
+// it is read from: the last element of the URI's path without any extension. For example both
+// "zip:///usr/local/data/instagram-sfomuseum-2024-11-27-p55zxMWB.zip" and
+// "file:///usr/local/data/instagram-sfomuseum-2024-11-27-p55zxMWB/" yield "instagram-sfomuseum-2024-11-27-p55zxMWB".
+func BundleNameFromURI(uri string) (string, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	path := u.Path
+
+	if path == "" {
+		path = u.Host
+	}
+
+	if path == "" {
+		return "", nil
+	}
+
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	return name, nil
+}
+
+// ExportDateFromBundleName derives the date (YYYY-MM-DD) an Instagram export bundle was created from its name, for
+// example "instagram-sfomuseum-2024-11-27-p55zxMWB" or "sfomuseum_20220418". It returns an empty string if no date
+// can be found.
+func ExportDateFromBundleName(name string) string {
+
+	m := re_bundle_date.FindStringSubmatch(name)
+
+	if m == nil {
+		return ""
+	}
+
+	str_date := fmt.Sprintf("%s-%s-%s", m[1], m[2], m[3])
+
+	_, err := time.Parse(time.DateOnly, str_date)
+
+	if err != nil {
+		return ""
+	}
+
+	return str_date
+}
+
+// ToolVersion returns the version of this package in the running binary. This is `TOOL_VERSION` if it was assigned
+// at build time, otherwise it is derived from the binary's build information. If the package is being built as the
+// main module (for example the tools in cmd) the VCS revision is used if the version is not known. Binaries built
+// from a list of files (`go build cmd/publish/main.go`) rather than a package path have neither so `TOOL_VERSION`
+// should be assigned for those. It returns "unknown" if no build information is available.
+func ToolVersion() string {
+
+	if TOOL_VERSION != "" {
+		return TOOL_VERSION
+	}
+
+	info, ok := debug.ReadBuildInfo()
+
+	if !ok {
+		return "unknown"
+	}
+
+	if info.Main.Path != MODULE_PATH {
+
+		for _, dep := range info.Deps {
+
+			if dep.Path == MODULE_PATH {
+				return dep.Version
+			}
+		}
+
+		return "unknown"
+	}
+
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	for _, s := range info.Settings {
+
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+
+	return "(devel)"
+}
+
+// AppendProvenance assigns 'p' to the `PROVENANCE_PROPERTY` property of 'body'. If 'body' already has provenance
+// from a different export bundle it is appended to the `PROVENANCE_HISTORY_PROPERTY` property first.
+func AppendProvenance(ctx context.Context, body []byte, p *Provenance) ([]byte, error) {
+
+	var err error
+
+	prev_rsp := gjson.GetBytes(body, "properties."+PROVENANCE_PROPERTY)
+
+	if prev_rsp.Exists() && prev_rsp.Get("bundle").String() != p.Bundle {
+
+		history := make([]interface{}, 0)
+
+		for _, h := range gjson.GetBytes(body, "properties."+PROVENANCE_HISTORY_PROPERTY).Array() {
+			history = append(history, h.Value())
+		}
+
+		history = append(history, prev_rsp.Value())
+
+		body, err = sjson.SetBytes(body, "properties."+PROVENANCE_HISTORY_PROPERTY, history)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to assign provenance history, %w", err)
+		}
+	}
+
+	body, err = sjson.SetBytes(body, "properties."+PROVENANCE_PROPERTY, p)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to assign provenance, %w", err)
+	}
+
+	return body, nil
+}
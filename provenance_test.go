@@ -0,0 +1,85 @@
+package publish
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestBundleNameFromURI(t *testing.T) {
+
+	tests := map[string]string{
+		"zip:///usr/local/data/instagram-sfomuseum-2024-11-27-p55zxMWB.zip":  "instagram-sfomuseum-2024-11-27-p55zxMWB",
+		"zip:///usr/local/data/instagram%20sfomuseum%20%231.zip":             "instagram sfomuseum #1",
+		"file:///usr/local/data/instagram-sfomuseum-2024-11-27-p55zxMWB/":    "instagram-sfomuseum-2024-11-27-p55zxMWB",
+		"s3://sfomuseum-media/instagram/sfomuseum_20220418?region=us-west-2": "sfomuseum_20220418",
+	}
+
+	for uri, expected := range tests {
+
+		name, err := BundleNameFromURI(uri)
+
+		if err != nil {
+			t.Fatalf("Failed to derive bundle name for %s, %v", uri, err)
+		}
+
+		if name != expected {
+			t.Fatalf("Unexpected bundle name for %s: %s", uri, name)
+		}
+	}
+}
+
+func TestExportDateFromBundleName(t *testing.T) {
+
+	tests := map[string]string{
+		"instagram-sfomuseum-2024-11-27-p55zxMWB": "2024-11-27",
+		"sfomuseum_20220418":                      "2022-04-18",
+		"sfomuseum":                               "",
+	}
+
+	for name, expected := range tests {
+
+		d := ExportDateFromBundleName(name)
+
+		if d != expected {
+			t.Fatalf("Unexpected export date for %s: %s", name, d)
+		}
+	}
+}
+
+func TestAppendProvenance(t *testing.T) {
+
+	ctx := context.Background()
+
+	body := []byte(`{"type":"Feature","properties":{}}`)
+
+	bundles := []string{"sfomuseum_20220418", "sfomuseum_20220418", "instagram-sfomuseum-2024-11-27-p55zxMWB"}
+
+	for _, b := range bundles {
+
+		p := &Provenance{
+			Bundle:     b,
+			ExportDate: ExportDateFromBundleName(b),
+			SourcePath: "media/posts/202204/123.jpg",
+		}
+
+		new_body, err := AppendProvenance(ctx, body, p)
+
+		if err != nil {
+			t.Fatalf("Failed to append provenance for %s, %v", b, err)
+		}
+
+		body = new_body
+	}
+
+	if gjson.GetBytes(body, "properties.instagram:provenance.bundle").String() != "instagram-sfomuseum-2024-11-27-p55zxMWB" {
+		t.Fatalf("Unexpected provenance")
+	}
+
+	history := gjson.GetBytes(body, "properties.instagram:provenance_history").Array()
+
+	if len(history) != 1 || history[0].Get("export_date").String() != "2022-04-18" {
+		t.Fatalf("Unexpected provenance history: %s", gjson.GetBytes(body, "properties.instagram:provenance_history").Raw)
+	}
+}
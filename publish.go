@@ -2,288 +2,207 @@ package publish
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"log/slog"
-	"path/filepath"
-	"sync"
 	"time"
 
-	"github.com/sfomuseum/go-sfomuseum-instagram/media"
-	sfom_reader "github.com/sfomuseum/go-sfomuseum-reader"
-	sfom_writer "github.com/sfomuseum/go-sfomuseum-writer/v3"
 	"github.com/tidwall/gjson"
-	"github.com/tidwall/sjson"
 	"github.com/whosonfirst/go-reader"
 	"github.com/whosonfirst/go-writer/v3"
 	"gocloud.dev/blob"
 )
 
 type PublishOptions struct {
-	Lookup      *sync.Map
+	Lookup      Lookup
 	Reader      reader.Reader
 	Writer      writer.Writer
 	MediaBucket *blob.Bucket
+	// DryRun is a boolean flag indicating that records should be classified (new, update, unchanged) but not written.
+	DryRun bool
+	// Report is an optional `Report` instance where the `Result` of each published post will be recorded.
+	Report *Report
+	// HashIndex is an optional `PerceptualHashIndex` used to match posts to existing records when neither their
+	// media ID nor media path can be found in Lookup.
+	HashIndex *PerceptualHashIndex
+	// FuzzyThreshold is the maximum Hamming distance between perceptual hashes for a post to be matched using HashIndex.
+	// If zero fuzzy matching is disabled.
+	FuzzyThreshold int
+	// Template is an optional `RecordTemplate` instance used to create new records. If nil `DefaultRecordTemplate` will be used.
+	Template *RecordTemplate
+	// LogChanges is a boolean flag indicating that the property-level changes for updated records should be logged.
+	LogChanges bool
+	// VideoFrameDecoder is an optional `VideoFrameDecoder` instance used to decode video keyframes when deriving video
+	// perceptual hashes. If nil only videos whose frames can be decoded natively will be assigned a video hash.
+	VideoFrameDecoder VideoFrameDecoder
+	// VideoHashFrames is the number of keyframes used to derive video perceptual hashes. If zero `DEFAULT_VIDEO_HASH_FRAMES` will be used.
+	VideoHashFrames int
+	// Journal is an optional `Journal` instance used to record the status of each post and to skip posts that
+	// were published in a previous (interrupted) run. Statuses are not recorded in dry-run mode.
+	Journal *Journal
+	// RetryFailed is a boolean flag indicating that only posts whose most recent status in Journal is
+	// `JOURNAL_STATUS_FAILED` should be published.
+	RetryFailed bool
+	// Timezone is the timezone of the (wall-clock) "taken_at" datetime strings in the export being published. It is
+	// used to derive the "taken" timestamp and the "taken_at_local" and "taken_at_utc" properties. If nil, and a post
+	// has no creation timestamp, "taken_at" is interpreted as a UTC time (which was the original behaviour).
+	Timezone *time.Location
+	// DerivativesBucket is an optional gocloud.dev/blob bucket where the original media file for each post, and resized
+	// derivatives, will be uploaded using the "{media_id}/{media_id}_{secret}_{size}.{extension}" naming scheme.
+	DerivativesBucket *blob.Bucket
+	// DerivativeSizes is the list of derivatives to generate for images. If nil `DEFAULT_DERIVATIVE_SIZES` is used.
+	DerivativeSizes []*DerivativeSize
+	// AuditLog is an optional `AuditLog` instance where every record that is written will be recorded.
+	AuditLog *AuditLog
+	// Bundle is the optional name of the Instagram export bundle being published, recorded in the provenance
+	// properties of new and updated records and in AuditLog.
+	Bundle string
+	// Commit is the optional git commit (HEAD) of the data repository at the start of the run, recorded in AuditLog.
+	Commit string
+	// MediaLimiter is an optional `Limiter` instance used to limit reads from MediaBucket.
+	MediaLimiter *Limiter
+	// WriteLimiter is an optional `Limiter` instance used to limit writes to Writer.
+	WriteLimiter *Limiter
+	// Metrics is an optional `Metrics` instance where counts and per-stage timings will be recorded.
+	Metrics *Metrics
+	// Pipeline is an optional `Pipeline` instance defining the stages used to publish each post. If nil
+	// `DefaultPipeline` will be used.
+	Pipeline *Pipeline
 }
 
+// PublishMedia will create or update a WOF record for the Instagram post defined in 'body'. Errors are returned
+// as `PublishError` instances recording the pipeline stage that failed.
 func PublishMedia(ctx context.Context, opts *PublishOptions, body []byte) error {
+	_, err := PublishMediaWithResult(ctx, opts, body)
+	return err
+}
 
-	select {
-	case <-ctx.Done():
-		return nil
-	default:
-		// pass
-	}
-
-	path_rsp := gjson.GetBytes(body, "path")
-	path := path_rsp.String()
-
-	logger := slog.Default()
-	logger = logger.With("path", path)
-
-	is_video := false
-
-	// This should be a little more sophisticated
-	if filepath.Ext(path) == ".mp4" {
-		is_video = true
-	}
-
-	body, err := media.AppendTakenAtTimestamp(ctx, body)
-
-	if err != nil {
-		return fmt.Errorf("Failed to append taken at timestamp, %w", err)
-	}
-
-	append_opts := &media.AppendHashesOptions{
-		Bucket: opts.MediaBucket,
-	}
-
-	if is_video {
-		append_opts.FileHash = true
-	} else {
-		append_opts.PerceptualHash = true
-	}
-
-	body, err = media.AppendHashes(ctx, append_opts, body)
-
-	if err != nil {
-		logger.Error("Failed to append hashes", "error", err)
-		return fmt.Errorf("Failed to append hashes, %w", err)
-	}
-
-	body, err = media.ExpandCaption(ctx, body)
-
-	if err != nil {
-		logger.Error("Failed to expand caption", "error", err)
-		return fmt.Errorf("Failed to expand caption, %w", err)
-	}
-
-	// We used to use media_id which is derived from the media file path.
-	// However between Oct 2020 and April 2022 those paths changed from
-	// being something like {HASH}.jpg to {SOME}-{THING}-{SOME}-{THING}.jpg
-	// The former allows us to use {HASH} in the mf.sfom URL.
-
-	// You might be asking yourself: Do we really need media ID? The answer
-	// is yes. More specifically we need something that we can for reliably
-	// de-depuplicating IG posts we've already imported. As stated we originally
-	// thought we could rely on the path of the media file associated with a
-	// post but apparently not (they seem to change).
-
-	// Unfortunately for SFO Museum we can't use the body of the caption either
-	// since we sometimes use the same caption for multiple posts. Nor can we
-	// use caption + taken (or taken at) since many of these posts are posted
-	// automatically by tools like hootsuite so they end up with the same timestamps.
-	// For example:
-
-	// https://raw.githubusercontent.com/sfomuseum-data/sfomuseum-data-socialmedia-instagram/main/data/172/935/502/5/1729355025.geojson?token={TOKEN}
-	// https://raw.githubusercontent.com/sfomuseum-data/sfomuseum-data-socialmedia-instagram/main/data/172/935/502/3/1729355023.geojson?token={TOKEN}
-
-	media_id, err := DeriveMediaId(body, "")
-
-	if err != nil {
-		logger.Error("Failed to derive media ID", "error", err)
-		return fmt.Errorf("Failed to derive media ID, %w", err)
-	}
-
-	body, err = sjson.SetBytes(body, "media_id", media_id)
-
-	if err != nil {
-		logger.Error("Failed to assign media ID", "error", err)
-		return fmt.Errorf("Failed to assign media_id to post, %w", err)
-	}
-
-	// lookup.go
-
-	pointer, ok := opts.Lookup.Load(media_id)
-
-	// Add path to the file as a fallback because apparently IG does stuff to the
-	// photos between archive runs that causes the percaptual hash to change. Good
-	// times...
-
-	if !ok {
-		pointer, ok = opts.Lookup.Load(path)
-	}
+// PublishMediaWithResult will create or update a WOF record for the Instagram post defined in 'body'
+// returning a `Result` instance describing what was (or in dry-run mode would be) done. If 'opts.Report'
+// is not nil the result will also be added to it.
+func PublishMediaWithResult(ctx context.Context, opts *PublishOptions, body []byte) (*Result, error) {
 
-	var wof_record []byte
+	path := gjson.GetBytes(body, "path").String()
 
-	if ok {
+	result, skip := skipJournaledMedia(ctx, opts, path)
 
-		wof_id := pointer.(int64)
+	if !skip {
 
-		wof_body, err := sfom_reader.LoadBytesFromID(ctx, opts.Reader, wof_id)
+		r, err := publishMedia(ctx, opts, body)
 
 		if err != nil {
-			return err
-		}
-
-		wof_record = wof_body
 
-		// See this? We are going to ensure we don't accidentally overwrite an
-		// existing media ID. For example the inputs for deriving a media ID
-		// changed between 202010 and 202204 to reflect changes IG made to their
-		// exports.
+			j_err := recordJournal(ctx, opts, &JournalEntry{
+				Path:   path,
+				Status: JOURNAL_STATUS_FAILED,
+				Error:  err.Error(),
+			})
 
-		id_rsp := gjson.GetBytes(wof_body, "properties.instagram:post.media_id")
+			if j_err != nil {
+				slog.Error("Failed to record failure in journal", "path", path, "error", j_err)
+			}
 
-		body, err = sjson.SetBytes(body, "media_id", id_rsp.String())
+			if opts.Metrics != nil {
+				opts.Metrics.AddFailure()
+			}
 
-		if err != nil {
-			logger.Error("Failed to assign media ID", "error", err)
-			return fmt.Errorf("Failed to assign media_id to post, %w", err)
+			return nil, err
 		}
 
-	} else {
-
-		new_record, err := newWOFRecord(ctx)
-
-		if err != nil {
-			logger.Error("Failed to create new record", "error", err)
-			return err
-		}
-
-		wof_record = new_record
+		result = r
 	}
 
-	taken_rsp := gjson.GetBytes(body, "taken")
-
-	if !taken_rsp.Exists() {
-		logger.Error("Missing taken property", "error", err)
-		return fmt.Errorf("Missing created timestamp")
+	if result != nil && opts.Report != nil {
+		opts.Report.Add(result)
 	}
 
-	taken := taken_rsp.Int()
-
-	taken_t := time.Unix(taken, 0)
-
-	if err != nil {
-		logger.Error("Failed to parse taken timestamp", "timestamp", taken, "error", err)
-		return err
+	if result != nil && opts.Metrics != nil {
+		opts.Metrics.AddResult(result)
 	}
 
-	taken_str := taken_t.Format(time.RFC3339)
-
-	wof_record, err = sjson.SetBytes(wof_record, "properties.wof:created", taken_t.Unix())
-
-	if err != nil {
-		return err
-	}
+	return result, nil
+}
 
-	wof_record, err = sjson.SetBytes(wof_record, "properties.edtf:inception", taken_str)
+// skipJournaledMedia returns a `Result` instance and true if the post with media path 'path' should be skipped
+// because of its status in 'opts.Journal'.
+func skipJournaledMedia(ctx context.Context, opts *PublishOptions, path string) (*Result, bool) {
 
-	if err != nil {
-		logger.Error("Failed to assign inception", "error", err)
-		return err
+	if opts.Journal == nil {
+		return nil, false
 	}
 
-	wof_record, err = sjson.SetBytes(wof_record, "properties.edtf:cessation", taken_str)
+	e, exists := opts.Journal.Get(ctx, path)
 
-	if err != nil {
-		logger.Error("Failed to assign cessation", "error", err)
-		return err
+	switch {
+	case opts.RetryFailed && exists && e.Status == JOURNAL_STATUS_FAILED:
+		return nil, false
+	case opts.RetryFailed:
+		// pass
+	case exists && e.IsComplete():
+		// pass
+	default:
+		return nil, false
 	}
 
-	excerpt_rsp := gjson.GetBytes(body, "caption.excerpt")
-
-	if !excerpt_rsp.Exists() {
-		logger.Error("Failed to assign caption", "error", err)
-		return fmt.Errorf("Missing caption.excerpt")
+	result := &Result{
+		Path:   path,
+		Action: ACTION_SKIPPED,
+		DryRun: opts.DryRun,
 	}
 
-	wof_name := fmt.Sprintf("%s..", excerpt_rsp.String())
-	wof_record, err = sjson.SetBytes(wof_record, "properties.wof:name", wof_name)
-
-	if err != nil {
-		logger.Error("Failed to assign name", "error", err)
-		return err
+	if exists {
+		result.MediaId = e.MediaId
+		result.WOFId = e.WOFId
 	}
 
-	var post interface{}
-
-	err = json.Unmarshal(body, &post)
-
-	if err != nil {
-		logger.Error("Failed to unmarshal post", "error", err)
-		return fmt.Errorf("Failed to unmarshal record, %w", err)
-	}
-
-	wof_record, err = sjson.SetBytes(wof_record, "properties.instagram:post", post)
-
-	if err != nil {
-		logger.Error("Failed to assign post properties", "error", err)
-		return fmt.Errorf("Failed to append post, %w", err)
-	}
+	return result, true
+}
 
-	_, err = sfom_writer.WriteBytes(ctx, opts.Writer, wof_record)
+// recordJournal records 'e' in 'opts.Journal' unless it is nil or 'opts.DryRun' is true.
+func recordJournal(ctx context.Context, opts *PublishOptions, e *JournalEntry) error {
 
-	if err != nil {
-		logger.Error("Failed to write new record", "error", err)
-		return fmt.Errorf("Failed to write record, %w", err)
+	if opts.Journal == nil || opts.DryRun {
+		return nil
 	}
 
-	return nil
+	return opts.Journal.Record(ctx, e)
 }
 
-func newWOFRecord(ctx context.Context) ([]byte, error) {
+func publishMedia(ctx context.Context, opts *PublishOptions, body []byte) (*Result, error) {
 
-	// Null Terminal - please read these details from source...
-	// https://raw.githubusercontent.com/sfomuseum-data/sfomuseum-data-architecture/master/data/115/916/086/9/1159160869.geojson
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	default:
+		// pass
+	}
 
-	parent_id := 1159160869
+	path_rsp := gjson.GetBytes(body, "path")
+	path := path_rsp.String()
 
-	lat := 37.616356
-	lon := -122.386166
+	logger := slog.Default()
+	logger = logger.With("path", path)
 
-	hier := []map[string]interface{}{
-		{
-			"building_id":      1159160869,
-			"campus_id":        102527513,
-			"continent_id":     102191575,
-			"country_id":       85633793,
-			"county_id":        102087579,
-			"locality_id":      85922583,
-			"neighbourhood_id": -1,
-			"region_id":        85688637,
+	state := &PostState{
+		Path:   path,
+		Body:   body,
+		Logger: logger,
+		Result: &Result{
+			Path:   path,
+			Action: ACTION_NEW,
+			DryRun: opts.DryRun,
 		},
 	}
 
-	geom := map[string]interface{}{
-		"type":        "Point",
-		"coordinates": [2]float64{lon, lat},
+	pipeline := opts.Pipeline
+
+	if pipeline == nil {
+		pipeline = DefaultPipeline()
 	}
 
-	feature := map[string]interface{}{
-		"type": "Feature",
-		"properties": map[string]interface{}{
-			"sfomuseum:placetype": "instagram",
-			"src:geom":            "sfomuseum",
-			"wof:country":         "US",
-			"wof:parent_id":       parent_id,
-			"wof:placetype":       "custom",
-			"wof:repo":            "sfomuseum-data-socialmedia-instagram",
-			"wof:hierarchy":       hier,
-		},
-		"geometry": geom,
+	err := pipeline.Run(ctx, opts, state)
+
+	if err != nil {
+		return nil, err
 	}
 
-	return json.Marshal(feature)
+	return state.Result, nil
 }
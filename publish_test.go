@@ -0,0 +1,543 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+	"github.com/whosonfirst/go-writer/v3"
+	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/fileblob"
+)
+
+// newPublishTestOptions returns a new `PublishOptions` instance for the data repository 'repo' (read and written
+// using repo:// URIs) with media and derivatives buckets in temporary directories. Each of 'media_paths' is written
+// to the media bucket as a (different) JPEG image.
+func newPublishTestOptions(t *testing.T, repo string, media_paths ...string) *PublishOptions {
+
+	ctx := context.Background()
+
+	media_bucket, err := blob.OpenBucket(ctx, "file://"+t.TempDir())
+
+	if err != nil {
+		t.Fatalf("Failed to open media bucket, %v", err)
+	}
+
+	t.Cleanup(func() { media_bucket.Close() })
+
+	derivatives_bucket, err := blob.OpenBucket(ctx, "file://"+t.TempDir())
+
+	if err != nil {
+		t.Fatalf("Failed to open derivatives bucket, %v", err)
+	}
+
+	t.Cleanup(func() { derivatives_bucket.Close() })
+
+	for i, path := range media_paths {
+
+		im := image.NewRGBA(image.Rect(0, 0, 320, 240))
+
+		for x := 0; x < 320; x++ {
+			im.Set(x, (x*(i+1))%240, color.RGBA{255, 255, 255, 255})
+		}
+
+		var buf bytes.Buffer
+
+		err = jpeg.Encode(&buf, im, nil)
+
+		if err != nil {
+			t.Fatalf("Failed to encode image, %v", err)
+		}
+
+		err = media_bucket.WriteAll(ctx, path, buf.Bytes(), nil)
+
+		if err != nil {
+			t.Fatalf("Failed to write media, %v", err)
+		}
+	}
+
+	rdr, err := reader.NewReader(ctx, "repo://"+repo)
+
+	if err != nil {
+		t.Fatalf("Failed to create reader, %v", err)
+	}
+
+	wr, err := writer.NewWriter(ctx, "repo://"+repo)
+
+	if err != nil {
+		t.Fatalf("Failed to create writer, %v", err)
+	}
+
+	opts := &PublishOptions{
+		Lookup:            NewMemoryLookup(),
+		Reader:            rdr,
+		Writer:            wr,
+		MediaBucket:       media_bucket,
+		DerivativesBucket: derivatives_bucket,
+	}
+
+	return opts
+}
+
+// listKeys returns the keys of every file in 'bucket'.
+func listKeys(t *testing.T, bucket *blob.Bucket) []string {
+
+	ctx := context.Background()
+
+	keys := make([]string, 0)
+	list_iter := bucket.List(nil)
+
+	for {
+
+		obj, err := list_iter.Next(ctx)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatalf("Failed to list bucket, %v", err)
+		}
+
+		keys = append(keys, obj.Key)
+	}
+
+	return keys
+}
+
+// writePublishTestRecord writes a minimal existing WOF record, with ID 'wof_id' and media ID 'media_id', to the
+// data repository 'repo'. It returns the path of the record.
+func writePublishTestRecord(t *testing.T, repo string, wof_id int64, media_id string) string {
+
+	record := fmt.Sprintf(`{"type":"Feature","geometry":{"type":"Point","coordinates":[-122.386,37.616]},"properties":{"wof:id":%d,"wof:name":"Hello..","wof:placetype":"custom","sfomuseum:placetype":"instagram","wof:repo":"sfomuseum-data-socialmedia-instagram","wof:parent_id":-1,"instagram:post":{"media_id":"%s","path":"media/posts/a.jpg"}}}`, wof_id, media_id)
+
+	rel_path, err := uri.Id2RelPath(wof_id)
+
+	if err != nil {
+		t.Fatalf("Failed to derive path for %d, %v", wof_id, err)
+	}
+
+	record_path := filepath.Join(repo, "data", rel_path)
+
+	err = os.MkdirAll(filepath.Dir(record_path), 0755)
+
+	if err != nil {
+		t.Fatalf("Failed to create data directory, %v", err)
+	}
+
+	err = os.WriteFile(record_path, []byte(record), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write record, %v", err)
+	}
+
+	return record_path
+}
+
+func TestPublishMediaUploadsLegacyRecordOnce(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo := t.TempDir()
+
+	// An existing record whose media ID predates hash-derived media IDs
+
+	writePublishTestRecord(t, repo, 1234, "legacy123")
+
+	opts := newPublishTestOptions(t, repo, "media/posts/a.jpg")
+
+	err := opts.Lookup.Store(ctx, "media/posts/a.jpg", 1234)
+
+	if err != nil {
+		t.Fatalf("Failed to store lookup, %v", err)
+	}
+
+	derivatives_bucket := opts.DerivativesBucket
+
+	post := []byte(`{"path":"media/posts/a.jpg","caption":"Hello #sfo","taken_at":"Nov 26, 2024 4:00 PM"}`)
+
+	rsp, err := PublishMediaWithResult(ctx, opts, post)
+
+	if err != nil {
+		t.Fatalf("Failed to publish media, %v", err)
+	}
+
+	if rsp.Action != ACTION_UPDATE || rsp.MediaId != "legacy123" {
+		t.Fatalf("Unexpected result for first run: %s %s", rsp.Action, rsp.MediaId)
+	}
+
+	key := DerivativeKey("legacy123", SIZE_ORIGINAL, "jpg")
+
+	exists, err := derivatives_bucket.Exists(ctx, key)
+
+	if err != nil {
+		t.Fatalf("Failed to determine whether %s exists, %v", key, err)
+	}
+
+	if !exists {
+		t.Fatalf("Expected %s to be uploaded using the record's media ID", key)
+	}
+
+	// Remove the uploaded files so that any upload during the second run can be detected
+
+	for _, k := range listKeys(t, derivatives_bucket) {
+
+		err = derivatives_bucket.Delete(ctx, k)
+
+		if err != nil {
+			t.Fatalf("Failed to delete %s, %v", k, err)
+		}
+	}
+
+	rsp, err = PublishMediaWithResult(ctx, opts, post)
+
+	if err != nil {
+		t.Fatalf("Failed to publish media a second time, %v", err)
+	}
+
+	if rsp.Action != ACTION_UNCHANGED {
+		t.Fatalf("Expected record to be unchanged on second run, got %s", rsp.Action)
+	}
+
+	uploaded := listKeys(t, derivatives_bucket)
+
+	if len(uploaded) != 0 {
+		t.Fatalf("Expected no media to be uploaded on second run, got %v", uploaded)
+	}
+}
+
+func TestPublishMediaUploadsCarouselSlides(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo := t.TempDir()
+
+	record_path := writePublishTestRecord(t, repo, 5678, "legacy567")
+
+	opts := newPublishTestOptions(t, repo, "media/posts/a.jpg", "media/posts/b.jpg")
+
+	err := opts.Lookup.Store(ctx, "media/posts/a.jpg", 5678)
+
+	if err != nil {
+		t.Fatalf("Failed to store lookup, %v", err)
+	}
+
+	post := []byte(`{"path":"media/posts/a.jpg","caption":"Hello #sfo","taken_at":"Nov 26, 2024 4:00 PM","media":[{"path":"media/posts/a.jpg"},{"path":"media/posts/b.jpg"}]}`)
+
+	rsp, err := PublishMediaWithResult(ctx, opts, post)
+
+	if err != nil {
+		t.Fatalf("Failed to publish media, %v", err)
+	}
+
+	body, err := os.ReadFile(record_path)
+
+	if err != nil {
+		t.Fatalf("Failed to read record, %v", err)
+	}
+
+	slide_ids, err := DeriveSlideMediaIds(body, "properties.instagram:post")
+
+	if err != nil {
+		t.Fatalf("Failed to derive slide media IDs, %v", err)
+	}
+
+	for _, k := range []string{
+		DerivativeKey(rsp.MediaId, SIZE_ORIGINAL, "jpg"),
+		DerivativeKey(slide_ids[1], SIZE_ORIGINAL, "jpg"),
+		DerivativeKey(slide_ids[1], "sq", "jpg"),
+	} {
+
+		exists, err := opts.DerivativesBucket.Exists(ctx, k)
+
+		if err != nil {
+			t.Fatalf("Failed to determine whether %s exists, %v", k, err)
+		}
+
+		if !exists {
+			t.Fatalf("Expected %s to be uploaded", k)
+		}
+	}
+
+	if !gjson.GetBytes(body, "properties."+SLIDE_SIZES_PROPERTY+"."+slide_ids[1]+"."+SIZE_ORIGINAL).Exists() {
+		t.Fatalf("Expected slide sizes to be assigned")
+	}
+}
+
+func TestPublishMediaFileHashRebuiltLookup(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(repo, "data"), 0755)
+
+	if err != nil {
+		t.Fatalf("Failed to create data directory, %v", err)
+	}
+
+	opts := newPublishTestOptions(t, repo)
+	opts.DerivativesBucket = nil
+
+	// Media that can't be decoded as an image only gets a file hash
+
+	err = opts.MediaBucket.WriteAll(ctx, "media/posts/a.heic", []byte("not really a HEIC image"), nil)
+
+	if err != nil {
+		t.Fatalf("Failed to write media, %v", err)
+	}
+
+	// Assign the WOF ID of new records up front rather than requesting one from a remote provider
+
+	template, err := DefaultRecordTemplate()
+
+	if err != nil {
+		t.Fatalf("Failed to create template, %v", err)
+	}
+
+	template.feature, err = sjson.SetBytes(template.feature, "properties.wof:id", 4321)
+
+	if err != nil {
+		t.Fatalf("Failed to assign WOF ID to template, %v", err)
+	}
+
+	opts.Template = template
+
+	post := []byte(`{"path":"media/posts/a.heic","caption":"Hello #sfo","taken_at":"Nov 26, 2024 4:00 PM"}`)
+
+	rsp, err := PublishMediaWithResult(ctx, opts, post)
+
+	if err != nil {
+		t.Fatalf("Failed to publish media, %v", err)
+	}
+
+	if rsp.Action != ACTION_NEW || rsp.WOFId != 4321 {
+		t.Fatalf("Unexpected result for first run: %s %d", rsp.Action, rsp.WOFId)
+	}
+
+	// Publish the post again using a lookup rebuilt from the data repository
+
+	lookup := NewMemoryLookup()
+
+	err = PopulateLookup(ctx, lookup, "repo://", repo)
+
+	if err != nil {
+		t.Fatalf("Failed to populate lookup, %v", err)
+	}
+
+	opts.Lookup = lookup
+
+	rsp, err = PublishMediaWithResult(ctx, opts, post)
+
+	if err != nil {
+		t.Fatalf("Failed to publish media a second time, %v", err)
+	}
+
+	if rsp.Action == ACTION_NEW || rsp.WOFId != 4321 {
+		t.Fatalf("Expected second run to match the existing record, got %s %d", rsp.Action, rsp.WOFId)
+	}
+
+	records, err := filepath.Glob(filepath.Join(repo, "data", "*", "*", "*.geojson"))
+
+	if err != nil {
+		t.Fatalf("Failed to list records, %v", err)
+	}
+
+	if len(records) != 1 {
+		t.Fatalf("Expected a single record, got %v", records)
+	}
+}
+
+func TestPublishMediaLookupMatchesRebuild(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo := t.TempDir()
+
+	// An existing record whose media ID is its media path, as was the case for records published
+	// before media IDs were derived from hashes
+
+	writePublishTestRecord(t, repo, 1234, "media/posts/a.jpg")
+
+	opts := newPublishTestOptions(t, repo, "media/posts/a.jpg", "media/posts/b.jpg")
+
+	template, err := DefaultRecordTemplate()
+
+	if err != nil {
+		t.Fatalf("Failed to create template, %v", err)
+	}
+
+	template.feature, err = sjson.SetBytes(template.feature, "properties.wof:id", 4321)
+
+	if err != nil {
+		t.Fatalf("Failed to assign WOF ID to template, %v", err)
+	}
+
+	opts.Template = template
+
+	lookup_path := filepath.Join(t.TempDir(), "lookup.json")
+
+	l, err := OpenFileLookup(ctx, lookup_path)
+
+	if err != nil {
+		t.Fatalf("Failed to open lookup, %v", err)
+	}
+
+	populate_opts := &PopulateLookupOptions{
+		Lookup:         l,
+		HashIndex:      l.HashIndex(),
+		IteratorURI:    "repo://",
+		IteratorSource: repo,
+	}
+
+	err = PopulateLookupWithOptions(ctx, populate_opts)
+
+	if err != nil {
+		t.Fatalf("Failed to populate lookup, %v", err)
+	}
+
+	opts.Lookup = l
+	opts.HashIndex = l.HashIndex()
+
+	posts := [][]byte{
+		[]byte(`{"path":"media/posts/a.jpg","caption":"Hello #sfo","taken_at":"Nov 26, 2024 4:00 PM"}`),
+		[]byte(`{"path":"media/posts/b.jpg","caption":"Hello again #sfo","taken_at":"Nov 27, 2024 4:00 PM"}`),
+	}
+
+	for _, post := range posts {
+
+		_, err := PublishMediaWithResult(ctx, opts, post)
+
+		if err != nil {
+			t.Fatalf("Failed to publish media, %v", err)
+		}
+	}
+
+	err = l.Save(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to save lookup, %v", err)
+	}
+
+	saved, err := OpenFileLookup(ctx, lookup_path)
+
+	if err != nil {
+		t.Fatalf("Failed to reopen lookup, %v", err)
+	}
+
+	rebuilt := NewMemoryLookup()
+	rebuilt_hashes := NewPerceptualHashIndex()
+
+	populate_opts = &PopulateLookupOptions{
+		Lookup:         rebuilt,
+		HashIndex:      rebuilt_hashes,
+		IteratorURI:    "repo://",
+		IteratorSource: repo,
+	}
+
+	err = PopulateLookupWithOptions(ctx, populate_opts)
+
+	if err != nil {
+		t.Fatalf("Failed to rebuild lookup, %v", err)
+	}
+
+	saved_entries := make(map[string]int64)
+
+	saved.Range(ctx, func(k string, id int64) bool {
+		saved_entries[k] = id
+		return true
+	})
+
+	rebuilt_entries := make(map[string]int64)
+
+	rebuilt.Range(ctx, func(k string, id int64) bool {
+		rebuilt_entries[k] = id
+		return true
+	})
+
+	if fmt.Sprintf("%v", saved_entries) != fmt.Sprintf("%v", rebuilt_entries) {
+		t.Fatalf("Saved lookup %v does not match rebuilt lookup %v", saved_entries, rebuilt_entries)
+	}
+
+	for taken_at, entries := range rebuilt_hashes.entries {
+
+		if len(saved.HashIndex().entries[taken_at]) != len(entries) {
+			t.Fatalf("Saved hash index does not match rebuilt hash index for %s", taken_at)
+		}
+	}
+
+	if len(saved.HashIndex().entries) != len(rebuilt_hashes.entries) {
+		t.Fatalf("Saved hash index does not match rebuilt hash index")
+	}
+}
+
+func TestPublishMediaFollowsSupersededRecord(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo := t.TempDir()
+
+	// Record 1234 has been merged in to record 5678 but the lookup still points to it
+
+	superseded_path := writePublishTestRecord(t, repo, 1234, "legacy123")
+	writePublishTestRecord(t, repo, 5678, "legacy123")
+
+	superseded_body, err := os.ReadFile(superseded_path)
+
+	if err != nil {
+		t.Fatalf("Failed to read record, %v", err)
+	}
+
+	superseded_body, err = sjson.SetBytes(superseded_body, "properties.wof:superseded_by", []int64{5678})
+
+	if err != nil {
+		t.Fatalf("Failed to assign superseded_by, %v", err)
+	}
+
+	err = os.WriteFile(superseded_path, superseded_body, 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write record, %v", err)
+	}
+
+	opts := newPublishTestOptions(t, repo, "media/posts/a.jpg")
+
+	err = opts.Lookup.Store(ctx, "media/posts/a.jpg", 1234)
+
+	if err != nil {
+		t.Fatalf("Failed to store lookup, %v", err)
+	}
+
+	post := []byte(`{"path":"media/posts/a.jpg","caption":"Hello #sfo","taken_at":"Nov 26, 2024 4:00 PM"}`)
+
+	rsp, err := PublishMediaWithResult(ctx, opts, post)
+
+	if err != nil {
+		t.Fatalf("Failed to publish media, %v", err)
+	}
+
+	if rsp.Action != ACTION_UPDATE || rsp.WOFId != 5678 {
+		t.Fatalf("Expected superseding record 5678 to be updated, got %s %d", rsp.Action, rsp.WOFId)
+	}
+
+	body, err := os.ReadFile(superseded_path)
+
+	if err != nil {
+		t.Fatalf("Failed to read record, %v", err)
+	}
+
+	if !bytes.Equal(body, superseded_body) {
+		t.Fatalf("Expected superseded record to be left unchanged")
+	}
+}
@@ -0,0 +1,160 @@
+package publish
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// Action is a string label describing what happened (or would happen) to the WOF record associated with an Instagram post.
+type Action string
+
+// ACTION_NEW indicates that a new WOF record was (or would be) created for an Instagram post.
+const ACTION_NEW Action = "new"
+
+// ACTION_UPDATE indicates that an existing WOF record was (or would be) updated for an Instagram post.
+const ACTION_UPDATE Action = "update"
+
+// ACTION_UNCHANGED indicates that an existing WOF record matches an Instagram post and nothing has changed.
+const ACTION_UNCHANGED Action = "unchanged"
+
+// ACTION_SKIPPED indicates that an Instagram post was not processed because the checkpoint journal records it as
+// already published (or, when only retrying failures, because it did not previously fail).
+const ACTION_SKIPPED Action = "skipped"
+
+// MatchType is a string label describing how an Instagram post was matched to an existing WOF record.
+type MatchType string
+
+// MATCH_MEDIA_ID indicates that an Instagram post was matched to an existing WOF record using its derived media ID.
+const MATCH_MEDIA_ID MatchType = "media_id"
+
+// MATCH_PATH indicates that an Instagram post was matched to an existing WOF record using the (fallback) media path.
+const MATCH_PATH MatchType = "path"
+
+// MATCH_SLIDE indicates that a carousel post was matched to an existing WOF record using the media ID of one of its slides.
+const MATCH_SLIDE MatchType = "slide"
+
+// MATCH_PERCEPTUAL_HASH indicates that an Instagram post was matched to an existing WOF record because its perceptual
+// hash is within a (configurable) Hamming distance of that record's perceptual hash and they were taken in the same minute.
+// These matches should be confirmed by a human.
+const MATCH_PERCEPTUAL_HASH MatchType = "perceptual_hash"
+
+// Result is a struct describing the outcome of publishing a single Instagram post.
+type Result struct {
+	// Path is the relative path of the media file associated with the post.
+	Path string `json:"path"`
+	// MediaId is the (SFO Museum) media ID associated with the post.
+	MediaId string `json:"media_id"`
+	// Action is what happened (or would happen) to the WOF record associated with the post.
+	Action Action `json:"action"`
+	// WOFId is the WOF ID of the record associated with the post. It will be zero for new records in dry-run mode.
+	WOFId int64 `json:"wof_id,omitempty"`
+	// MatchedBy is how the post was matched to an existing WOF record, if at all.
+	MatchedBy MatchType `json:"matched_by,omitempty"`
+	// Distance is the Hamming distance between perceptual hashes for posts matched using `MATCH_PERCEPTUAL_HASH`.
+	Distance int `json:"distance,omitempty"`
+	// Changes is the list of property-level changes for updated records.
+	Changes []*PropertyChange `json:"changes,omitempty"`
+	// DryRun is a boolean flag indicating the post was processed in dry-run mode and nothing was written.
+	DryRun bool `json:"dry_run"`
+}
+
+// Report is a thread-safe collection of `Result` instances produced during a publish run.
+type Report struct {
+	mu      *sync.RWMutex
+	results []*Result
+}
+
+// NewReport returns a new (empty) `Report` instance.
+func NewReport() *Report {
+
+	r := &Report{
+		mu:      new(sync.RWMutex),
+		results: make([]*Result, 0),
+	}
+
+	return r
+}
+
+// Add appends 'result' to 'r'.
+func (r *Report) Add(result *Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, result)
+}
+
+// Results returns the list of `Result` instances in 'r' sorted by path.
+func (r *Report) Results() []*Result {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	results := make([]*Result, len(r.results))
+	copy(results, r.results)
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Path < results[j].Path
+	})
+
+	return results
+}
+
+// WriteJSONLines writes each `Result` in 'r' to 'wr' as a line-separated JSON record.
+func (r *Report) WriteJSONLines(wr io.Writer) error {
+
+	enc := json.NewEncoder(wr)
+
+	for _, result := range r.Results() {
+
+		err := enc.Encode(result)
+
+		if err != nil {
+			return fmt.Errorf("Failed to encode result for %s, %w", result.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// WriteSummary writes a table summarizing the number of posts for each action and match type in 'r' to 'wr'.
+func (r *Report) WriteSummary(wr io.Writer) error {
+
+	counts := make(map[string]int)
+
+	for _, result := range r.Results() {
+
+		k := string(result.Action)
+
+		if result.MatchedBy != "" {
+			k = fmt.Sprintf("%s (matched by %s)", k, result.MatchedBy)
+		}
+
+		counts[k] += 1
+	}
+
+	keys := make([]string, 0)
+
+	for k := range counts {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(wr, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "ACTION\tCOUNT\n")
+
+	total := 0
+
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s\t%d\n", k, counts[k])
+		total += counts[k]
+	}
+
+	fmt.Fprintf(tw, "total\t%d\n", total)
+
+	return tw.Flush()
+}
@@ -0,0 +1,756 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "github.com/sfomuseum/go-sfomuseum-export/v2"
+	"github.com/sfomuseum/go-sfomuseum-instagram/media"
+	sfom_reader "github.com/sfomuseum/go-sfomuseum-reader"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"github.com/whosonfirst/go-whosonfirst-export/v2"
+	wof_writer "github.com/whosonfirst/go-whosonfirst-writer/v3"
+)
+
+// timestampStage appends a "taken" (Unix) timestamp, and local and UTC datetime strings, derived from the post's
+// "taken_at" datetime string (or creation timestamp) and 'opts.Timezone'.
+func timestampStage(ctx context.Context, opts *PublishOptions, state *PostState) error {
+
+	body, err := AppendTakenTimestamps(ctx, state.Body, opts.Timezone)
+
+	if err != nil {
+		return fmt.Errorf("Failed to append taken at timestamp, %w", err)
+	}
+
+	state.Body = body
+	return nil
+}
+
+// hashingStage appends media types and hashes for the post's media file(s).
+func hashingStage(ctx context.Context, opts *PublishOptions, state *PostState) error {
+
+	append_opts := &AppendMediaOptions{
+		Bucket:            opts.MediaBucket,
+		VideoFrameDecoder: opts.VideoFrameDecoder,
+		VideoHashFrames:   opts.VideoHashFrames,
+		Limiter:           opts.MediaLimiter,
+	}
+
+	var body []byte
+	var err error
+
+	if IsCarousel(state.Body) {
+
+		body, err = AppendCarouselHashes(ctx, append_opts, state.Body)
+
+	} else {
+
+		// Media types are determined by inspecting the contents of each file rather than
+		// trusting file extensions (which can be missing or wrong).
+
+		body, err = AppendMediaTypeAndHashes(ctx, append_opts, state.Path, state.Body)
+	}
+
+	if err != nil {
+		state.Logger.Error("Failed to append hashes", "error", err)
+		return fmt.Errorf("Failed to append hashes, %w", err)
+	}
+
+	state.Body = body
+	return nil
+}
+
+// captionStage expands the post's caption.
+func captionStage(ctx context.Context, opts *PublishOptions, state *PostState) error {
+
+	body, err := media.ExpandCaption(ctx, state.Body)
+
+	if err != nil {
+		state.Logger.Error("Failed to expand caption", "error", err)
+		return fmt.Errorf("Failed to expand caption, %w", err)
+	}
+
+	state.Body = body
+	return nil
+}
+
+// mediaIdStage derives the post's media ID, and slide media IDs for carousel posts.
+func mediaIdStage(ctx context.Context, opts *PublishOptions, state *PostState) error {
+
+	// We used to use media_id which is derived from the media file path.
+	// However between Oct 2020 and April 2022 those paths changed from
+	// being something like {HASH}.jpg to {SOME}-{THING}-{SOME}-{THING}.jpg
+	// The former allows us to use {HASH} in the mf.sfom URL.
+
+	// You might be asking yourself: Do we really need media ID? The answer
+	// is yes. More specifically we need something that we can for reliably
+	// de-depuplicating IG posts we've already imported. As stated we originally
+	// thought we could rely on the path of the media file associated with a
+	// post but apparently not (they seem to change).
+
+	// Unfortunately for SFO Museum we can't use the body of the caption either
+	// since we sometimes use the same caption for multiple posts. Nor can we
+	// use caption + taken (or taken at) since many of these posts are posted
+	// automatically by tools like hootsuite so they end up with the same timestamps.
+	// For example:
+
+	// https://raw.githubusercontent.com/sfomuseum-data/sfomuseum-data-socialmedia-instagram/main/data/172/935/502/5/1729355025.geojson?token={TOKEN}
+	// https://raw.githubusercontent.com/sfomuseum-data/sfomuseum-data-socialmedia-instagram/main/data/172/935/502/3/1729355023.geojson?token={TOKEN}
+
+	media_id, err := DeriveMediaId(state.Body, "")
+
+	if err != nil {
+		state.Logger.Error("Failed to derive media ID", "error", err)
+		return fmt.Errorf("Failed to derive media ID, %w", err)
+	}
+
+	body, err := sjson.SetBytes(state.Body, "media_id", media_id)
+
+	if err != nil {
+		state.Logger.Error("Failed to assign media ID", "error", err)
+		return fmt.Errorf("Failed to assign media_id to post, %w", err)
+	}
+
+	slide_ids, err := DeriveSlideMediaIds(body, "")
+
+	if err != nil {
+		state.Logger.Error("Failed to derive slide media IDs", "error", err)
+		return fmt.Errorf("Failed to derive slide media IDs, %w", err)
+	}
+
+	state.Body = body
+	state.MediaId = media_id
+	state.SlideIds = slide_ids
+
+	err = recordJournal(ctx, opts, &JournalEntry{
+		Path:    state.Path,
+		Status:  JOURNAL_STATUS_HASHED,
+		MediaId: media_id,
+	})
+
+	if err != nil {
+		state.Logger.Error("Failed to record journal entry", "error", err)
+		return fmt.Errorf("Failed to record journal entry, %w", err)
+	}
+
+	return nil
+}
+
+// matchStage matches the post to an existing WOF record by media ID, slide media ID, media path or
+// (optionally) perceptual hash.
+func matchStage(ctx context.Context, opts *PublishOptions, state *PostState) error {
+
+	// lookup.go
+
+	pointer, ok := opts.Lookup.Load(ctx, state.MediaId)
+
+	if ok {
+		state.Result.MatchedBy = MATCH_MEDIA_ID
+	}
+
+	// For carousel posts look for any slide whose media ID matches an existing record. This
+	// accounts for Instagram re-encoding some, but not all, of the slides between exports as
+	// well as carousels that were previously published as individual posts.
+
+	if !ok {
+
+		for _, slide_id := range state.SlideIds {
+
+			pointer, ok = opts.Lookup.Load(ctx, slide_id)
+
+			if ok {
+				state.Result.MatchedBy = MATCH_SLIDE
+				break
+			}
+		}
+	}
+
+	// Add path to the file as a fallback because apparently IG does stuff to the
+	// photos between archive runs that causes the percaptual hash to change. Good
+	// times...
+
+	if !ok {
+
+		pointer, ok = opts.Lookup.Load(ctx, state.Path)
+
+		if ok {
+			state.Result.MatchedBy = MATCH_PATH
+		}
+	}
+
+	// If there's still no match look for existing records, taken in the same minute, whose
+	// perceptual hash is close enough to this post's perceptual hash. These matches are logged
+	// (and reported) so that a human can confirm them. Videos use their video hash.
+
+	phash_rsp := gjson.GetBytes(state.Body, "perceptual_hash")
+
+	if !phash_rsp.Exists() {
+		phash_rsp = gjson.GetBytes(state.Body, "video_hash")
+	}
+
+	if !ok && opts.HashIndex != nil && opts.FuzzyThreshold > 0 && phash_rsp.Exists() {
+
+		taken_at := gjson.GetBytes(state.Body, "taken_at").String()
+
+		wof_id, distance, fuzzy_ok, err := opts.HashIndex.Match(ctx, taken_at, phash_rsp.String(), opts.FuzzyThreshold)
+
+		if err != nil {
+			state.Logger.Error("Failed to match perceptual hash", "error", err)
+			return fmt.Errorf("Failed to match perceptual hash, %w", err)
+		}
+
+		if fuzzy_ok {
+			state.Logger.Warn("Matched post using perceptual hash, please confirm", "id", wof_id, "distance", distance)
+			pointer = wof_id
+			ok = true
+			state.Result.MatchedBy = MATCH_PERCEPTUAL_HASH
+			state.Result.Distance = distance
+		}
+	}
+
+	if ok {
+		state.Matched = true
+		state.WOFId = pointer
+	}
+
+	err := recordJournal(ctx, opts, &JournalEntry{
+		Path:    state.Path,
+		Status:  JOURNAL_STATUS_MATCHED,
+		MediaId: state.MediaId,
+		WOFId:   state.WOFId,
+	})
+
+	if err != nil {
+		state.Logger.Error("Failed to record journal entry", "error", err)
+		return fmt.Errorf("Failed to record journal entry, %w", err)
+	}
+
+	return nil
+}
+
+// readStage reads the existing WOF record the post was matched to or creates a new record from 'opts.Template'.
+func readStage(ctx context.Context, opts *PublishOptions, state *PostState) error {
+
+	if !state.Matched {
+
+		template := opts.Template
+
+		if template == nil {
+
+			t, err := DefaultRecordTemplate()
+
+			if err != nil {
+				state.Logger.Error("Failed to create default record template", "error", err)
+				return err
+			}
+
+			template = t
+		}
+
+		new_record, err := template.NewRecord(ctx)
+
+		if err != nil {
+			state.Logger.Error("Failed to create new record", "error", err)
+			return err
+		}
+
+		state.Record = new_record
+		return nil
+	}
+
+	wof_id := state.WOFId
+
+	wof_body, err := sfom_reader.LoadBytesFromID(ctx, opts.Reader, wof_id)
+
+	if err != nil {
+		return err
+	}
+
+	// Records that have been merged in to another record (see merge.go) are superseded by it. Lookups
+	// (or perceptual hash indices) that predate the merge may still point to them so follow the chain of
+	// wof:superseded_by properties to the record that should be updated instead.
+
+	seen := map[int64]bool{
+		wof_id: true,
+	}
+
+	for {
+
+		superseded_by := gjson.GetBytes(wof_body, "properties.wof:superseded_by").Array()
+
+		if len(superseded_by) == 0 {
+			break
+		}
+
+		if len(superseded_by) > 1 {
+			state.Logger.Error("Matched record has been superseded by multiple records", "id", wof_id)
+			return fmt.Errorf("Record %d has been superseded by multiple records", wof_id)
+		}
+
+		next_id := superseded_by[0].Int()
+
+		if seen[next_id] {
+			state.Logger.Error("Matched record has a circular chain of superseded records", "id", wof_id)
+			return fmt.Errorf("Record %d has a circular chain of superseded records", wof_id)
+		}
+
+		seen[next_id] = true
+
+		state.Logger.Warn("Matched record has been superseded", "id", wof_id, "superseded_by", next_id)
+
+		wof_body, err = sfom_reader.LoadBytesFromID(ctx, opts.Reader, next_id)
+
+		if err != nil {
+			return err
+		}
+
+		wof_id = next_id
+	}
+
+	state.WOFId = wof_id
+	state.Record = wof_body
+	state.ExistingRecord = wof_body
+
+	state.Result.WOFId = wof_id
+	state.Result.Action = ACTION_UPDATE
+
+	// See this? We are going to ensure we don't accidentally overwrite an
+	// existing media ID. For example the inputs for deriving a media ID
+	// changed between 202010 and 202204 to reflect changes IG made to their
+	// exports.
+
+	id_rsp := gjson.GetBytes(wof_body, "properties.instagram:post.media_id")
+
+	body, err := sjson.SetBytes(state.Body, "media_id", id_rsp.String())
+
+	if err != nil {
+		state.Logger.Error("Failed to assign media ID", "error", err)
+		return fmt.Errorf("Failed to assign media_id to post, %w", err)
+	}
+
+	state.Body = body
+	return nil
+}
+
+// recordStage assigns the properties derived from the post to the WOF record.
+func recordStage(ctx context.Context, opts *PublishOptions, state *PostState) error {
+
+	logger := state.Logger
+	wof_record := state.Record
+
+	taken_rsp := gjson.GetBytes(state.Body, "taken")
+
+	if !taken_rsp.Exists() {
+		logger.Error("Missing taken property")
+		return fmt.Errorf("Missing created timestamp")
+	}
+
+	taken := taken_rsp.Int()
+
+	taken_t := time.Unix(taken, 0)
+	taken_str := taken_t.Format(time.RFC3339)
+
+	// Prefer the local time (with offset) when the source timezone is known
+
+	local_rsp := gjson.GetBytes(state.Body, "taken_at_local")
+
+	if local_rsp.Exists() {
+		taken_str = local_rsp.String()
+	}
+
+	wof_record, err := sjson.SetBytes(wof_record, "properties.wof:created", taken_t.Unix())
+
+	if err != nil {
+		return err
+	}
+
+	wof_record, err = sjson.SetBytes(wof_record, "properties.edtf:inception", taken_str)
+
+	if err != nil {
+		logger.Error("Failed to assign inception", "error", err)
+		return err
+	}
+
+	wof_record, err = sjson.SetBytes(wof_record, "properties.edtf:cessation", taken_str)
+
+	if err != nil {
+		logger.Error("Failed to assign cessation", "error", err)
+		return err
+	}
+
+	excerpt_rsp := gjson.GetBytes(state.Body, "caption.excerpt")
+
+	if !excerpt_rsp.Exists() {
+		logger.Error("Failed to assign caption, missing excerpt")
+		return fmt.Errorf("Missing caption.excerpt")
+	}
+
+	wof_name := fmt.Sprintf("%s..", excerpt_rsp.String())
+	wof_record, err = sjson.SetBytes(wof_record, "properties.wof:name", wof_name)
+
+	if err != nil {
+		logger.Error("Failed to assign name", "error", err)
+		return err
+	}
+
+	var post interface{}
+
+	err = json.Unmarshal(state.Body, &post)
+
+	if err != nil {
+		logger.Error("Failed to unmarshal post", "error", err)
+		return fmt.Errorf("Failed to unmarshal record, %w", err)
+	}
+
+	wof_record, err = sjson.SetBytes(wof_record, "properties.instagram:post", post)
+
+	if err != nil {
+		logger.Error("Failed to assign post properties", "error", err)
+		return fmt.Errorf("Failed to append post, %w", err)
+	}
+
+	state.Record = wof_record
+	state.Result.MediaId = gjson.GetBytes(state.Body, "media_id").String()
+
+	return nil
+}
+
+// uploadStage uploads the post's original media file, and resized derivatives, to 'opts.DerivativesBucket' and
+// assigns their sizes to the record. Files are uploaded using the media ID written to the record (which, for matched
+// records, is the record's existing media ID). The remaining slides of carousel posts are uploaded using their slide
+// media IDs and their sizes assigned to the record's `instagram:slide_sizes` property. Uploads are skipped in dry-run
+// mode and for media files whose sizes are already listed in the existing record.
+func uploadStage(ctx context.Context, opts *PublishOptions, state *PostState) error {
+
+	if opts.DerivativesBucket == nil || opts.DryRun {
+		return nil
+	}
+
+	sizes := opts.DerivativeSizes
+
+	if sizes == nil {
+		sizes = DEFAULT_DERIVATIVE_SIZES
+	}
+
+	media_id := state.Result.MediaId
+	mime_type := gjson.GetBytes(state.Body, "mime_type").String()
+
+	is_uploaded := false
+
+	if state.ExistingRecord != nil {
+
+		existing_id := gjson.GetBytes(state.ExistingRecord, "properties.instagram:post.media_id").String()
+		existing_sizes := gjson.GetBytes(state.ExistingRecord, "properties."+SIZES_PROPERTY)
+
+		is_uploaded = existing_id == media_id && isUploaded(existing_sizes, mime_type, sizes)
+	}
+
+	if is_uploaded {
+		state.Logger.Debug("Media already uploaded, skipping")
+	} else {
+
+		uploaded, err := uploadPostMedia(ctx, opts, sizes, media_id, state.Path, mime_type)
+
+		if err != nil {
+			state.Logger.Error("Failed to upload media", "error", err)
+			return fmt.Errorf("Failed to upload media, %w", err)
+		}
+
+		wof_record, err := sjson.SetBytes(state.Record, "properties."+SIZES_PROPERTY, uploaded)
+
+		if err != nil {
+			state.Logger.Error("Failed to assign sizes", "error", err)
+			return fmt.Errorf("Failed to assign sizes, %w", err)
+		}
+
+		state.Record = wof_record
+	}
+
+	if !IsCarousel(state.Body) {
+		return nil
+	}
+
+	// The first slide is the post's own media file (above)
+
+	slide_sizes := make(map[string]interface{})
+	changed := false
+
+	for i, m := range gjson.GetBytes(state.Body, "media").Array() {
+
+		if i == 0 || i >= len(state.SlideIds) {
+			continue
+		}
+
+		slide_id := state.SlideIds[i]
+		slide_mime_type := m.Get("mime_type").String()
+
+		if state.ExistingRecord != nil {
+
+			existing_sizes := gjson.GetBytes(state.ExistingRecord, "properties."+SLIDE_SIZES_PROPERTY+"."+slide_id)
+
+			if isUploaded(existing_sizes, slide_mime_type, sizes) {
+				slide_sizes[slide_id] = json.RawMessage(existing_sizes.Raw)
+				continue
+			}
+		}
+
+		uploaded, err := uploadPostMedia(ctx, opts, sizes, slide_id, m.Get("path").String(), slide_mime_type)
+
+		if err != nil {
+			state.Logger.Error("Failed to upload slide media", "slide", i, "error", err)
+			return fmt.Errorf("Failed to upload media for slide %d, %w", i, err)
+		}
+
+		slide_sizes[slide_id] = uploaded
+		changed = true
+	}
+
+	if !changed {
+		state.Logger.Debug("Slide media already uploaded, skipping")
+		return nil
+	}
+
+	wof_record, err := sjson.SetBytes(state.Record, "properties."+SLIDE_SIZES_PROPERTY, slide_sizes)
+
+	if err != nil {
+		state.Logger.Error("Failed to assign slide sizes", "error", err)
+		return fmt.Errorf("Failed to assign slide sizes, %w", err)
+	}
+
+	state.Record = wof_record
+	return nil
+}
+
+// isUploaded returns a boolean value indicating whether 'existing_sizes', the sizes recorded for a media file with
+// MIME type 'mime_type', include the original and (for images that can be decoded) every derivative in 'sizes'.
+func isUploaded(existing_sizes gjson.Result, mime_type string, sizes []*DerivativeSize) bool {
+
+	if !existing_sizes.Get(SIZE_ORIGINAL).Exists() {
+		return false
+	}
+
+	if !perceptual_mimetypes[mime_type] {
+		return true
+	}
+
+	for _, sz := range sizes {
+
+		if !existing_sizes.Get(sz.Label).Exists() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// uploadPostMedia reads the media file at 'path' from 'opts.MediaBucket' and uploads it, and resized derivatives,
+// to 'opts.DerivativesBucket' using 'media_id'.
+func uploadPostMedia(ctx context.Context, opts *PublishOptions, sizes []*DerivativeSize, media_id string, path string, mime_type string) (map[string]*MediaSize, error) {
+
+	read_opts := &AppendMediaOptions{
+		Bucket:  opts.MediaBucket,
+		Limiter: opts.MediaLimiter,
+	}
+
+	body, err := readMedia(ctx, read_opts, path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	upload_opts := &UploadDerivativesOptions{
+		Bucket: opts.DerivativesBucket,
+		Sizes:  sizes,
+	}
+
+	return UploadDerivatives(ctx, upload_opts, media_id, mime_type, body)
+}
+
+// diffStage compares an updated WOF record to the existing record, stopping the pipeline if nothing has changed.
+func diffStage(ctx context.Context, opts *PublishOptions, state *PostState) error {
+
+	// Only write records that have actually changed so that we don't churn wof:lastmodified
+	// (and produce noisy commits in the data repo) for every post in every export.
+
+	if state.ExistingRecord == nil {
+		return nil
+	}
+
+	changes, err := DiffRecords(state.ExistingRecord, state.Record)
+
+	if err != nil {
+		state.Logger.Error("Failed to compare records", "error", err)
+		return fmt.Errorf("Failed to compare records, %w", err)
+	}
+
+	if len(changes) == 0 {
+
+		state.Logger.Debug("Record is unchanged, skipping", "id", state.Result.WOFId)
+		state.Result.Action = ACTION_UNCHANGED
+		state.Done = true
+
+		err = recordJournal(ctx, opts, &JournalEntry{
+			Path:    state.Path,
+			Status:  JOURNAL_STATUS_UNCHANGED,
+			MediaId: state.Result.MediaId,
+			WOFId:   state.Result.WOFId,
+		})
+
+		if err != nil {
+			state.Logger.Error("Failed to record journal entry", "error", err)
+			return fmt.Errorf("Failed to record journal entry, %w", err)
+		}
+
+		return nil
+	}
+
+	state.Result.Changes = changes
+
+	if opts.LogChanges {
+
+		for _, ch := range changes {
+			state.Logger.Info("Property changed", "id", state.Result.WOFId, "property", ch.Property, "old", ch.Old, "new", ch.New)
+		}
+	}
+
+	return nil
+}
+
+// provenanceStage assigns the provenance of the export bundle (defined by 'opts.Bundle') to the record, keeping a
+// history of earlier bundles that updated it.
+func provenanceStage(ctx context.Context, opts *PublishOptions, state *PostState) error {
+
+	p := &Provenance{
+		Bundle:      opts.Bundle,
+		ExportDate:  ExportDateFromBundleName(opts.Bundle),
+		SourcePath:  state.Path,
+		ToolVersion: ToolVersion(),
+	}
+
+	wof_record, err := AppendProvenance(ctx, state.Record, p)
+
+	if err != nil {
+		state.Logger.Error("Failed to assign provenance", "error", err)
+		return err
+	}
+
+	state.Record = wof_record
+	return nil
+}
+
+// writeStage writes the WOF record (unless 'opts.DryRun' is true) and updates the lookup and hash index.
+func writeStage(ctx context.Context, opts *PublishOptions, state *PostState) error {
+
+	if opts.DryRun {
+		state.Logger.Debug("Dry run, skip writing record", "action", state.Result.Action, "id", state.Result.WOFId)
+		return nil
+	}
+
+	release, err := opts.WriteLimiter.Acquire(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	// This is the equivalent of sfom_writer.WriteBytes but we hold on to the exported record so we
+	// know what its wof:lastmodified property was set to.
+
+	ex, err := export.NewExporter(ctx, "sfomuseum://")
+
+	if err != nil {
+		release()
+		return fmt.Errorf("Failed to create SFO Museum exporter, %w", err)
+	}
+
+	record_ex := &recordingExporter{
+		Exporter: ex,
+	}
+
+	wof_id, err := wof_writer.WriteBytesWithExporter(ctx, opts.Writer, record_ex, state.Record)
+
+	release()
+
+	if err != nil {
+		state.Logger.Error("Failed to write new record", "error", err)
+		return fmt.Errorf("Failed to write record, %w", err)
+	}
+
+	state.WOFId = wof_id
+	state.Result.WOFId = wof_id
+
+	// Update the lookup so that it reflects the record we just wrote (for example
+	// when it is saved to disk for use in future runs). This uses the same keys that
+	// would be derived for the record if the lookup were rebuilt.
+
+	index_opts := &PopulateLookupOptions{
+		Lookup:    opts.Lookup,
+		HashIndex: opts.HashIndex,
+	}
+
+	err = indexRecord(ctx, index_opts, state.Path, record_ex.body)
+
+	if err != nil {
+		state.Logger.Error("Failed to update lookup", "error", err)
+		return fmt.Errorf("Failed to update lookup, %w", err)
+	}
+
+	if opts.AuditLog != nil {
+
+		audit_e := &AuditEntry{
+			WOFId:        wof_id,
+			Action:       state.Result.Action,
+			MediaId:      state.Result.MediaId,
+			Path:         state.Path,
+			Bundle:       opts.Bundle,
+			LastModified: gjson.GetBytes(record_ex.body, "properties.wof:lastmodified").Int(),
+			Commit:       opts.Commit,
+		}
+
+		if state.ExistingRecord != nil {
+			audit_e.PreviousLastModified = gjson.GetBytes(state.ExistingRecord, "properties.wof:lastmodified").Int()
+		}
+
+		err = opts.AuditLog.Record(ctx, audit_e)
+
+		if err != nil {
+			state.Logger.Error("Failed to record audit log entry", "error", err)
+			return fmt.Errorf("Failed to record audit log entry, %w", err)
+		}
+	}
+
+	err = recordJournal(ctx, opts, &JournalEntry{
+		Path:    state.Path,
+		Status:  JOURNAL_STATUS_WRITTEN,
+		MediaId: state.Result.MediaId,
+		WOFId:   wof_id,
+	})
+
+	if err != nil {
+		state.Logger.Error("Failed to record journal entry", "error", err)
+		return fmt.Errorf("Failed to record journal entry, %w", err)
+	}
+
+	return nil
+}
+
+// recordingExporter is an `export.Exporter` which keeps a copy of the last record it exported.
+type recordingExporter struct {
+	export.Exporter
+	body []byte
+}
+
+// Export exports 'feature' and keeps a copy of the result.
+func (ex *recordingExporter) Export(ctx context.Context, feature []byte) ([]byte, error) {
+
+	body, err := ex.Exporter.Export(ctx, feature)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ex.body = body
+	return body, nil
+}
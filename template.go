@@ -0,0 +1,172 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	sfom_reader "github.com/sfomuseum/go-sfomuseum-reader"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"github.com/whosonfirst/go-reader"
+)
+
+// DEFAULT_REPO is the default value of the `wof:repo` property assigned to new records.
+const DEFAULT_REPO string = "sfomuseum-data-socialmedia-instagram"
+
+// DEFAULT_PARENT_ID is the WOF ID of the default parent (the Null Terminal, in sfomuseum-data-architecture) assigned to new records.
+const DEFAULT_PARENT_ID int64 = 1159160869
+
+// RecordTemplate is a struct containing the GeoJSON Feature used as the starting point for new WOF records.
+type RecordTemplate struct {
+	feature []byte
+}
+
+// DefaultRecordTemplate returns a `RecordTemplate` instance parented by the Null Terminal and stored in the
+// sfomuseum-data-socialmedia-instagram repository. These details are hard-coded and may drift from the
+// architecture data; use `NewRecordTemplateFromParent` to derive them from source.
+func DefaultRecordTemplate() (*RecordTemplate, error) {
+
+	// Null Terminal
+	// https://raw.githubusercontent.com/sfomuseum-data/sfomuseum-data-architecture/master/data/115/916/086/9/1159160869.geojson
+
+	lat := 37.616356
+	lon := -122.386166
+
+	hier := []map[string]int64{
+		{
+			"building_id":      1159160869,
+			"campus_id":        102527513,
+			"continent_id":     102191575,
+			"country_id":       85633793,
+			"county_id":        102087579,
+			"locality_id":      85922583,
+			"neighbourhood_id": -1,
+			"region_id":        85688637,
+		},
+	}
+
+	return newRecordTemplate(DEFAULT_PARENT_ID, hier, "US", lat, lon, DEFAULT_REPO)
+}
+
+// NewRecordTemplateFromParent returns a `RecordTemplate` instance whose parent ID, hierarchy, country and centroid
+// are derived from the WOF record 'parent_id' read from 'r'. New records will be assigned a `wof:repo` property
+// of 'repo'.
+func NewRecordTemplateFromParent(ctx context.Context, r reader.Reader, parent_id int64, repo string) (*RecordTemplate, error) {
+
+	body, err := sfom_reader.LoadBytesFromID(ctx, r, parent_id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load parent record %d, %w", parent_id, err)
+	}
+
+	hier_rsp := gjson.GetBytes(body, "properties.wof:hierarchy")
+
+	if !hier_rsp.Exists() {
+		return nil, fmt.Errorf("Parent record %d is missing wof:hierarchy property", parent_id)
+	}
+
+	var hier []map[string]int64
+
+	err = json.Unmarshal([]byte(hier_rsp.Raw), &hier)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal hierarchy for parent record %d, %w", parent_id, err)
+	}
+
+	// Prefer label centroids, falling back to geometry centroids
+
+	var lat gjson.Result
+	var lon gjson.Result
+
+	for _, prefix := range []string{"lbl", "geom"} {
+
+		lat = gjson.GetBytes(body, fmt.Sprintf("properties.%s:latitude", prefix))
+		lon = gjson.GetBytes(body, fmt.Sprintf("properties.%s:longitude", prefix))
+
+		if lat.Exists() && lon.Exists() {
+			break
+		}
+	}
+
+	if !lat.Exists() || !lon.Exists() {
+		return nil, fmt.Errorf("Parent record %d is missing a centroid", parent_id)
+	}
+
+	country := gjson.GetBytes(body, "properties.wof:country").String()
+
+	return newRecordTemplate(parent_id, hier, country, lat.Float(), lon.Float(), repo)
+}
+
+// NewRecordTemplateFromReader returns a `RecordTemplate` instance derived from the GeoJSON Feature contained in 'r'.
+// The Feature is used as-is (minus any `wof:id` property) so it is expected to define all the necessary properties,
+// including `wof:parent_id`, `wof:hierarchy` and `wof:repo`.
+func NewRecordTemplateFromReader(ctx context.Context, r io.Reader) (*RecordTemplate, error) {
+
+	body, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read template, %w", err)
+	}
+
+	for _, path := range []string{"type", "properties", "geometry", "properties.wof:parent_id", "properties.wof:hierarchy", "properties.wof:repo"} {
+
+		if !gjson.GetBytes(body, path).Exists() {
+			return nil, fmt.Errorf("Template is missing '%s' property", path)
+		}
+	}
+
+	body, err = sjson.DeleteBytes(body, "properties.wof:id")
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to remove wof:id property from template, %w", err)
+	}
+
+	t := &RecordTemplate{
+		feature: body,
+	}
+
+	return t, nil
+}
+
+// NewRecord returns a new GeoJSON Feature derived from 't'.
+func (t *RecordTemplate) NewRecord(ctx context.Context) ([]byte, error) {
+	feature := make([]byte, len(t.feature))
+	copy(feature, t.feature)
+	return feature, nil
+}
+
+func newRecordTemplate(parent_id int64, hier []map[string]int64, country string, lat float64, lon float64, repo string) (*RecordTemplate, error) {
+
+	geom := map[string]interface{}{
+		"type":        "Point",
+		"coordinates": [2]float64{lon, lat},
+	}
+
+	feature := map[string]interface{}{
+		"type": "Feature",
+		"properties": map[string]interface{}{
+			"sfomuseum:placetype": "instagram",
+			"src:geom":            "sfomuseum",
+			"wof:country":         country,
+			"wof:parent_id":       parent_id,
+			"wof:placetype":       "custom",
+			"wof:repo":            repo,
+			"wof:hierarchy":       hier,
+		},
+		"geometry": geom,
+	}
+
+	enc_feature, err := json.Marshal(feature)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal template, %w", err)
+	}
+
+	t := &RecordTemplate{
+		feature: enc_feature,
+	}
+
+	return t, nil
+}
@@ -0,0 +1,214 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+)
+
+func TestDefaultRecordTemplate(t *testing.T) {
+
+	ctx := context.Background()
+
+	template, err := DefaultRecordTemplate()
+
+	if err != nil {
+		t.Fatalf("Failed to create template, %v", err)
+	}
+
+	body, err := template.NewRecord(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to create record, %v", err)
+	}
+
+	tests := map[string]string{
+		"type":                                   "Feature",
+		"geometry.type":                          "Point",
+		"geometry.coordinates.0":                 "-122.386166",
+		"geometry.coordinates.1":                 "37.616356",
+		"properties.wof:parent_id":               fmt.Sprintf("%d", DEFAULT_PARENT_ID),
+		"properties.wof:repo":                    DEFAULT_REPO,
+		"properties.wof:country":                 "US",
+		"properties.wof:placetype":               "custom",
+		"properties.sfomuseum:placetype":         "instagram",
+		"properties.src:geom":                    "sfomuseum",
+		"properties.wof:hierarchy.0.building_id": fmt.Sprintf("%d", DEFAULT_PARENT_ID),
+		"properties.wof:hierarchy.0.campus_id":   "102527513",
+	}
+
+	for path, expected := range tests {
+
+		v := gjson.GetBytes(body, path).String()
+
+		if v != expected {
+			t.Fatalf("Expected %s to be %s, got '%s'", path, expected, v)
+		}
+	}
+
+	if gjson.GetBytes(body, "properties.wof:id").Exists() {
+		t.Fatalf("Expected template to not have a wof:id property")
+	}
+
+	// Records are copies of the template
+
+	body[0] = ' '
+
+	body2, err := template.NewRecord(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to create second record, %v", err)
+	}
+
+	if body2[0] != '{' {
+		t.Fatalf("Expected modifying a record to not modify the template")
+	}
+}
+
+func TestNewRecordTemplateFromParent(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo := t.TempDir()
+
+	parents := map[int64]string{
+		// Label centroids are preferred over geometry centroids
+		1: `{"wof:id":1,"wof:country":"US","wof:hierarchy":[{"building_id":1,"campus_id":102527513,"country_id":85633793}],"lbl:latitude":37.1,"lbl:longitude":-122.1,"geom:latitude":37.2,"geom:longitude":-122.2}`,
+		2: `{"wof:id":2,"wof:country":"US","wof:hierarchy":[{"building_id":2,"campus_id":102527513,"country_id":85633793}],"geom:latitude":37.2,"geom:longitude":-122.2}`,
+		3: `{"wof:id":3,"wof:country":"US","lbl:latitude":37.1,"lbl:longitude":-122.1}`,
+		4: `{"wof:id":4,"wof:country":"US","wof:hierarchy":[{"building_id":4}]}`,
+	}
+
+	for id, props := range parents {
+
+		rel_path, err := uri.Id2RelPath(id)
+
+		if err != nil {
+			t.Fatalf("Failed to derive path for %d, %v", id, err)
+		}
+
+		record_path := filepath.Join(repo, "data", rel_path)
+
+		err = os.MkdirAll(filepath.Dir(record_path), 0755)
+
+		if err != nil {
+			t.Fatalf("Failed to create data directory, %v", err)
+		}
+
+		body := fmt.Sprintf(`{"type":"Feature","geometry":{"type":"Point","coordinates":[-122.2,37.2]},"properties":%s}`, props)
+
+		err = os.WriteFile(record_path, []byte(body), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write record, %v", err)
+		}
+	}
+
+	r, err := reader.NewReader(ctx, "repo://"+repo)
+
+	if err != nil {
+		t.Fatalf("Failed to create reader, %v", err)
+	}
+
+	tests := map[int64][2]float64{
+		1: {-122.1, 37.1},
+		2: {-122.2, 37.2},
+	}
+
+	for parent_id, coords := range tests {
+
+		template, err := NewRecordTemplateFromParent(ctx, r, parent_id, "sfomuseum-data-example")
+
+		if err != nil {
+			t.Fatalf("Failed to create template from parent %d, %v", parent_id, err)
+		}
+
+		body, err := template.NewRecord(ctx)
+
+		if err != nil {
+			t.Fatalf("Failed to create record, %v", err)
+		}
+
+		if gjson.GetBytes(body, "properties.wof:parent_id").Int() != parent_id {
+			t.Fatalf("Unexpected parent ID for parent %d: %s", parent_id, gjson.GetBytes(body, "properties.wof:parent_id").Raw)
+		}
+
+		if gjson.GetBytes(body, "properties.wof:hierarchy.0.building_id").Int() != parent_id {
+			t.Fatalf("Expected hierarchy to be inherited from parent %d, got %s", parent_id, gjson.GetBytes(body, "properties.wof:hierarchy").Raw)
+		}
+
+		if gjson.GetBytes(body, "properties.wof:hierarchy.0.campus_id").Int() != 102527513 {
+			t.Fatalf("Expected hierarchy to be inherited from parent %d, got %s", parent_id, gjson.GetBytes(body, "properties.wof:hierarchy").Raw)
+		}
+
+		if gjson.GetBytes(body, "properties.wof:country").String() != "US" {
+			t.Fatalf("Unexpected country for parent %d", parent_id)
+		}
+
+		if gjson.GetBytes(body, "properties.wof:repo").String() != "sfomuseum-data-example" {
+			t.Fatalf("Unexpected repo for parent %d", parent_id)
+		}
+
+		lon := gjson.GetBytes(body, "geometry.coordinates.0").Float()
+		lat := gjson.GetBytes(body, "geometry.coordinates.1").Float()
+
+		if lon != coords[0] || lat != coords[1] {
+			t.Fatalf("Unexpected coordinates for parent %d: %f, %f", parent_id, lon, lat)
+		}
+	}
+
+	failures := map[int64]string{
+		3: "missing wof:hierarchy",
+		4: "missing a centroid",
+		5: "Failed to load parent record",
+	}
+
+	for parent_id, expected := range failures {
+
+		_, err := NewRecordTemplateFromParent(ctx, r, parent_id, DEFAULT_REPO)
+
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected '%s' error for parent %d, got %v", expected, parent_id, err)
+		}
+	}
+}
+
+func TestNewRecordTemplateFromReader(t *testing.T) {
+
+	ctx := context.Background()
+
+	feature := `{"type":"Feature","geometry":{"type":"Point","coordinates":[-122.2,37.2]},"properties":{"wof:id":1234,"wof:parent_id":1,"wof:hierarchy":[{"building_id":1}],"wof:repo":"sfomuseum-data-example"}}`
+
+	template, err := NewRecordTemplateFromReader(ctx, strings.NewReader(feature))
+
+	if err != nil {
+		t.Fatalf("Failed to create template, %v", err)
+	}
+
+	body, err := template.NewRecord(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to create record, %v", err)
+	}
+
+	if gjson.GetBytes(body, "properties.wof:id").Exists() {
+		t.Fatalf("Expected wof:id property to be removed from template")
+	}
+
+	if gjson.GetBytes(body, "properties.wof:repo").String() != "sfomuseum-data-example" {
+		t.Fatalf("Unexpected repo: %s", gjson.GetBytes(body, "properties.wof:repo").String())
+	}
+
+	_, err = NewRecordTemplateFromReader(ctx, strings.NewReader(`{"type":"Feature","geometry":{"type":"Point","coordinates":[-122.2,37.2]},"properties":{"wof:parent_id":1}}`))
+
+	if err == nil {
+		t.Fatalf("Expected template without wof:hierarchy property to fail")
+	}
+}
@@ -0,0 +1,79 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sfomuseum/go-sfomuseum-instagram/media"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// AppendTakenTimestamps appends a "taken" (Unix) timestamp to 'body' along with "taken_at_local" (RFC3339, with
+// the offset of 'loc'), "taken_at_utc" (RFC3339) and "timezone" properties. If 'body' has a "creation_timestamp"
+// property (derived from an Instagram "posts" JSON file) it is used as the authoritative time. Otherwise the
+// "taken_at" datetime string, which Instagram records as a wall-clock time without a zone, is interpreted as a
+// wall-clock time in 'loc'. Wall-clock times which are ambiguous or skipped because of daylight saving time
+// transitions are resolved as described in `time.Date`. The "taken_at" property itself is never modified so media
+// IDs derived from it remain the same regardless of 'loc'.
+//
+// If 'loc' is nil and there is no "creation_timestamp" property the legacy behaviour of interpreting "taken_at" as
+// a UTC time is used and no other properties are appended.
+func AppendTakenTimestamps(ctx context.Context, body []byte, loc *time.Location) ([]byte, error) {
+
+	ct_rsp := gjson.GetBytes(body, "creation_timestamp")
+
+	if loc == nil && !ct_rsp.Exists() {
+		return media.AppendTakenAtTimestamp(ctx, body)
+	}
+
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	var t_local time.Time
+
+	if ct_rsp.Exists() {
+
+		t_local = time.Unix(ct_rsp.Int(), 0).In(loc)
+
+	} else {
+
+		taken_rsp := gjson.GetBytes(body, "taken_at")
+
+		if !taken_rsp.Exists() {
+			return nil, fmt.Errorf("Missing taken_at property")
+		}
+
+		wall, err := media.ParseTime(taken_rsp.String())
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse taken value (%s), %w", taken_rsp.String(), err)
+		}
+
+		t_local = time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc)
+	}
+
+	t_utc := t_local.UTC()
+
+	updates := map[string]interface{}{
+		"taken":          t_utc.Unix(),
+		"taken_at_local": t_local.Format(time.RFC3339),
+		"taken_at_utc":   t_utc.Format(time.RFC3339),
+		"timezone":       loc.String(),
+	}
+
+	for k, v := range updates {
+
+		var err error
+
+		body, err = sjson.SetBytes(body, k, v)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to assign %s property, %w", k, err)
+		}
+	}
+
+	return body, nil
+}
@@ -0,0 +1,87 @@
+package publish
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestAppendTakenTimestamps(t *testing.T) {
+
+	ctx := context.Background()
+
+	la, err := time.LoadLocation(DEFAULT_TIMEZONE)
+
+	if err != nil {
+		t.Fatalf("Failed to load timezone, %v", err)
+	}
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+
+	if err != nil {
+		t.Fatalf("Failed to load timezone, %v", err)
+	}
+
+	body := []byte(`{"taken_at":"Jul 4, 2021 1:30 PM","perceptual_hash":"p:8040205408542205","path":"media/posts/202107/a.jpg"}`)
+
+	legacy_id, err := DeriveMediaId(body, "")
+
+	if err != nil {
+		t.Fatalf("Failed to derive media ID, %v", err)
+	}
+
+	la_body, err := AppendTakenTimestamps(ctx, body, la)
+
+	if err != nil {
+		t.Fatalf("Failed to append timestamps, %v", err)
+	}
+
+	if gjson.GetBytes(la_body, "taken_at_utc").String() != "2021-07-04T20:30:00Z" {
+		t.Fatalf("Unexpected UTC time: %s", gjson.GetBytes(la_body, "taken_at_utc").String())
+	}
+
+	if gjson.GetBytes(la_body, "taken_at_local").String() != "2021-07-04T13:30:00-07:00" {
+		t.Fatalf("Unexpected local time: %s", gjson.GetBytes(la_body, "taken_at_local").String())
+	}
+
+	if gjson.GetBytes(la_body, "taken").Int() != 1625430600 {
+		t.Fatalf("Unexpected taken timestamp: %d", gjson.GetBytes(la_body, "taken").Int())
+	}
+
+	// Posts JSON files have an authoritative creation timestamp
+
+	ct_body := []byte(`{"taken_at":"Jul 4, 2021 1:30 PM","creation_timestamp":1625430600}`)
+
+	ct_body, err = AppendTakenTimestamps(ctx, ct_body, tokyo)
+
+	if err != nil {
+		t.Fatalf("Failed to append timestamps, %v", err)
+	}
+
+	if gjson.GetBytes(ct_body, "taken_at_local").String() != "2021-07-05T05:30:00+09:00" {
+		t.Fatalf("Unexpected local time: %s", gjson.GetBytes(ct_body, "taken_at_local").String())
+	}
+
+	// Media IDs do not depend on the timezone
+
+	for _, loc := range []*time.Location{nil, la, tokyo} {
+
+		tz_body, err := AppendTakenTimestamps(ctx, body, loc)
+
+		if err != nil {
+			t.Fatalf("Failed to append timestamps, %v", err)
+		}
+
+		media_id, err := DeriveMediaId(tz_body, "")
+
+		if err != nil {
+			t.Fatalf("Failed to derive media ID, %v", err)
+		}
+
+		if media_id != legacy_id {
+			t.Fatalf("Unexpected media ID for %v: %s", loc, media_id)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/sfomuseum/go-sfomuseum-instagram/hash"
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/iterator"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// MediaProblemType is a string label describing the kind of problem found while verifying a media bucket.
+type MediaProblemType string
+
+// MEDIA_MISSING indicates that a media file expected for a WOF record is not present in the media bucket.
+const MEDIA_MISSING MediaProblemType = "missing"
+
+// MEDIA_EMPTY indicates that a media file expected for a WOF record is present in the media bucket but is empty.
+const MEDIA_EMPTY MediaProblemType = "empty"
+
+// MEDIA_HASH_MISMATCH indicates that the hash of the original media file in the media bucket does not match the
+// hash recorded in the WOF record.
+const MEDIA_HASH_MISMATCH MediaProblemType = "hash_mismatch"
+
+// MEDIA_ORPHANED indicates that a media file in the media bucket does not belong to any WOF record.
+const MEDIA_ORPHANED MediaProblemType = "orphaned"
+
+// MediaProblem is a struct describing a single problem found while verifying a media bucket.
+type MediaProblem struct {
+	// Type is the kind of problem.
+	Type MediaProblemType `json:"type"`
+	// Key is the key of the media file in the media bucket.
+	Key string `json:"key"`
+	// MediaId is the media ID the media file is (or is expected to be) associated with.
+	MediaId string `json:"media_id"`
+	// WOFId is the WOF ID of the record the media file is expected for. It is zero for `MEDIA_ORPHANED` problems.
+	WOFId int64 `json:"wof_id,omitempty"`
+	// Path is the path (in the data repository) of the record the media file is expected for.
+	Path string `json:"path,omitempty"`
+	// Expected is the hash recorded in the WOF record, for `MEDIA_HASH_MISMATCH` problems.
+	Expected string `json:"expected,omitempty"`
+	// Actual is the hash of the media file in the media bucket, for `MEDIA_HASH_MISMATCH` problems.
+	Actual string `json:"actual,omitempty"`
+	// Distance is the Hamming distance between perceptual hashes, for `MEDIA_HASH_MISMATCH` problems.
+	Distance int `json:"distance,omitempty"`
+}
+
+// VerifyMediaOptions is a struct containing configuration options for the `VerifyMedia` method.
+type VerifyMediaOptions struct {
+	// Bucket is the gocloud.dev/blob bucket containing media files named using the `DerivativeKey` scheme.
+	Bucket *blob.Bucket
+	// IteratorURI is a valid whosonfirst/go-whosonfirst-iterate/v2 URI.
+	IteratorURI string
+	// IteratorSource is the URI (or path) of the data repository to iterate.
+	IteratorSource string
+	// CheckHashes is a boolean flag indicating that original media files should be read and their hashes compared
+	// to those recorded in each WOF record.
+	CheckHashes bool
+	// HashThreshold is the maximum Hamming distance between perceptual hashes before they are considered a mismatch.
+	HashThreshold int
+	// Limiter is an optional `Limiter` instance used to limit reads from Bucket.
+	Limiter *Limiter
+}
+
+// MediaVerification is a thread-safe collection of problems found while verifying a media bucket.
+type MediaVerification struct {
+	mu       *sync.RWMutex
+	checked  int
+	problems []*MediaProblem
+}
+
+// NewMediaVerification returns a new (empty) `MediaVerification` instance.
+func NewMediaVerification() *MediaVerification {
+
+	v := &MediaVerification{
+		mu:       new(sync.RWMutex),
+		problems: make([]*MediaProblem, 0),
+	}
+
+	return v
+}
+
+// Add adds 'p' to 'v'.
+func (v *MediaVerification) Add(p *MediaProblem) {
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.problems = append(v.problems, p)
+}
+
+// Checked returns the number of media files that were checked.
+func (v *MediaVerification) Checked() int {
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return v.checked
+}
+
+// Problems returns the list of problems recorded in 'v' sorted by type and key.
+func (v *MediaVerification) Problems() []*MediaProblem {
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	problems := make([]*MediaProblem, len(v.problems))
+	copy(problems, v.problems)
+
+	sort.Slice(problems, func(i, j int) bool {
+
+		if problems[i].Type != problems[j].Type {
+			return problems[i].Type < problems[j].Type
+		}
+
+		return problems[i].Key < problems[j].Key
+	})
+
+	return problems
+}
+
+// WriteJSONLines writes each `MediaProblem` in 'v' to 'wr' as a line-separated JSON record.
+func (v *MediaVerification) WriteJSONLines(wr io.Writer) error {
+
+	enc := json.NewEncoder(wr)
+
+	for _, p := range v.Problems() {
+
+		err := enc.Encode(p)
+
+		if err != nil {
+			return fmt.Errorf("Failed to encode problem, %w", err)
+		}
+	}
+
+	return nil
+}
+
+// WriteSummary writes a table summarizing the number of problems, by type, in 'v' to 'wr'.
+func (v *MediaVerification) WriteSummary(wr io.Writer) error {
+
+	types := []MediaProblemType{MEDIA_MISSING, MEDIA_EMPTY, MEDIA_HASH_MISMATCH, MEDIA_ORPHANED}
+	counts := make(map[MediaProblemType]int)
+
+	for _, p := range v.Problems() {
+		counts[p.Type] += 1
+	}
+
+	tw := tabwriter.NewWriter(wr, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "PROBLEM\tCOUNT\n")
+
+	total := 0
+
+	for _, t := range types {
+		fmt.Fprintf(tw, "%s\t%d\n", t, counts[t])
+		total += counts[t]
+	}
+
+	fmt.Fprintf(tw, "total\t%d\n", total)
+	fmt.Fprintf(tw, "checked\t%d\n", v.Checked())
+
+	return tw.Flush()
+}
+
+// VerifyMedia checks that the original media file (and any derivatives listed in the `instagram:sizes` property, as
+// well as the media files of carousel slides listed in the `instagram:slide_sizes` property) for every record in
+// the data repository defined in 'opts' exists, is not empty and (optionally) matches the hash recorded in the
+// record. It then lists the media bucket and reports any media files whose media ID does not belong to a record.
+func VerifyMedia(ctx context.Context, opts *VerifyMediaOptions) (*MediaVerification, error) {
+
+	v := NewMediaVerification()
+
+	known := new(sync.Map)
+
+	iter_cb := func(ctx context.Context, path string, fh io.ReadSeeker, args ...interface{}) error {
+
+		body, err := io.ReadAll(fh)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read %s, %w", path, err)
+		}
+
+		wof_id := gjson.GetBytes(body, "properties.wof:id").Int()
+		media_id := gjson.GetBytes(body, "properties.instagram:post.media_id").String()
+
+		if media_id == "" {
+			slog.Warn("Record is missing instagram:post.media_id property, skipping", "path", path)
+			return nil
+		}
+
+		known.Store(media_id, true)
+
+		slide_ids, err := DeriveSlideMediaIds(body, "properties.instagram:post")
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive slide media IDs for %s, %w", path, err)
+		}
+
+		for _, id := range slide_ids {
+			known.Store(id, true)
+		}
+
+		// Originals are assumed to be JPEG files unless the record says otherwise
+
+		original_ext := "jpg"
+
+		mime_type := gjson.GetBytes(body, "properties.instagram:post.mime_type").String()
+
+		if ext, ok := extensions[mime_type]; ok {
+			original_ext = ext
+		}
+
+		type mediaFile struct {
+			media_id string
+			label    string
+			ext      string
+		}
+
+		files := []*mediaFile{
+			{media_id, SIZE_ORIGINAL, original_ext},
+		}
+
+		for label, sz := range gjson.GetBytes(body, "properties."+SIZES_PROPERTY).Map() {
+
+			if label != SIZE_ORIGINAL {
+				files = append(files, &mediaFile{media_id, label, sz.Get("extension").String()})
+			}
+		}
+
+		for slide_id, slide_sizes := range gjson.GetBytes(body, "properties."+SLIDE_SIZES_PROPERTY).Map() {
+
+			known.Store(slide_id, true)
+
+			for label, sz := range slide_sizes.Map() {
+				files = append(files, &mediaFile{slide_id, label, sz.Get("extension").String()})
+			}
+		}
+
+		for _, f := range files {
+
+			p := &MediaProblem{
+				Key:     DerivativeKey(f.media_id, f.label, f.ext),
+				MediaId: f.media_id,
+				WOFId:   wof_id,
+				Path:    path,
+			}
+
+			ok, err := verifyMediaFile(ctx, opts, v, p)
+
+			if err != nil {
+				return err
+			}
+
+			// Only the post's own original is compared to the hash recorded in the record
+
+			if !ok || f.media_id != media_id || f.label != SIZE_ORIGINAL || !opts.CheckHashes {
+				continue
+			}
+
+			err = verifyMediaHash(ctx, opts, v, p, body)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	iter, err := iterator.NewIterator(ctx, opts.IteratorURI, iter_cb)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create iterator, %w", err)
+	}
+
+	err = iter.IterateURIs(ctx, opts.IteratorSource)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to iterate %s, %w", opts.IteratorSource, err)
+	}
+
+	list_iter := opts.Bucket.List(nil)
+
+	for {
+
+		obj, err := list_iter.Next(ctx)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list media bucket, %w", err)
+		}
+
+		if obj.IsDir {
+			continue
+		}
+
+		media_id := strings.SplitN(obj.Key, "/", 2)[0]
+
+		_, ok := known.Load(media_id)
+
+		if !ok {
+
+			v.Add(&MediaProblem{
+				Type:    MEDIA_ORPHANED,
+				Key:     obj.Key,
+				MediaId: media_id,
+			})
+		}
+	}
+
+	return v, nil
+}
+
+// verifyMediaFile checks that the media file defined by 'p.Key' exists and is not empty, recording a problem in 'v'
+// if it does not. It returns a boolean value indicating whether the file exists and is not empty.
+func verifyMediaFile(ctx context.Context, opts *VerifyMediaOptions, v *MediaVerification, p *MediaProblem) (bool, error) {
+
+	v.mu.Lock()
+	v.checked += 1
+	v.mu.Unlock()
+
+	release, err := opts.Limiter.Acquire(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	attrs, err := opts.Bucket.Attributes(ctx, p.Key)
+
+	release()
+
+	switch {
+	case gcerrors.Code(err) == gcerrors.NotFound:
+		p.Type = MEDIA_MISSING
+	case err != nil:
+		return false, fmt.Errorf("Failed to retrieve attributes for %s, %w", p.Key, err)
+	case attrs.Size == 0:
+		p.Type = MEDIA_EMPTY
+	default:
+		return true, nil
+	}
+
+	v.Add(p)
+	return false, nil
+}
+
+// verifyMediaHash compares the hash of the media file defined by 'p.Key' to the perceptual (or file) hash recorded
+// in the WOF record 'body', recording a problem in 'v' if they do not match. Records without a hash are not checked.
+func verifyMediaHash(ctx context.Context, opts *VerifyMediaOptions, v *MediaVerification, p *MediaProblem, body []byte) error {
+
+	phash_rsp := gjson.GetBytes(body, "properties.instagram:post.perceptual_hash")
+	fhash_rsp := gjson.GetBytes(body, "properties.instagram:post.file_hash")
+
+	if !phash_rsp.Exists() && !fhash_rsp.Exists() {
+		return nil
+	}
+
+	release, err := opts.Limiter.Acquire(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	r, err := opts.Bucket.NewReader(ctx, p.Key, nil)
+
+	if err != nil {
+		return fmt.Errorf("Failed to open %s, %w", p.Key, err)
+	}
+
+	defer r.Close()
+
+	if phash_rsp.Exists() {
+
+		actual, err := hash.PerceptualHash(r)
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive perceptual hash for %s, %w", p.Key, err)
+		}
+
+		distance, ok, err := PerceptualHashDistance(phash_rsp.String(), actual)
+
+		if err != nil {
+			return fmt.Errorf("Failed to compare perceptual hashes for %s, %w", p.Key, err)
+		}
+
+		if !ok || distance > opts.HashThreshold {
+			p.Type = MEDIA_HASH_MISMATCH
+			p.Expected = phash_rsp.String()
+			p.Actual = actual
+			p.Distance = distance
+			v.Add(p)
+		}
+
+		return nil
+	}
+
+	actual, err := hash.FileHash(r)
+
+	if err != nil {
+		return fmt.Errorf("Failed to derive file hash for %s, %w", p.Key, err)
+	}
+
+	if actual != fhash_rsp.String() {
+		p.Type = MEDIA_HASH_MISMATCH
+		p.Expected = fhash_rsp.String()
+		p.Actual = actual
+		v.Add(p)
+	}
+
+	return nil
+}
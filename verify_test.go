@@ -0,0 +1,112 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sfomuseum/go-sfomuseum-instagram/hash"
+	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/fileblob"
+)
+
+func TestVerifyMedia(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo := t.TempDir()
+
+	bucket, err := blob.OpenBucket(ctx, "file://"+t.TempDir())
+
+	if err != nil {
+		t.Fatalf("Failed to open bucket, %v", err)
+	}
+
+	defer bucket.Close()
+
+	im := image.NewRGBA(image.Rect(0, 0, 64, 64))
+
+	for x := 0; x < 64; x++ {
+		im.Set(x, x, color.RGBA{255, 255, 255, 255})
+	}
+
+	var buf bytes.Buffer
+
+	err = jpeg.Encode(&buf, im, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to encode image, %v", err)
+	}
+
+	phash, err := hash.PerceptualHash(bytes.NewReader(buf.Bytes()))
+
+	if err != nil {
+		t.Fatalf("Failed to derive perceptual hash, %v", err)
+	}
+
+	// a: OK, b: missing, c: hash mismatch, d: orphaned
+
+	records := map[string]string{
+		"a": phash,
+		"b": phash,
+		"c": "p:0000000000000000",
+	}
+
+	for media_id, p := range records {
+
+		body := fmt.Sprintf(`{"type":"Feature","properties":{"wof:id":1,"instagram:post":{"media_id":"%s","perceptual_hash":"%s"}}}`, media_id, p)
+
+		err := os.WriteFile(filepath.Join(repo, media_id+".geojson"), []byte(body), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write record, %v", err)
+		}
+	}
+
+	for _, media_id := range []string{"a", "c", "d"} {
+
+		err := bucket.WriteAll(ctx, DerivativeKey(media_id, SIZE_ORIGINAL, "jpg"), buf.Bytes(), nil)
+
+		if err != nil {
+			t.Fatalf("Failed to write media, %v", err)
+		}
+	}
+
+	opts := &VerifyMediaOptions{
+		Bucket:         bucket,
+		IteratorURI:    "directory://",
+		IteratorSource: repo,
+		CheckHashes:    true,
+	}
+
+	v, err := VerifyMedia(ctx, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to verify media, %v", err)
+	}
+
+	expected := map[MediaProblemType]string{
+		MEDIA_MISSING:       "b",
+		MEDIA_HASH_MISMATCH: "c",
+		MEDIA_ORPHANED:      "d",
+	}
+
+	problems := v.Problems()
+
+	if len(problems) != len(expected) {
+		t.Fatalf("Unexpected number of problems: %d", len(problems))
+	}
+
+	for _, p := range problems {
+
+		if expected[p.Type] != p.MediaId {
+			t.Fatalf("Unexpected %s problem for %s", p.Type, p.MediaId)
+		}
+	}
+}
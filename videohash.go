@@ -0,0 +1,221 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/corona10/goimagehash"
+	"github.com/sfomuseum/go-sfomuseum-instagram-publish/mp4"
+)
+
+// DEFAULT_VIDEO_HASH_FRAMES is the default number of keyframes used to derive a video perceptual hash.
+const DEFAULT_VIDEO_HASH_FRAMES int = 3
+
+// ErrUnsupportedCodec is returned by `VideoFrameDecoder` implementations (and `VideoPerceptualHash`) when
+// the frames of a video track can not be decoded.
+var ErrUnsupportedCodec = errors.New("Unsupported video codec")
+
+// jpeg_codecs is the list of sample entry codes for (Motion) JPEG video tracks, whose frames can be decoded natively.
+var jpeg_codecs = map[string]bool{
+	"jpeg": true,
+	"mjpa": true,
+}
+
+// VideoFrameDecoder is an interface for decoding individual (key) frames of a video track in to images.
+type VideoFrameDecoder interface {
+	// Decode decodes 'sample', a keyframe read from 'track', in to an image. It returns `ErrUnsupportedCodec`
+	// if the track's codec is not supported.
+	Decode(context.Context, *mp4.Track, []byte) (image.Image, error)
+}
+
+// FFmpegFrameDecoder implements the `VideoFrameDecoder` interface for H.264 video tracks by handing individual
+// keyframes (but not the video file itself) to an external ffmpeg binary.
+type FFmpegFrameDecoder struct {
+	path string
+}
+
+// NewFFmpegFrameDecoder returns a new `FFmpegFrameDecoder` instance for the ffmpeg binary at 'path'.
+func NewFFmpegFrameDecoder(path string) *FFmpegFrameDecoder {
+
+	d := &FFmpegFrameDecoder{
+		path: path,
+	}
+
+	return d
+}
+
+// Decode decodes 'sample', an H.264 keyframe read from 'track', in to an image.
+func (d *FFmpegFrameDecoder) Decode(ctx context.Context, track *mp4.Track, sample []byte) (image.Image, error) {
+
+	if !track.IsAVC() {
+		return nil, fmt.Errorf("%w (%s)", ErrUnsupportedCodec, track.Codec)
+	}
+
+	stream, err := track.AnnexB(sample)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive H.264 stream, %w", err)
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, d.path,
+		"-hide_banner", "-loglevel", "error",
+		"-f", "h264", "-i", "pipe:0",
+		"-frames:v", "1", "-f", "image2pipe", "-vcodec", "png", "pipe:1")
+
+	cmd.Stdin = bytes.NewReader(stream)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode frame with ffmpeg, %w (%s)", err, strings.TrimSpace(stderr.String()))
+	}
+
+	im, err := png.Decode(&stdout)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode ffmpeg output, %w", err)
+	}
+
+	return im, nil
+}
+
+// ReadSeekerAt is an interface for readers that support both seeking and random access, for example `*bytes.Reader` or `*os.File`.
+type ReadSeekerAt interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// VideoPerceptualHash derives a perceptual hash (fingerprint) for the MP4 (or QuickTime) video in 'r'. The container
+// is parsed in pure Go and up to 'frames' keyframes, distributed evenly across the duration of the video, are decoded
+// using 'decoder' and perceptually hashed. (Motion) JPEG frames are always decoded natively; 'decoder' may be nil in
+// which case `ErrUnsupportedCodec` is returned for all other codecs. The result is a comma-separated list of per-frame
+// hashes (as produced by `goimagehash.ImageHash.ToString`) which can be compared using `PerceptualHashDistance`.
+func VideoPerceptualHash(ctx context.Context, r ReadSeekerAt, decoder VideoFrameDecoder, frames int) (string, error) {
+
+	mov, err := mp4.Parse(r)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse video, %w", err)
+	}
+
+	track, err := mov.VideoTrack()
+
+	if err != nil {
+		return "", err
+	}
+
+	keyframes := mp4.SelectKeyframes(track, frames)
+
+	if len(keyframes) == 0 {
+		return "", fmt.Errorf("Video track has no keyframes")
+	}
+
+	hashes := make([]string, len(keyframes))
+
+	for i, s := range keyframes {
+
+		sample, err := track.ReadSample(r, s)
+
+		if err != nil {
+			return "", err
+		}
+
+		var im image.Image
+
+		switch {
+		case jpeg_codecs[track.Codec]:
+			im, _, err = image.Decode(bytes.NewReader(sample))
+		case decoder != nil:
+			im, err = decoder.Decode(ctx, track, sample)
+		default:
+			err = fmt.Errorf("%w (%s)", ErrUnsupportedCodec, track.Codec)
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to decode sample %d, %w", s.Number, err)
+		}
+
+		p_hash, err := goimagehash.PerceptionHash(im)
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to derive perceptual hash for sample %d, %w", s.Number, err)
+		}
+
+		hashes[i] = p_hash.ToString()
+	}
+
+	return strings.Join(hashes, ","), nil
+}
+
+// PerceptualHashDistance returns the Hamming distance between 'a' and 'b' which may be image perceptual hashes
+// or video perceptual hashes (as produced by `VideoPerceptualHash`). The distance between two video hashes is the
+// largest distance between any of their corresponding frames. The boolean value indicates whether the two hashes
+// are comparable; hashes with a different number of frames are not.
+func PerceptualHashDistance(a string, b string) (int, bool, error) {
+
+	hashes_a, err := parsePerceptualHashes(a)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	hashes_b, err := parsePerceptualHashes(b)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	if len(hashes_a) != len(hashes_b) {
+		return 0, false, nil
+	}
+
+	max_distance := 0
+
+	for i, h := range hashes_a {
+
+		d, err := h.Distance(hashes_b[i])
+
+		if err != nil {
+			return 0, false, fmt.Errorf("Failed to derive distance between '%s' and '%s', %w", a, b, err)
+		}
+
+		if d > max_distance {
+			max_distance = d
+		}
+	}
+
+	return max_distance, true, nil
+}
+
+// parsePerceptualHashes parses a comma-separated list of perceptual hashes. Image perceptual hashes are
+// treated as a list of one.
+func parsePerceptualHashes(str_hash string) ([]*goimagehash.ImageHash, error) {
+
+	parts := strings.Split(str_hash, ",")
+	hashes := make([]*goimagehash.ImageHash, len(parts))
+
+	for i, p := range parts {
+
+		h, err := goimagehash.ImageHashFromString(p)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse perceptual hash '%s', %w", p, err)
+		}
+
+		hashes[i] = h
+	}
+
+	return hashes, nil
+}
@@ -0,0 +1,364 @@
+// package zipblob provides a read-only gocloud.dev/blob driver for files contained in a ZIP archive, for example
+// an Instagram export bundle, so that they can be read without first extracting the archive. Importing this package
+// registers the "zip" scheme with `blob.DefaultURLMux`. For example:
+//
+//	import (
+//		"gocloud.dev/blob"
+//		_ "github.com/sfomuseum/go-sfomuseum-instagram-publish/zipblob"
+//	)
+//
+//	bucket, err := blob.OpenBucket(ctx, "zip:///usr/local/data/instagram-sfomuseum-2024-11-27-p55zxMWB.zip")
+package zipblob
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+)
+
+// Scheme is the URL scheme zipblob registers its URLOpener under on blob.DefaultURLMux.
+const Scheme = "zip"
+
+const defaultPageSize = 1000
+
+var errReadOnly = errors.New("zipblob buckets are read-only")
+
+func init() {
+	blob.DefaultURLMux().RegisterBucket(Scheme, &URLOpener{})
+}
+
+// URLOpener opens ZIP archive bucket URLs like "zip:///path/to/archive.zip".
+//
+// The following query parameters are supported:
+//
+//   - prefix: an optional directory inside the archive to treat as the root of the bucket. This is useful for
+//     archives whose contents are nested inside a single top-level directory.
+type URLOpener struct{}
+
+// OpenBucketURL opens a blob.Bucket based on u.
+func (o *URLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
+
+	q := u.Query()
+	prefix := q.Get("prefix")
+
+	return OpenBucket(u.Path, prefix)
+}
+
+// OpenBucket returns a read-only *blob.Bucket backed by the ZIP archive at 'zip_path'. If 'prefix' is not empty
+// only files inside that directory are exposed, with keys relative to it.
+func OpenBucket(zip_path string, prefix string) (*blob.Bucket, error) {
+
+	drv, err := openBucket(zip_path, prefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return blob.NewBucket(drv), nil
+}
+
+type bucket struct {
+	zip_r *zip.ReadCloser
+	files map[string]*zip.File
+	keys  []string
+}
+
+func openBucket(zip_path string, prefix string) (*bucket, error) {
+
+	zip_r, err := zip.OpenReader(zip_path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s, %w", zip_path, err)
+	}
+
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+
+	files := make(map[string]*zip.File)
+	keys := make([]string, 0)
+
+	for _, f := range zip_r.File {
+
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		if !strings.HasPrefix(f.Name, prefix) {
+			continue
+		}
+
+		k := strings.TrimPrefix(f.Name, prefix)
+
+		files[k] = f
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	b := &bucket{
+		zip_r: zip_r,
+		files: files,
+		keys:  keys,
+	}
+
+	return b, nil
+}
+
+func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return gcerrors.NotFound
+	case errors.Is(err, errReadOnly):
+		return gcerrors.Unimplemented
+	default:
+		return gcerrors.Unknown
+	}
+}
+
+func (b *bucket) As(i interface{}) bool {
+
+	p, ok := i.(**zip.ReadCloser)
+
+	if !ok {
+		return false
+	}
+
+	*p = b.zip_r
+	return true
+}
+
+func (b *bucket) ErrorAs(err error, i interface{}) bool {
+	return errors.As(err, i)
+}
+
+func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+
+	f, err := b.file(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := &driver.Attributes{
+		ContentType: contentType(key),
+		ModTime:     f.Modified,
+		Size:        int64(f.UncompressedSize64),
+	}
+
+	return attrs, nil
+}
+
+func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+
+	page_size := opts.PageSize
+
+	if page_size == 0 {
+		page_size = defaultPageSize
+	}
+
+	start := 0
+
+	if len(opts.PageToken) > 0 {
+
+		last_key := string(opts.PageToken)
+
+		start = sort.Search(len(b.keys), func(i int) bool {
+			return b.keys[i] > last_key
+		})
+	}
+
+	var last_prefix string
+
+	page := &driver.ListPage{
+		Objects: make([]*driver.ListObject, 0),
+	}
+
+	for i := start; i < len(b.keys); i++ {
+
+		k := b.keys[i]
+
+		if !strings.HasPrefix(k, opts.Prefix) {
+			continue
+		}
+
+		// Collapse keys into pseudo-directories, as other drivers do, if there is a delimiter
+
+		if opts.Delimiter != "" {
+
+			rel_key := strings.TrimPrefix(k, opts.Prefix)
+			idx := strings.Index(rel_key, opts.Delimiter)
+
+			if idx > -1 {
+
+				dir_key := opts.Prefix + rel_key[:idx+len(opts.Delimiter)]
+
+				if dir_key == last_prefix {
+					continue
+				}
+
+				if len(page.Objects) == page_size {
+					page.NextPageToken = []byte(page.Objects[len(page.Objects)-1].Key)
+					break
+				}
+
+				last_prefix = dir_key
+
+				obj := &driver.ListObject{
+					Key:   dir_key,
+					IsDir: true,
+				}
+
+				page.Objects = append(page.Objects, obj)
+				continue
+			}
+		}
+
+		if len(page.Objects) == page_size {
+			page.NextPageToken = []byte(page.Objects[len(page.Objects)-1].Key)
+			break
+		}
+
+		f := b.files[k]
+
+		obj := &driver.ListObject{
+			Key:     k,
+			ModTime: f.Modified,
+			Size:    int64(f.UncompressedSize64),
+		}
+
+		page.Objects = append(page.Objects, obj)
+	}
+
+	// Tokens for pseudo-directories need to skip all the keys they contain
+
+	if len(page.NextPageToken) > 0 && page.Objects[len(page.Objects)-1].IsDir {
+		page.NextPageToken = append(page.NextPageToken, 0xff)
+	}
+
+	return page, nil
+}
+
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset int64, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+
+	f, err := b.file(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := f.Open()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s, %w", key, err)
+	}
+
+	// Compressed files aren't seekable so discard everything before offset
+
+	if offset > 0 {
+
+		_, err := io.CopyN(io.Discard, rc, offset)
+
+		if err != nil && !errors.Is(err, io.EOF) {
+			rc.Close()
+			return nil, fmt.Errorf("Failed to seek to offset %d in %s, %w", offset, key, err)
+		}
+	}
+
+	var r io.Reader = rc
+
+	if length >= 0 {
+		r = io.LimitReader(rc, length)
+	}
+
+	attrs := &driver.ReaderAttributes{
+		ContentType: contentType(key),
+		ModTime:     f.Modified,
+		Size:        int64(f.UncompressedSize64),
+	}
+
+	zr := &reader{
+		r:     r,
+		c:     rc,
+		attrs: attrs,
+	}
+
+	return zr, nil
+}
+
+func (b *bucket) NewTypedWriter(ctx context.Context, key string, content_type string, opts *driver.WriterOptions) (driver.Writer, error) {
+	return nil, errReadOnly
+}
+
+func (b *bucket) Copy(ctx context.Context, dst_key string, src_key string, opts *driver.CopyOptions) error {
+	return errReadOnly
+}
+
+func (b *bucket) Delete(ctx context.Context, key string) error {
+	return errReadOnly
+}
+
+func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return "", errReadOnly
+}
+
+func (b *bucket) Close() error {
+	return b.zip_r.Close()
+}
+
+func (b *bucket) file(key string) (*zip.File, error) {
+
+	f, ok := b.files[key]
+
+	if !ok {
+		return nil, fmt.Errorf("%s, %w", key, fs.ErrNotExist)
+	}
+
+	return f, nil
+}
+
+type reader struct {
+	r     io.Reader
+	c     io.Closer
+	attrs *driver.ReaderAttributes
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r *reader) Close() error {
+	return r.c.Close()
+}
+
+func (r *reader) Attributes() *driver.ReaderAttributes {
+	return r.attrs
+}
+
+func (r *reader) As(i interface{}) bool {
+	return false
+}
+
+func contentType(key string) string {
+
+	t := mime.TypeByExtension(path.Ext(key))
+
+	if t == "" {
+		t = "application/octet-stream"
+	}
+
+	return t
+}
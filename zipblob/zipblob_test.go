@@ -0,0 +1,169 @@
+package zipblob
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestZipBucket(t *testing.T) {
+
+	ctx := context.Background()
+
+	zip_path := filepath.Join(t.TempDir(), "instagram-sfomuseum-2024-11-27-p55zxMWB.zip")
+
+	files := map[string]string{
+		"your_instagram_activity/content/posts_1.json": "[]",
+		"media/posts/202411/a.jpg":                     "aaaaaaaaaa",
+		"media/posts/202411/b.jpg":                     "bbbbbbbbbb",
+	}
+
+	fh, err := os.Create(zip_path)
+
+	if err != nil {
+		t.Fatalf("Failed to create %s, %v", zip_path, err)
+	}
+
+	zip_wr := zip.NewWriter(fh)
+
+	for name, body := range files {
+
+		wr, err := zip_wr.Create(name)
+
+		if err != nil {
+			t.Fatalf("Failed to create %s, %v", name, err)
+		}
+
+		_, err = wr.Write([]byte(body))
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", name, err)
+		}
+	}
+
+	zip_wr.Close()
+	fh.Close()
+
+	bucket, err := blob.OpenBucket(ctx, fmt.Sprintf("zip://%s", zip_path))
+
+	if err != nil {
+		t.Fatalf("Failed to open bucket, %v", err)
+	}
+
+	defer bucket.Close()
+
+	r, err := bucket.NewRangeReader(ctx, "media/posts/202411/b.jpg", 2, 3, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to open reader, %v", err)
+	}
+
+	body, err := io.ReadAll(r)
+	r.Close()
+
+	if err != nil {
+		t.Fatalf("Failed to read, %v", err)
+	}
+
+	if string(body) != "bbb" {
+		t.Fatalf("Unexpected body '%s'", string(body))
+	}
+
+	_, err = bucket.NewReader(ctx, "media/posts/202411/c.jpg", nil)
+
+	if gcerrors.Code(err) != gcerrors.NotFound {
+		t.Fatalf("Expected NotFound error, got %v", err)
+	}
+
+	list_opts := &blob.ListOptions{
+		Prefix:    "media/",
+		Delimiter: "/",
+	}
+
+	iter := bucket.List(list_opts)
+
+	obj, err := iter.Next(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to list bucket, %v", err)
+	}
+
+	if obj.Key != "media/posts/" || !obj.IsDir {
+		t.Fatalf("Unexpected list object, %s", obj.Key)
+	}
+
+	_, err = iter.Next(ctx)
+
+	if err != io.EOF {
+		t.Fatalf("Expected a single list object, %v", err)
+	}
+
+	err = bucket.WriteAll(ctx, "test.txt", []byte("test"), nil)
+
+	if gcerrors.Code(err) != gcerrors.Unimplemented {
+		t.Fatalf("Expected Unimplemented error, got %v", err)
+	}
+}
+
+func TestZipBucketURI(t *testing.T) {
+
+	ctx := context.Background()
+
+	// Export paths may contain characters that need to be escaped in a URI
+
+	zip_path := filepath.Join(t.TempDir(), "instagram sfomuseum #1 ?100%.zip")
+
+	fh, err := os.Create(zip_path)
+
+	if err != nil {
+		t.Fatalf("Failed to create %s, %v", zip_path, err)
+	}
+
+	zip_wr := zip.NewWriter(fh)
+
+	wr, err := zip_wr.Create("media/posts/a.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to create media/posts/a.jpg, %v", err)
+	}
+
+	_, err = wr.Write([]byte("aaaaaaaaaa"))
+
+	if err != nil {
+		t.Fatalf("Failed to write media/posts/a.jpg, %v", err)
+	}
+
+	zip_wr.Close()
+	fh.Close()
+
+	u := url.URL{
+		Scheme: Scheme,
+		Path:   zip_path,
+	}
+
+	bucket, err := blob.OpenBucket(ctx, u.String())
+
+	if err != nil {
+		t.Fatalf("Failed to open bucket, %v", err)
+	}
+
+	defer bucket.Close()
+
+	body, err := bucket.ReadAll(ctx, "media/posts/a.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to read, %v", err)
+	}
+
+	if string(body) != "aaaaaaaaaa" {
+		t.Fatalf("Unexpected body '%s'", string(body))
+	}
+}